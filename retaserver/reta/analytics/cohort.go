@@ -0,0 +1,262 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"html"
+	"sort"
+	"strconv"
+	"time"
+
+	"reta/db"
+)
+
+//Cohort retention matrix
+//
+//Players are grouped by install day, the day of their first event. Players with an event before the date range
+//installed earlier and are left out, so a returning player is never counted as a new install. Day-N retention of
+//a cohort is the percentage of its players with at least one event N days after the install day (day 0 is
+//always 100%). Days after the end date cannot be observed yet, so the matrix is a triangle.
+
+type CohortMatrix struct {
+	Begin    time.Time
+	End      time.Time
+	Version  string      //App version of the players, empty for every version
+	Days     int         //Last day shown
+	Dates    []time.Time //Install day of each cohort
+	Players  []int       //Players of each cohort
+	Retained [][]int     //Players of each cohort active on each day, only the observable days
+	Versions []string    //Every app version in the date range
+	Earlier  int         //Players of the date range who installed before it
+}
+
+//Calendar day of the date
+func day(date time.Time) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+//Whole days from begin to end
+func daysBetween(begin time.Time, end time.Time) int {
+	return int(end.Sub(begin).Hours() / 24.0)
+}
+
+//Cohort matrix of the events, version of a player is the version of its first event, players of earlier are left out
+func BuildCohorts(events []db.Event, earlier map[string]bool, begin time.Time, end time.Time, version string, days int) CohortMatrix {
+	matrix := CohortMatrix{Begin: day(begin), End: day(end), Version: version, Days: days}
+
+	//First event and active days of every player, events are ordered by date
+	installs := make(map[string]time.Time)
+	versions := make(map[string]string)
+	active := make(map[string]map[int]bool)
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if !seen[event.Version] {
+			seen[event.Version] = true
+			matrix.Versions = append(matrix.Versions, event.Version)
+		}
+
+		install, ok := installs[event.Player]
+		if !ok || event.Date.Before(install) {
+			installs[event.Player] = event.Date
+			versions[event.Player] = event.Version
+		}
+	}
+	sort.Strings(matrix.Versions)
+
+	for _, event := range events {
+		offset := daysBetween(day(installs[event.Player]), day(event.Date))
+		if active[event.Player] == nil {
+			active[event.Player] = make(map[int]bool)
+		}
+		active[event.Player][offset] = true
+	}
+
+	//Cohort of every install day with at least one player
+	index := make(map[time.Time]int)
+	var players []string
+	for player := range installs {
+		if earlier[player] {
+			matrix.Earlier++
+			continue
+		}
+
+		if version == "" || versions[player] == version {
+			players = append(players, player)
+		}
+	}
+	sort.Strings(players)
+
+	for _, player := range players {
+		install := day(installs[player])
+		if _, ok := index[install]; !ok {
+			index[install] = len(matrix.Dates)
+			matrix.Dates = append(matrix.Dates, install)
+		}
+	}
+	sort.Sort(byDate(matrix.Dates))
+	for c, date := range matrix.Dates {
+		index[date] = c
+	}
+
+	matrix.Players = make([]int, len(matrix.Dates))
+	matrix.Retained = make([][]int, len(matrix.Dates))
+	for c, date := range matrix.Dates {
+		observable := daysBetween(date, matrix.End)
+		if observable > days {
+			observable = days
+		}
+		matrix.Retained[c] = make([]int, observable+1)
+	}
+
+	for _, player := range players {
+		c := index[day(installs[player])]
+		matrix.Players[c]++
+		for offset := range active[player] {
+			if offset < len(matrix.Retained[c]) {
+				matrix.Retained[c][offset]++
+			}
+		}
+	}
+
+	return matrix
+}
+
+type byDate []time.Time
+
+func (b byDate) Len() int           { return len(b) }
+func (b byDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDate) Less(i, j int) bool { return b[i].Before(b[j]) }
+
+//Retention percentage of the cohort on the day, false when the day cannot be observed yet
+func (m CohortMatrix) Retention(cohort int, offset int) (float64, bool) {
+	if offset >= len(m.Retained[cohort]) || m.Players[cohort] == 0 {
+		return 0.0, false
+	}
+
+	return 100.0 * float64(m.Retained[cohort][offset]) / float64(m.Players[cohort]), true
+}
+
+//Retention of every cohort which can be observed on the day, weighted by players
+func (m CohortMatrix) Average(offset int) (float64, bool) {
+	retained, players := 0, 0
+	for c := range m.Dates {
+		if offset < len(m.Retained[c]) {
+			retained += m.Retained[c][offset]
+			players += m.Players[c]
+		}
+	}
+
+	if players == 0 {
+		return 0.0, false
+	}
+
+	return 100.0 * float64(retained) / float64(players), true
+}
+
+func (m CohortMatrix) StringHTML() string {
+	var buffer bytes.Buffer
+
+	if len(m.Dates) == 0 {
+		buffer.WriteString("<div>No player installed in the selected dates</div>")
+		return buffer.String()
+	}
+
+	if m.Earlier > 0 {
+		buffer.WriteString("<div>Players installed before the start date, left out: ")
+		buffer.WriteString(strconv.Itoa(m.Earlier))
+		buffer.WriteString("</div><br/>")
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Install Date</td>")
+	buffer.WriteString("<td>Players</td>")
+	for offset := 0; offset <= m.Days; offset++ {
+		buffer.WriteString("<td>Day ")
+		buffer.WriteString(strconv.Itoa(offset))
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for c, date := range m.Dates {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(date.Format("02/01/2006"))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(m.Players[c]))
+		buffer.WriteString("</td>")
+		for offset := 0; offset <= m.Days; offset++ {
+			retention, ok := m.Retention(c, offset)
+			buffer.WriteString(retentionCell(retention, ok))
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	//Weighted average of the observable cohorts
+	total := 0
+	for _, players := range m.Players {
+		total += players
+	}
+
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>All Cohorts</td>")
+	buffer.WriteString("<td>")
+	buffer.WriteString(strconv.Itoa(total))
+	buffer.WriteString("</td>")
+	for offset := 0; offset <= m.Days; offset++ {
+		retention, ok := m.Average(offset)
+		buffer.WriteString(retentionCell(retention, ok))
+	}
+	buffer.WriteString("</tr>")
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
+
+//Table cell shaded by the retention percentage, empty when not observable
+func retentionCell(retention float64, ok bool) string {
+	if !ok {
+		return "<td></td>"
+	}
+
+	opacity := strconv.FormatFloat(retention/100.0, 'f', 2, 64)
+	return "<td style=\"background-color: rgba(31, 119, 180, " + opacity + ")\">" + html.EscapeString(strconv.FormatFloat(retention, 'f', 1, 64)) + "%</td>"
+}
+
+//Cohort, players and retention percentage of every day, empty when not observable
+func (m CohortMatrix) CSV() ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	header := []string{"Install Date", "Players"}
+	for offset := 0; offset <= m.Days; offset++ {
+		header = append(header, "Day "+strconv.Itoa(offset))
+	}
+	err := writer.Write(header)
+	if err != nil {
+		return nil, err
+	}
+
+	for c, date := range m.Dates {
+		record := []string{date.Format("2006-01-02"), strconv.Itoa(m.Players[c])}
+		for offset := 0; offset <= m.Days; offset++ {
+			retention, ok := m.Retention(c, offset)
+			if ok {
+				record = append(record, strconv.FormatFloat(retention, 'f', 2, 64))
+			} else {
+				record = append(record, "")
+			}
+		}
+
+		err = writer.Write(record)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+
+	return buffer.Bytes(), writer.Error()
+}
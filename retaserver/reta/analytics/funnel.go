@@ -0,0 +1,297 @@
+package analytics
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"reta/db"
+	"reta/errors"
+)
+
+//Conversion funnel of ordered event actions
+//
+//Steps are event or timed event actions separated by "->", e.g.
+//	Tutorial Start -> Tutorial Duration -> Level Duration -> Social Feature Consumed
+//
+//A player enters the funnel with the first event of the first step. Every next step is the first event of its
+//action after the previous step, and it only counts when it happened within the maximum time of the previous
+//step, otherwise the player dropped there. Players are broken down by the app version of their first step.
+
+const DefaultFunnel = "Tutorial Start -> Tutorial Duration -> Level Duration -> Social Feature Consumed"
+
+type FunnelStep struct {
+	Action         string
+	Players        int           //Players reaching the step
+	Conversion     float64       //Percentage of the players entering the funnel
+	StepConversion float64       //Percentage of the players of the previous step
+	DropOff        int           //Players of the previous step not reaching this one
+	MedianTime     time.Duration //From the previous step
+	MedianTotal    time.Duration //From the first step
+}
+
+type Funnel struct {
+	Version string //Empty for every version
+	Steps   []FunnelStep
+}
+
+type FunnelAnalysis struct {
+	Actions  []string
+	MaxGap   time.Duration
+	Overall  Funnel
+	Versions []Funnel //One funnel of each app version
+}
+
+func ParseFunnel(spec string) ([]string, error) {
+	var actions []string
+	for _, part := range strings.Split(strings.Replace(spec, "→", "->", -1), "->") {
+		action := strings.TrimSpace(part)
+		if action == "" {
+			return nil, errors.New("Error: Funnel step cannot be empty")
+		}
+		actions = append(actions, action)
+	}
+
+	if len(actions) < 2 {
+		return nil, errors.New("Error: Funnel needs at least two steps separated by '->'")
+	}
+
+	return actions, nil
+}
+
+//Action and time of an event or timed event
+type playerEvent struct {
+	action  string
+	version string
+	date    time.Time
+}
+
+type byEventDate []playerEvent
+
+func (b byEventDate) Len() int           { return len(b) }
+func (b byEventDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byEventDate) Less(i, j int) bool { return b[i].date.Before(b[j].date) }
+
+//Events and timed events of every player ordered by date
+func playerEvents(events []db.Event, timedevents []db.TimedEvent) map[string][]playerEvent {
+	players := make(map[string][]playerEvent)
+	for _, event := range events {
+		players[event.Player] = append(players[event.Player], playerEvent{action: event.Action, version: event.Version, date: event.Date})
+	}
+	for _, timedevent := range timedevents {
+		event := timedevent.Info
+		players[event.Player] = append(players[event.Player], playerEvent{action: event.Action, version: event.Version, date: event.Date})
+	}
+
+	for player := range players {
+		sort.Stable(byEventDate(players[player]))
+	}
+
+	return players
+}
+
+//Time of reaching every step of the player, shorter than the actions when it dropped
+func funnelPath(events []playerEvent, actions []string, maxGap time.Duration) ([]time.Time, string) {
+	var reached []time.Time
+	version := ""
+	for _, event := range events {
+		step := len(reached)
+		if step == len(actions) {
+			break
+		}
+
+		if step > 0 && event.date.Sub(reached[step-1]) > maxGap {
+			break
+		}
+
+		if event.action == actions[step] {
+			if step == 0 {
+				version = event.version
+			}
+			reached = append(reached, event.date)
+		}
+	}
+
+	return reached, version
+}
+
+func BuildFunnel(events []db.Event, timedevents []db.TimedEvent, actions []string, maxGap time.Duration) FunnelAnalysis {
+	analysis := FunnelAnalysis{Actions: actions, MaxGap: maxGap}
+
+	var overall [][]time.Time
+	versions := make(map[string][][]time.Time)
+	for _, history := range playerEvents(events, timedevents) {
+		reached, version := funnelPath(history, actions, maxGap)
+		if len(reached) == 0 {
+			continue
+		}
+
+		overall = append(overall, reached)
+		versions[version] = append(versions[version], reached)
+	}
+
+	analysis.Overall = funnelSteps("", overall, actions)
+
+	var names []string
+	for version := range versions {
+		names = append(names, version)
+	}
+	sort.Strings(names)
+	for _, version := range names {
+		analysis.Versions = append(analysis.Versions, funnelSteps(version, versions[version], actions))
+	}
+
+	return analysis
+}
+
+//Conversion and median times of every step from the paths of the players
+func funnelSteps(version string, paths [][]time.Time, actions []string) Funnel {
+	funnel := Funnel{Version: version, Steps: make([]FunnelStep, len(actions))}
+
+	for s, action := range actions {
+		step := FunnelStep{Action: action}
+
+		var times, totals []time.Duration
+		for _, path := range paths {
+			if len(path) <= s {
+				continue
+			}
+
+			step.Players++
+			if s > 0 {
+				times = append(times, path[s].Sub(path[s-1]))
+				totals = append(totals, path[s].Sub(path[0]))
+			}
+		}
+
+		entered := len(paths)
+		if entered > 0 {
+			step.Conversion = 100.0 * float64(step.Players) / float64(entered)
+		}
+		if s == 0 {
+			step.StepConversion = 100.0
+		} else {
+			previous := funnel.Steps[s-1].Players
+			step.DropOff = previous - step.Players
+			if previous > 0 {
+				step.StepConversion = 100.0 * float64(step.Players) / float64(previous)
+			}
+		}
+		step.MedianTime = medianDuration(times)
+		step.MedianTotal = medianDuration(totals)
+
+		funnel.Steps[s] = step
+	}
+
+	return funnel
+}
+
+type byDuration []time.Duration
+
+func (b byDuration) Len() int           { return len(b) }
+func (b byDuration) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byDuration) Less(i, j int) bool { return b[i] < b[j] }
+
+func medianDuration(durations []time.Duration) time.Duration {
+	count := len(durations)
+	if count == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, count)
+	copy(sorted, durations)
+	sort.Sort(byDuration(sorted))
+
+	if count%2 == 1 {
+		return sorted[count/2]
+	}
+
+	return (sorted[count/2-1] + sorted[count/2]) / 2
+}
+
+func (f FunnelAnalysis) StringHTML() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<div>Maximum time between steps: ")
+	buffer.WriteString(f.MaxGap.String())
+	buffer.WriteString("</div>")
+
+	buffer.WriteString("<br/><div><h3>All Versions</h3></div>")
+	buffer.WriteString(f.Overall.StringHTML())
+
+	for _, funnel := range f.Versions {
+		buffer.WriteString("<br/><div><h3>Version ")
+		buffer.WriteString(html.EscapeString(funnel.Version))
+		buffer.WriteString("</h3></div>")
+		buffer.WriteString(funnel.StringHTML())
+	}
+
+	return buffer.String()
+}
+
+func (f Funnel) StringHTML() string {
+	var buffer bytes.Buffer
+
+	if len(f.Steps) == 0 || f.Steps[0].Players == 0 {
+		buffer.WriteString("<div>No player entered the funnel</div>")
+		return buffer.String()
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Step</td>")
+	buffer.WriteString("<td>Action</td>")
+	buffer.WriteString("<td>Players</td>")
+	buffer.WriteString("<td>Conversion (%)</td>")
+	buffer.WriteString("<td>Step Conversion (%)</td>")
+	buffer.WriteString("<td>Drop-off</td>")
+	buffer.WriteString("<td>Median Time from Previous</td>")
+	buffer.WriteString("<td>Median Time from First</td>")
+	buffer.WriteString("<td></td>")
+	buffer.WriteString("</tr>")
+
+	for s, step := range f.Steps {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(s + 1))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(step.Action))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(step.Players))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(step.Conversion, 'f', 2, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(step.StepConversion, 'f', 2, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(step.DropOff))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if s > 0 && step.Players > 0 {
+			buffer.WriteString(step.MedianTime.String())
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if s > 0 && step.Players > 0 {
+			buffer.WriteString(step.MedianTotal.String())
+		}
+		buffer.WriteString("</td>")
+
+		//Bar of the conversion
+		buffer.WriteString("<td><div style=\"background-color: #1f77b4; height: 12px; width: ")
+		buffer.WriteString(strconv.FormatFloat(2.0*step.Conversion, 'f', 0, 64))
+		buffer.WriteString("px\"></div></td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
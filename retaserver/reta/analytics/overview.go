@@ -0,0 +1,338 @@
+package analytics
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+
+	"appengine"
+
+	"reta/db"
+)
+
+//Engagement overview from daily rollups
+//
+//Every finished day is aggregated once into a db.DailyRollup with its active players, event counts and
+//sessions, later requests only read the rollups. Days still open (today and yesterday, for events sent late)
+//are rebuilt from the events on every request. Weekly and monthly active users are the distinct players of
+//the last 7 and 30 rollups, so rollups of the 29 days before the range are read as well.
+//
+//Sessions are found by Sessionize from the events of the day only, so they are split at midnight.
+
+const (
+	rollupWindow = 30 //Days of the monthly active users
+	openDays     = 2  //Days after which a rollup is not rebuilt anymore
+)
+
+type OverviewDay struct {
+	Date       time.Time
+	DAU        int
+	WAU        int
+	MAU        int
+	Unseen     int     //Active players without any event in the previous 30 days, new or coming back
+	Stickiness float64 //DAU / MAU in percent
+	Events     int     //Events and timed events
+	Sessions   int
+	SessionAvg float64 //Mean session length in minutes
+}
+
+type Overview struct {
+	Begin           time.Time
+	End             time.Time
+	Days            []OverviewDay
+	Players         int     //Distinct players in the range
+	EventsPerPlayer float64 //Events and timed events of the range per player
+	SessionAvg      float64 //Mean session length of the range in minutes
+	Stickiness      float64 //Mean daily stickiness in percent
+	Rebuilt         int     //Rollups built from events for this request
+}
+
+//Aggregate the events and timed events of one day
+func BuildRollup(date time.Time, events []db.Event, timedevents []db.TimedEvent) db.DailyRollup {
+	rollup := db.DailyRollup{Date: day(date), Events: len(events), TimedEvents: len(timedevents), Built: time.Now()}
+
+	//Sessions of the day, sorted by player
+	for _, session := range Sessionize(events, timedevents, SessionGap) {
+		if len(rollup.Players) == 0 || rollup.Players[len(rollup.Players)-1] != session.Player {
+			rollup.Players = append(rollup.Players, session.Player)
+			rollup.ActivePlayers++
+		}
+
+		rollup.Sessions++
+		rollup.SessionSeconds += session.End.Sub(session.Start).Seconds()
+	}
+
+	return rollup
+}
+
+//Rollups of every day from begin to end, missing and open days are built from the events and stored
+func GetRollups(c appengine.Context, begin time.Time, end time.Time, now time.Time) ([]db.DailyRollup, int, error) {
+	begin, end = day(begin), day(end)
+
+	var stored []db.DailyRollup
+	err := db.GetRollups(c, begin, end, &stored)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byDay := make(map[time.Time]db.DailyRollup)
+	for _, rollup := range stored {
+		byDay[day(rollup.Date)] = rollup
+	}
+
+	var rollups []db.DailyRollup
+	rebuilt := 0
+	for date := begin; !date.After(end); date = date.AddDate(0, 0, 1) {
+		rollup, ok := byDay[date]
+		closed := date.AddDate(0, 0, openDays)
+		if !ok || rollup.Built.Before(closed) {
+			//Events of the day only
+			last := date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+
+			var events []db.Event
+			err = db.GetAllEvents(c, date, last, &events)
+			if err != nil {
+				return nil, rebuilt, err
+			}
+
+			var timedevents []db.TimedEvent
+			err = db.GetAllTimedEvents(c, date, last, &timedevents)
+			if err != nil {
+				return nil, rebuilt, err
+			}
+
+			rollup = BuildRollup(date, events, timedevents)
+			rebuilt++
+
+			//Open days may still get events
+			if now.After(closed) {
+				err = db.PutRollup(c, rollup)
+				if err != nil {
+					return nil, rebuilt, err
+				}
+			}
+		}
+
+		rollups = append(rollups, rollup)
+	}
+
+	return rollups, rebuilt, nil
+}
+
+//Overview of the days from begin to end, rollups must start 29 days before begin
+func ComputeOverview(rollups []db.DailyRollup, begin time.Time, end time.Time) Overview {
+	overview := Overview{Begin: day(begin), End: day(end)}
+
+	inRange := make(map[string]bool)
+	events, sessions, sessionSeconds, stickiness := 0, 0, 0.0, 0.0
+	for r, rollup := range rollups {
+		date := day(rollup.Date)
+		if date.Before(overview.Begin) || date.After(overview.End) {
+			continue
+		}
+
+		today := OverviewDay{Date: date, DAU: rollup.ActivePlayers, Events: rollup.Events + rollup.TimedEvents, Sessions: rollup.Sessions}
+		today.WAU = distinctPlayers(rollups, r, 7)
+		today.MAU = distinctPlayers(rollups, r, rollupWindow)
+
+		//Players not in any earlier rollup of the window
+		earlier := make(map[string]bool)
+		for _, previous := range windowRollups(rollups, r, rollupWindow+1) {
+			if day(previous.Date).Before(date) {
+				for _, player := range previous.Players {
+					earlier[player] = true
+				}
+			}
+		}
+		for _, player := range rollup.Players {
+			if !earlier[player] {
+				today.Unseen++
+			}
+			inRange[player] = true
+		}
+
+		if today.MAU > 0 {
+			today.Stickiness = 100.0 * float64(today.DAU) / float64(today.MAU)
+		}
+		if rollup.Sessions > 0 {
+			today.SessionAvg = rollup.SessionSeconds / float64(rollup.Sessions) / 60.0
+		}
+
+		overview.Days = append(overview.Days, today)
+		events += today.Events
+		sessions += rollup.Sessions
+		sessionSeconds += rollup.SessionSeconds
+		stickiness += today.Stickiness
+	}
+
+	overview.Players = len(inRange)
+	if overview.Players > 0 {
+		overview.EventsPerPlayer = float64(events) / float64(overview.Players)
+	}
+	if sessions > 0 {
+		overview.SessionAvg = sessionSeconds / float64(sessions) / 60.0
+	}
+	if len(overview.Days) > 0 {
+		overview.Stickiness = stickiness / float64(len(overview.Days))
+	}
+
+	return overview
+}
+
+//Rollups of the days up to the index, at most the given number of days before it
+func windowRollups(rollups []db.DailyRollup, index int, days int) []db.DailyRollup {
+	first := day(rollups[index].Date).AddDate(0, 0, -days+1)
+
+	start := index
+	for start > 0 && !day(rollups[start-1].Date).Before(first) {
+		start--
+	}
+
+	return rollups[start : index+1]
+}
+
+func distinctPlayers(rollups []db.DailyRollup, index int, days int) int {
+	players := make(map[string]bool)
+	for _, rollup := range windowRollups(rollups, index, days) {
+		for _, player := range rollup.Players {
+			players[player] = true
+		}
+	}
+
+	return len(players)
+}
+
+func (o Overview) StringHTML() string {
+	var buffer bytes.Buffer
+
+	if len(o.Days) == 0 {
+		buffer.WriteString("<div>No day in the selected dates</div>")
+		return buffer.String()
+	}
+
+	last := o.Days[len(o.Days)-1]
+
+	//Summary of the range
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Players</td>")
+	buffer.WriteString("<td>DAU (last day)</td>")
+	buffer.WriteString("<td>WAU (last day)</td>")
+	buffer.WriteString("<td>MAU (last day)</td>")
+	buffer.WriteString("<td>Mean Stickiness DAU/MAU (%)</td>")
+	buffer.WriteString("<td>Events per Player</td>")
+	buffer.WriteString("<td>Mean Session Length (min)</td>")
+	buffer.WriteString("</tr>")
+	buffer.WriteString("<tr>")
+	for _, val := range []string{
+		strconv.Itoa(o.Players),
+		strconv.Itoa(last.DAU),
+		strconv.Itoa(last.WAU),
+		strconv.Itoa(last.MAU),
+		strconv.FormatFloat(o.Stickiness, 'f', 2, 64),
+		strconv.FormatFloat(o.EventsPerPlayer, 'f', 2, 64),
+		strconv.FormatFloat(o.SessionAvg, 'f', 2, 64),
+	} {
+		buffer.WriteString("<td>")
+		buffer.WriteString(val)
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+	buffer.WriteString("</table>")
+
+	buffer.WriteString(activeUsersSVG(o.Days))
+
+	//Every day
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Date</td>")
+	buffer.WriteString("<td>DAU</td>")
+	buffer.WriteString("<td>WAU</td>")
+	buffer.WriteString("<td>MAU</td>")
+	buffer.WriteString("<td>Unseen in 30 Days</td>")
+	buffer.WriteString("<td>Stickiness (%)</td>")
+	buffer.WriteString("<td>Events</td>")
+	buffer.WriteString("<td>Sessions</td>")
+	buffer.WriteString("<td>Mean Session Length (min)</td>")
+	buffer.WriteString("</tr>")
+
+	for _, today := range o.Days {
+		buffer.WriteString("<tr>")
+		for _, val := range []string{
+			today.Date.Format("02/01/2006"),
+			strconv.Itoa(today.DAU),
+			strconv.Itoa(today.WAU),
+			strconv.Itoa(today.MAU),
+			strconv.Itoa(today.Unseen),
+			strconv.FormatFloat(today.Stickiness, 'f', 2, 64),
+			strconv.Itoa(today.Events),
+			strconv.Itoa(today.Sessions),
+			strconv.FormatFloat(today.SessionAvg, 'f', 2, 64),
+		} {
+			buffer.WriteString("<td>")
+			buffer.WriteString(val)
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
+
+//Line chart of daily, weekly and monthly active users as inline SVG
+func activeUsersSVG(days []OverviewDay) string {
+	width, height := 600.0, 200.0
+
+	maximum := 1
+	for _, today := range days {
+		if today.MAU > maximum {
+			maximum = today.MAU
+		}
+	}
+
+	x := func(i int) string {
+		if len(days) == 1 {
+			return strconv.FormatFloat(width/2.0, 'f', 1, 64)
+		}
+		return strconv.FormatFloat(40.0+float64(i)/float64(len(days)-1)*(width-50.0), 'f', 1, 64)
+	}
+	y := func(val int) string {
+		return strconv.FormatFloat(10.0+(1.0-float64(val)/float64(maximum))*(height-30.0), 'f', 1, 64)
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"")
+	buffer.WriteString(strconv.FormatFloat(width+80.0, 'f', 0, 64))
+	buffer.WriteString("\" height=\"")
+	buffer.WriteString(strconv.FormatFloat(height, 'f', 0, 64))
+	buffer.WriteString("\" font-size=\"11\">")
+	buffer.WriteString("<text x=\"0\" y=\"" + y(maximum) + "\">" + strconv.Itoa(maximum) + "</text>")
+	buffer.WriteString("<text x=\"0\" y=\"" + y(0) + "\">0</text>")
+
+	series := []struct {
+		name  string
+		color string
+		value func(OverviewDay) int
+	}{
+		{"DAU", "#1f77b4", func(d OverviewDay) int { return d.DAU }},
+		{"WAU", "#ff7f0e", func(d OverviewDay) int { return d.WAU }},
+		{"MAU", "#2ca02c", func(d OverviewDay) int { return d.MAU }},
+	}
+	for s, line := range series {
+		buffer.WriteString("<polyline fill=\"none\" stroke=\"" + line.color + "\" points=\"")
+		for i, today := range days {
+			buffer.WriteString(x(i) + "," + y(line.value(today)) + " ")
+		}
+		buffer.WriteString("\"/>")
+
+		legendY := strconv.FormatFloat(20.0+float64(s)*15.0, 'f', 1, 64)
+		buffer.WriteString("<text x=\"" + strconv.FormatFloat(width+10.0, 'f', 1, 64) + "\" y=\"" + legendY + "\" fill=\"" + line.color + "\">" + line.name + "</text>")
+	}
+
+	buffer.WriteString("</svg>")
+
+	return buffer.String()
+}
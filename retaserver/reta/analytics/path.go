@@ -0,0 +1,409 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+
+	"reta/db"
+)
+
+//Path analysis of event sequences
+//
+//Events and timed events of every player are ordered by date from the first launch, repeats of the same action
+//in a row count once. The first steps of every sequence become a Sankey-style graph: a node is an action at a
+//step, a link is the number of players going from one node to the next. Only the top N actions keep their
+//name, the others are "Other", and players whose sequence is shorter than the steps go to "End".
+//
+//The last steps of every sequence can be shown instead, ending at the last action of the player in the date range:
+//for churned players these are the actions before they left. Players with fewer actions start at "Start".
+//
+//Players are labelled retained or churned by the caller, players without a label are left out.
+
+type PathFilter int
+
+const (
+	AllPaths      PathFilter = iota //Every labelled player
+	RetainedPaths                   //Players labelled retained
+	ChurnedPaths                    //Players labelled churned
+)
+
+func (f PathFilter) String() string {
+	switch f {
+	case RetainedPaths:
+		return "Retained Players"
+	case ChurnedPaths:
+		return "Churned Players"
+	}
+
+	return "All Players"
+}
+
+const (
+	otherAction = "Other"
+	endAction   = "End"
+	startAction = "Start"
+)
+
+//Largest top actions and steps, the graph is unreadable past them
+const (
+	MaxPathTop   = 20
+	MaxPathSteps = 10
+)
+
+type PathNode struct {
+	Step    int    `json:"step"`
+	Action  string `json:"action"`
+	Players int    `json:"players"`
+}
+
+type PathLink struct {
+	Source  int `json:"source"` //Index of the node
+	Target  int `json:"target"`
+	Players int `json:"players"`
+}
+
+type PathSequence struct {
+	Actions []string `json:"actions"`
+	Players int      `json:"players"`
+}
+
+type PathGraph struct {
+	Filter    string         `json:"filter"`
+	Last      bool           `json:"last"` //Steps end at the last action instead of starting at the first
+	Players   int            `json:"players"`
+	Steps     int            `json:"steps"`
+	Actions   []string       `json:"actions"` //Top actions by players, the others are "Other"
+	Nodes     []PathNode     `json:"nodes"`
+	Links     []PathLink     `json:"links"`
+	Sequences []PathSequence `json:"sequences"` //Most common sequences of the steps
+}
+
+//Ordered actions of the player without repeats in a row
+func actionSequence(history []playerEvent) []string {
+	var sequence []string
+	for _, event := range history {
+		if len(sequence) == 0 || sequence[len(sequence)-1] != event.action {
+			sequence = append(sequence, event.action)
+		}
+	}
+
+	return sequence
+}
+
+type byPlayersThenName struct {
+	names   []string
+	players map[string]int
+}
+
+func (b byPlayersThenName) Len() int      { return len(b.names) }
+func (b byPlayersThenName) Swap(i, j int) { b.names[i], b.names[j] = b.names[j], b.names[i] }
+func (b byPlayersThenName) Less(i, j int) bool {
+	if b.players[b.names[i]] != b.players[b.names[j]] {
+		return b.players[b.names[i]] > b.players[b.names[j]]
+	}
+	return b.names[i] < b.names[j]
+}
+
+//Actions of the steps from the first action, or up to the last action, with the step of the first of them
+func pathSteps(sequence []string, kept map[string]bool, steps int, last bool) ([]string, int) {
+	name := func(action string) string {
+		if kept[action] {
+			return action
+		}
+		return otherAction
+	}
+
+	var path []string
+	if !last {
+		//Nothing follows the end
+		for step := 0; step < steps; step++ {
+			if step >= len(sequence) {
+				path = append(path, endAction)
+				break
+			}
+			path = append(path, name(sequence[step]))
+		}
+
+		return path, 1
+	}
+
+	//Nothing comes before the start
+	start := len(sequence) - steps
+	if start < 0 {
+		path = append(path, startAction)
+		start = 0
+	}
+	for _, action := range sequence[start:] {
+		path = append(path, name(action))
+	}
+
+	return path, steps - len(path) + 1
+}
+
+func BuildPaths(events []db.Event, timedevents []db.TimedEvent, retained map[string]bool, filter PathFilter, last bool, top int, steps int) PathGraph {
+	graph := PathGraph{Filter: filter.String(), Last: last, Steps: steps}
+
+	//Sequences of the players of the filter, sorted by player so the graph is the same on every request
+	histories := playerEvents(events, timedevents)
+	var names []string
+	for player := range histories {
+		names = append(names, player)
+	}
+	sort.Strings(names)
+
+	var sequences [][]string
+	for _, player := range names {
+		label, ok := retained[player]
+		if !ok || (filter == RetainedPaths && !label) || (filter == ChurnedPaths && label) {
+			continue
+		}
+
+		sequences = append(sequences, actionSequence(histories[player]))
+	}
+	graph.Players = len(sequences)
+
+	//Top actions by the number of players doing them
+	players := make(map[string]int)
+	for _, sequence := range sequences {
+		seen := make(map[string]bool)
+		for _, action := range sequence {
+			if !seen[action] {
+				seen[action] = true
+				players[action]++
+			}
+		}
+	}
+
+	var actions []string
+	for action := range players {
+		actions = append(actions, action)
+	}
+	sort.Sort(byPlayersThenName{actions, players})
+	if len(actions) > top {
+		actions = actions[:top]
+	}
+	graph.Actions = actions
+
+	kept := make(map[string]bool)
+	for _, action := range actions {
+		kept[action] = true
+	}
+
+	//Nodes and links of the steps
+	nodes := make(map[string]int)
+	links := make(map[[2]int]int)
+	counts := make(map[string]int)
+	node := func(step int, action string) int {
+		key := strconv.Itoa(step) + "/" + action
+		index, ok := nodes[key]
+		if !ok {
+			index = len(graph.Nodes)
+			nodes[key] = index
+			graph.Nodes = append(graph.Nodes, PathNode{Step: step, Action: action})
+		}
+		graph.Nodes[index].Players++
+		return index
+	}
+
+	for _, sequence := range sequences {
+		path, first := pathSteps(sequence, kept, steps, last)
+
+		previous := -1
+		for step, action := range path {
+			current := node(first+step, action)
+			if previous >= 0 {
+				links[[2]int{previous, current}]++
+			}
+			previous = current
+		}
+
+		counts[strings.Join(path, "\x00")]++
+	}
+
+	for link, count := range links {
+		graph.Links = append(graph.Links, PathLink{Source: link[0], Target: link[1], Players: count})
+	}
+	sort.Sort(byLink(graph.Links))
+
+	var keys []string
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Sort(byPlayersThenName{keys, counts})
+	if len(keys) > top {
+		keys = keys[:top]
+	}
+	for _, key := range keys {
+		graph.Sequences = append(graph.Sequences, PathSequence{Actions: strings.Split(key, "\x00"), Players: counts[key]})
+	}
+
+	return graph
+}
+
+type byLink []PathLink
+
+func (b byLink) Len() int      { return len(b) }
+func (b byLink) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byLink) Less(i, j int) bool {
+	if b[i].Source != b[j].Source {
+		return b[i].Source < b[j].Source
+	}
+	return b[i].Target < b[j].Target
+}
+
+func (g PathGraph) JSON() ([]byte, error) {
+	return json.Marshal(g)
+}
+
+func (g PathGraph) StringHTML() string {
+	var buffer bytes.Buffer
+
+	if g.Players == 0 {
+		buffer.WriteString("<div>No player in the selected dates</div>")
+		return buffer.String()
+	}
+
+	buffer.WriteString("<div>")
+	buffer.WriteString(html.EscapeString(g.Filter))
+	if g.Last {
+		buffer.WriteString(", last actions")
+	}
+	buffer.WriteString(": ")
+	buffer.WriteString(strconv.Itoa(g.Players))
+	buffer.WriteString("</div>")
+
+	buffer.WriteString(pathSVG(g))
+
+	//Most common sequences
+	buffer.WriteString("<br/><div><h3>Most Common Sequences</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Sequence</td>")
+	buffer.WriteString("<td>Players</td>")
+	buffer.WriteString("<td>Players (%)</td>")
+	buffer.WriteString("</tr>")
+	for _, sequence := range g.Sequences {
+		escaped := make([]string, len(sequence.Actions))
+		for i, action := range sequence.Actions {
+			escaped[i] = html.EscapeString(action)
+		}
+
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strings.Join(escaped, " &rarr; "))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(sequence.Players))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(100.0*float64(sequence.Players)/float64(g.Players), 'f', 2, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
+
+//Sankey diagram of the nodes and links as inline SVG, one column of nodes per step
+func pathSVG(g PathGraph) string {
+	columnWidth, nodeWidth, height, padding := 170.0, 12.0, 400.0, 8.0
+	width := columnWidth*float64(g.Steps-1) + nodeWidth + 130.0
+
+	//Nodes of every step in order of players
+	columns := make([][]int, g.Steps)
+	for n, node := range g.Nodes {
+		columns[node.Step-1] = append(columns[node.Step-1], n)
+	}
+
+	scale := (height - padding*float64(len(g.Actions)+2)) / float64(g.Players)
+	top := make([]float64, len(g.Nodes))
+	for _, column := range columns {
+		sort.Stable(byNodePlayers{column, g.Nodes})
+
+		y := 0.0
+		for _, n := range column {
+			top[n] = y
+			y += float64(g.Nodes[n].Players)*scale + padding
+		}
+	}
+
+	colors := make(map[string]string)
+	for a, action := range g.Actions {
+		colors[action] = actionColor(a)
+	}
+	colors[otherAction] = "#999999"
+	colors[endAction] = "#d62728"
+	colors[startAction] = "#333333"
+
+	x := func(n int) float64 {
+		return float64(g.Nodes[n].Step-1) * columnWidth
+	}
+	format := func(val float64) string {
+		return strconv.FormatFloat(val, 'f', 1, 64)
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"")
+	buffer.WriteString(format(width))
+	buffer.WriteString("\" height=\"")
+	buffer.WriteString(format(height))
+	buffer.WriteString("\" font-size=\"11\">")
+
+	//Links leave and enter the nodes stacked in order of the links
+	out := make([]float64, len(g.Nodes))
+	in := make([]float64, len(g.Nodes))
+	for _, link := range g.Links {
+		thickness := float64(link.Players) * scale
+		y1 := top[link.Source] + out[link.Source] + thickness/2.0
+		y2 := top[link.Target] + in[link.Target] + thickness/2.0
+		out[link.Source] += thickness
+		in[link.Target] += thickness
+
+		x1 := x(link.Source) + nodeWidth
+		x2 := x(link.Target)
+		middle := (x1 + x2) / 2.0
+
+		buffer.WriteString("<path fill=\"none\" stroke-opacity=\"0.35\" stroke=\"")
+		buffer.WriteString(colors[g.Nodes[link.Source].Action])
+		buffer.WriteString("\" stroke-width=\"")
+		buffer.WriteString(format(thickness))
+		buffer.WriteString("\" d=\"M" + format(x1) + "," + format(y1) + " C" + format(middle) + "," + format(y1) + " " + format(middle) + "," + format(y2) + " " + format(x2) + "," + format(y2) + "\">")
+		buffer.WriteString("<title>")
+		buffer.WriteString(html.EscapeString(g.Nodes[link.Source].Action + " → " + g.Nodes[link.Target].Action + ": " + strconv.Itoa(link.Players)))
+		buffer.WriteString("</title></path>")
+	}
+
+	for n, node := range g.Nodes {
+		buffer.WriteString("<rect x=\"" + format(x(n)) + "\" y=\"" + format(top[n]) + "\" width=\"" + format(nodeWidth) + "\" height=\"" + format(float64(node.Players)*scale) + "\" fill=\"" + colors[node.Action] + "\"/>")
+		buffer.WriteString("<text x=\"" + format(x(n)+nodeWidth+3.0) + "\" y=\"" + format(top[n]+10.0) + "\">")
+		buffer.WriteString(html.EscapeString(node.Action + " (" + strconv.Itoa(node.Players) + ")"))
+		buffer.WriteString("</text>")
+	}
+
+	buffer.WriteString("</svg>")
+
+	return buffer.String()
+}
+
+type byNodePlayers struct {
+	indexes []int
+	nodes   []PathNode
+}
+
+func (b byNodePlayers) Len() int      { return len(b.indexes) }
+func (b byNodePlayers) Swap(i, j int) { b.indexes[i], b.indexes[j] = b.indexes[j], b.indexes[i] }
+func (b byNodePlayers) Less(i, j int) bool {
+	return b.nodes[b.indexes[i]].Players > b.nodes[b.indexes[j]].Players
+}
+
+//Color of the n-th action
+func actionColor(n int) string {
+	colors := []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#9467bd", "#8c564b", "#e377c2", "#bcbd22", "#17becf"}
+	return colors[n%len(colors)]
+}
@@ -0,0 +1,153 @@
+package analytics
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strconv"
+	"time"
+
+	"appengine"
+
+	"reta/db"
+)
+
+//Sessionization of raw events
+//
+//Events and timed events of every player are ordered by date and split into sessions wherever there is more
+//than the session gap without any event. A session lasts from its first to its last event, so a session of a
+//single event has no length.
+//
+//Sessions are stored by StoreSessions from overlapping windows of events. Sessions starting within the gap of
+//the window begin may continue an earlier session and sessions ending within the gap of now may still grow,
+//both are left to the runs which see them whole.
+
+const SessionGap = 30 * time.Minute
+
+type bySessionStart []db.Session
+
+func (b bySessionStart) Len() int      { return len(b) }
+func (b bySessionStart) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bySessionStart) Less(i, j int) bool {
+	if b[i].Player != b[j].Player {
+		return b[i].Player < b[j].Player
+	}
+	return b[i].Start.Before(b[j].Start)
+}
+
+//Sessions of every player ordered by player and start
+func Sessionize(events []db.Event, timedevents []db.TimedEvent, gap time.Duration) []db.Session {
+	var sessions []db.Session
+	for player, history := range playerEvents(events, timedevents) {
+		session := db.Session{Player: player, Version: history[0].version, Start: history[0].date, End: history[0].date, Events: 1}
+		for _, event := range history[1:] {
+			if event.date.Sub(session.End) > gap {
+				sessions = append(sessions, session)
+				session = db.Session{Player: player, Version: event.version, Start: event.date, End: event.date}
+			}
+
+			session.End = event.date
+			session.Events++
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.Sort(bySessionStart(sessions))
+
+	return sessions
+}
+
+//Number of sessions, mean session length and mean time between sessions of one player in minutes
+func SessionStatistics(sessions []db.Session) (int, float64, float64) {
+	count := len(sessions)
+	if count == 0 {
+		return 0, 0.0, 0.0
+	}
+
+	length, interval := 0.0, 0.0
+	for i, session := range sessions {
+		length += session.End.Sub(session.Start).Minutes()
+		if i > 0 {
+			interval += session.Start.Sub(sessions[i-1].End).Minutes()
+		}
+	}
+
+	length /= float64(count)
+	if count > 1 {
+		interval /= float64(count - 1)
+	}
+
+	return count, length, interval
+}
+
+//Sessionize the events from begin to now and store the finished sessions, returns the number stored
+func StoreSessions(c appengine.Context, begin time.Time, now time.Time) (int, error) {
+	var events []db.Event
+	err := db.GetAllEvents(c, begin, now, &events)
+	if err != nil {
+		return 0, err
+	}
+
+	var timedevents []db.TimedEvent
+	err = db.GetAllTimedEvents(c, begin, now, &timedevents)
+	if err != nil {
+		return 0, err
+	}
+
+	var finished []db.Session
+	for _, session := range Sessionize(events, timedevents, SessionGap) {
+		if session.Start.Sub(begin) > SessionGap && now.Sub(session.End) > SessionGap {
+			finished = append(finished, session)
+		}
+	}
+
+	err = db.PutSessions(c, finished)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(finished), nil
+}
+
+//Stored sessions of one player
+func SessionsHTML(sessions []db.Session) string {
+	var buffer bytes.Buffer
+
+	if len(sessions) == 0 {
+		buffer.WriteString("<div>No stored session of the player</div>")
+		return buffer.String()
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Start</td>")
+	buffer.WriteString("<td>End</td>")
+	buffer.WriteString("<td>Length (min)</td>")
+	buffer.WriteString("<td>Events</td>")
+	buffer.WriteString("<td>App Version</td>")
+	buffer.WriteString("</tr>")
+
+	for _, session := range sessions {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(session.Start.Format("02/01/2006 15:04:05"))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(session.End.Format("02/01/2006 15:04:05"))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(session.End.Sub(session.Start).Minutes(), 'f', 2, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(session.Events))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(session.Version))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
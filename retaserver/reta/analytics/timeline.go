@@ -0,0 +1,81 @@
+package analytics
+
+import (
+	"bytes"
+	"html"
+	"sort"
+
+	"reta/db"
+)
+
+//Event timeline of one player
+
+type timelineEntry struct {
+	event    db.Event
+	duration string //Empty for events
+}
+
+type byEntryDate []timelineEntry
+
+func (b byEntryDate) Len() int           { return len(b) }
+func (b byEntryDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byEntryDate) Less(i, j int) bool { return b[i].event.Date.Before(b[j].event.Date) }
+
+//Events and timed events in chronological order with their parameters
+func TimelineHTML(events []db.Event, timedevents []db.TimedEvent) string {
+	var entries []timelineEntry
+	for _, event := range events {
+		entries = append(entries, timelineEntry{event: event})
+	}
+	for _, timedevent := range timedevents {
+		entries = append(entries, timelineEntry{event: timedevent.Info, duration: timedevent.Duration.String()})
+	}
+	sort.Stable(byEntryDate(entries))
+
+	var buffer bytes.Buffer
+
+	if len(entries) == 0 {
+		buffer.WriteString("<div>No event of the player</div>")
+		return buffer.String()
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Date</td>")
+	buffer.WriteString("<td>Action</td>")
+	buffer.WriteString("<td>App Version</td>")
+	buffer.WriteString("<td>Duration</td>")
+	buffer.WriteString("<td>Parameters</td>")
+	buffer.WriteString("</tr>")
+
+	for _, entry := range entries {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(entry.event.Date.Format("02/01/2006 15:04:05"))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(entry.event.Action))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(entry.event.Version))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(entry.duration)
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		for p, parameter := range entry.event.Parameters {
+			if p > 0 {
+				buffer.WriteString("<br/>")
+			}
+			buffer.WriteString(html.EscapeString(parameter.Key))
+			buffer.WriteString(" = ")
+			buffer.WriteString(html.EscapeString(parameter.Value))
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -6,6 +6,8 @@ import (
 
 	"appengine"
 	"appengine/datastore"
+
+	"reta/errors"
 )
 
 type Parameter struct {
@@ -26,53 +28,110 @@ type TimedEvent struct {
 	Duration time.Duration
 }
 
-func SubmitEvent(c appengine.Context, player string, version string, data string) error {
-	b := []byte(data)
+//Datastore puts and deletes at most 500 entities and gets at most 1000 at once
+const (
+	writeBatch = 500
+	readBatch  = 1000
+)
 
-	var f interface{}
-	err := json.Unmarshal(b, &f)
+//Call f with the bounds of every batch of count items
+func inBatches(count int, size int, f func(start int, end int) error) error {
+	for start := 0; start < count; start += size {
+		end := start + size
+		if end > count {
+			end = count
+		}
+
+		err := f(start, end)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func SubmitEvent(c appengine.Context, player string, version string, data string) error {
+	ev, duration, err := ParseEvent(player, version, data)
 	if err != nil {
 		return err
 	}
 
-	var duration time.Duration = 0
-	var tev TimedEvent
+	return PutEvent(c, ev, duration)
+}
+
+//Parse the json sent by the game, duration is zero for events without one
+func ParseEvent(player string, version string, data string) (Event, time.Duration, error) {
+	b := []byte(data)
 
 	var ev Event
 	ev.Player = player
 	ev.Version = version
 
+	var f interface{}
+	err := json.Unmarshal(b, &f)
+	if err != nil {
+		return ev, 0, err
+	}
+
+	var duration time.Duration = 0
+
 	//Parse json to event object
-	m := f.(map[string]interface{})
+	m, ok := f.(map[string]interface{})
+	if !ok {
+		return ev, 0, errors.New("Error: Event data must be a json object")
+	}
 	for k, v := range m {
 		if k == "Name" {
 			//Just get the name
-			action := v.(string)
+			action, ok := v.(string)
+			if !ok {
+				return ev, 0, errors.New("Error: Event name must be a string")
+			}
 			ev.Action = action
 		} else if k == "Time" {
 			//Convert to time
 			layout := "01/02/2006 15:04:05"
-			clienttime := v.(string)
+			clienttime, ok := v.(string)
+			if !ok {
+				return ev, 0, errors.New("Error: Event time must be a string")
+			}
 			ev.Date, _ = time.Parse(layout, clienttime)
 		} else if k == "Parameters" {
 			//Convert to parameters
-			pars := v.([]interface{})
+			pars, ok := v.([]interface{})
+			if !ok {
+				return ev, 0, errors.New("Error: Event parameters must be an array")
+			}
 			parameters := make([]Parameter, len(pars))
 			for i, par := range pars {
+				parstring, ok := par.(string)
+				if !ok {
+					return ev, 0, errors.New("Error: Event parameter must be a json string")
+				}
+
 				var pinterface interface{}
-				p := []byte(par.(string))
+				p := []byte(parstring)
 
 				err = json.Unmarshal(p, &pinterface)
 				if err != nil {
-					return err
+					return ev, 0, err
 				}
 
 				//There's only one parameter per map
-				pmap := pinterface.(map[string]interface{})
+				pmap, ok := pinterface.(map[string]interface{})
+				if !ok {
+					return ev, 0, errors.New("Error: Event parameter must be a json object")
+				}
 				for kpar, vpar := range pmap {
+					value, ok := vpar.(string)
+					if !ok {
+						return ev, 0, errors.New("Error: Event parameter value must be a string")
+					}
+
 					param := Parameter{
 						Key:   kpar,
-						Value: vpar.(string),
+						Value: value,
 					}
 					//See above comment
 					parameters[i] = param
@@ -81,11 +140,18 @@ func SubmitEvent(c appengine.Context, player string, version string, data string
 			ev.Parameters = parameters
 		} else if k == "Duration" {
 			//Convert to duration
-			durr := v.(string)
+			durr, ok := v.(string)
+			if !ok {
+				return ev, 0, errors.New("Error: Event duration must be a string")
+			}
 			duration, _ = time.ParseDuration(durr)
 		}
 	}
 
+	return ev, duration, nil
+}
+
+func PutEvent(c appengine.Context, ev Event, duration time.Duration) error {
 	//Save to appropriate datastore
 	if duration == 0 {
 		c.Debugf("Event: %v\n", ev)
@@ -93,7 +159,14 @@ func SubmitEvent(c appengine.Context, player string, version string, data string
 		if err != nil {
 			return err
 		}
+
+		//Cohorts and risk scores tell new players from the first seen date
+		err = putFirstSeen(c, ev.Player, ev.Date)
+		if err != nil {
+			return err
+		}
 	} else {
+		var tev TimedEvent
 		tev.Info = ev
 		tev.Duration = duration
 
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"sync"
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+	"appengine/memcache"
+)
+
+//Submission received by the connector, kept for the live event viewer
+type LiveEvent struct {
+	Received   time.Time
+	Player     string
+	Version    string
+	Action     string
+	Date       time.Time
+	Duration   time.Duration //Zero for events
+	Parameters []Parameter
+	Payload    string `datastore:",noindex"` //Raw data sent by the game
+	Error      string `datastore:",noindex"` //Parse or save failure, empty when stored
+	Unparsed   bool   //Payload could not be parsed, the event fields may be partial
+}
+
+const (
+	liveCaptureKey = "live-capture"
+	liveCaptureTTL = 10 * time.Second //Time the instance keeps the capture flag
+)
+
+//Capture flag of the instance, so the connector does not read memcache on every submission
+var liveCaptureCache struct {
+	sync.Mutex
+	on      bool
+	checked time.Time
+}
+
+//Record submissions for the next duration, renewed by the viewer while it is open
+func StartLiveCapture(c appengine.Context, duration time.Duration) error {
+	err := memcache.Set(c, &memcache.Item{Key: liveCaptureKey, Value: []byte("on"), Expiration: duration})
+	if err != nil {
+		return err
+	}
+
+	liveCaptureCache.Lock()
+	liveCaptureCache.on, liveCaptureCache.checked = true, time.Now()
+	liveCaptureCache.Unlock()
+
+	return nil
+}
+
+//Whether a viewer is open, submissions are not recorded otherwise, other instances see it within the TTL
+func LiveCapture(c appengine.Context) bool {
+	liveCaptureCache.Lock()
+	defer liveCaptureCache.Unlock()
+
+	if time.Since(liveCaptureCache.checked) < liveCaptureTTL {
+		return liveCaptureCache.on
+	}
+
+	_, err := memcache.Get(c, liveCaptureKey)
+	liveCaptureCache.on, liveCaptureCache.checked = err == nil, time.Now()
+
+	return liveCaptureCache.on
+}
+
+//Submissions share one parent so the viewer query is strongly consistent, the entity group limits the writes
+//per second but only the debugging copies are lost when it is busy
+func liveKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Live Capture", "live", 0, nil)
+}
+
+func PutLiveEvent(c appengine.Context, live LiveEvent) error {
+	_, err := datastore.Put(c, datastore.NewIncompleteKey(c, "Live Event", liveKey(c)), &live)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//Submissions received after the date up to the last date, at most limit of the oldest
+func GetLiveEvents(c appengine.Context, after time.Time, last time.Time, limit int, lives *[]LiveEvent) error {
+	q := datastore.NewQuery("Live Event").Ancestor(liveKey(c)).Filter("Received >", after).Filter("Received <=", last).Order("Received").Limit(limit)
+
+	var livesData []LiveEvent
+
+	_, err := q.GetAll(c, &livesData)
+	if err != nil {
+		return err
+	}
+
+	*lives = livesData
+
+	return nil
+}
+
+//Remove submissions received before the date, returns the number removed
+func DeleteLiveEvents(c appengine.Context, before time.Time) (int, error) {
+	q := datastore.NewQuery("Live Event").Ancestor(liveKey(c)).Filter("Received <", before).KeysOnly()
+
+	keys, err := q.GetAll(c, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	err = inBatches(len(keys), writeBatch, func(start int, end int) error {
+		return datastore.DeleteMulti(c, keys[start:end])
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(keys), nil
+}
@@ -0,0 +1,166 @@
+package db
+
+import (
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+//Latest churn risk of a player from the last prediction run
+type RiskScore struct {
+	Player string
+	Risk   float64 //Probability of not being retained
+	Method string  //Classification method of the model
+	Scored time.Time
+}
+
+func GetPlayerEvents(c appengine.Context, player string, events *[]Event) error {
+	q := datastore.NewQuery("Event").Filter("Player =", player).Order("Date")
+
+	var eventsData []Event
+
+	_, err := q.GetAll(c, &eventsData)
+	if err != nil {
+		return err
+	}
+
+	*events = eventsData
+
+	return nil
+}
+
+func GetPlayerTimedEvents(c appengine.Context, player string, timedevents *[]TimedEvent) error {
+	q := datastore.NewQuery("Timed Event").Filter("Info.Player =", player).Order("Info.Date")
+
+	var timedeventsData []TimedEvent
+
+	_, err := q.GetAll(c, &timedeventsData)
+	if err != nil {
+		return err
+	}
+
+	*timedevents = timedeventsData
+
+	return nil
+}
+
+//Date of the first event of a player, kept at ingest so players seen before a date are read by key
+type FirstSeen struct {
+	Date time.Time `datastore:",noindex"`
+}
+
+func firstSeenKey(c appengine.Context, player string) *datastore.Key {
+	return datastore.NewKey(c, "First Seen", player, 0, nil)
+}
+
+//Keep the earliest event date of the player, events do not arrive in order
+func putFirstSeen(c appengine.Context, player string, date time.Time) error {
+	return datastore.RunInTransaction(c, func(tc appengine.Context) error {
+		key := firstSeenKey(tc, player)
+
+		var seen FirstSeen
+		err := datastore.Get(tc, key, &seen)
+		if err == nil && !date.Before(seen.Date) {
+			return nil
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		_, err = datastore.Put(tc, key, &FirstSeen{Date: date})
+		return err
+	}, nil)
+}
+
+//First event date of a player stored before first seen dates were kept, false when the player has no event
+func backfillFirstSeen(c appengine.Context, player string) (time.Time, bool, error) {
+	q := datastore.NewQuery("Event").Filter("Player =", player).Order("Date").Limit(1)
+
+	var eventsData []Event
+	_, err := q.GetAll(c, &eventsData)
+	if err != nil || len(eventsData) == 0 {
+		return time.Time{}, false, err
+	}
+
+	err = putFirstSeen(c, player, eventsData[0].Date)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	return eventsData[0].Date, true, nil
+}
+
+//Players of the list with an event before the date from their first seen dates
+func GetPlayersBefore(c appengine.Context, players []string, date time.Time) (map[string]bool, error) {
+	before := make(map[string]bool)
+
+	err := inBatches(len(players), readBatch, func(start int, end int) error {
+		keys := make([]*datastore.Key, end-start)
+		for i, player := range players[start:end] {
+			keys[i] = firstSeenKey(c, player)
+		}
+
+		seen := make([]FirstSeen, end-start)
+		err := datastore.GetMulti(c, keys, seen)
+		missing, ok := err.(appengine.MultiError)
+		if err != nil && !ok {
+			return err
+		}
+
+		for i, player := range players[start:end] {
+			first, found := seen[i].Date, true
+			if ok && missing[i] != nil {
+				if missing[i] != datastore.ErrNoSuchEntity {
+					return missing[i]
+				}
+
+				first, found, err = backfillFirstSeen(c, player)
+				if err != nil {
+					return err
+				}
+			}
+
+			if found && first.Before(date) {
+				before[player] = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return before, nil
+}
+
+//Risk scores are identified by player, so a new prediction replaces the previous score
+func riskKey(c appengine.Context, player string) *datastore.Key {
+	return datastore.NewKey(c, "Risk Score", player, 0, nil)
+}
+
+func PutRiskScores(c appengine.Context, scores []RiskScore) error {
+	return inBatches(len(scores), writeBatch, func(start int, end int) error {
+		keys := make([]*datastore.Key, end-start)
+		for i, score := range scores[start:end] {
+			keys[i] = riskKey(c, score.Player)
+		}
+
+		_, err := datastore.PutMulti(c, keys, scores[start:end])
+		return err
+	})
+}
+
+//Latest risk score of the player, false when the player was never scored
+func GetRiskScore(c appengine.Context, player string, score *RiskScore) (bool, error) {
+	err := datastore.Get(c, riskKey(c, player), score)
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
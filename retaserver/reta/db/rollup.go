@@ -0,0 +1,101 @@
+package db
+
+import (
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+//Activity of one day aggregated from its events, stored so the overview does not read every event again
+//
+//Active players of a busy day do not fit in one entity, so every player is a child entity whose key name is
+//the player. Players keep the day of their rollup so the players of every day are read back with one keys-only
+//query. The rollup itself only keeps the count.
+type DailyRollup struct {
+	Date           time.Time //Day at 00:00 UTC
+	ActivePlayers  int       //Players with at least one event on the day
+	Players        []string  `datastore:"-"` //Stored as the children of the rollup
+	Events         int
+	TimedEvents    int
+	Sessions       int
+	SessionSeconds float64 //Total length of the sessions
+	Built          time.Time
+}
+
+//Active player of a daily rollup, identified by its key name
+type RollupPlayer struct {
+	Date   time.Time //Day of the rollup
+	Player string    `datastore:",noindex"`
+}
+
+func rollupKey(c appengine.Context, date time.Time) *datastore.Key {
+	return datastore.NewKey(c, "Daily Rollup", date.Format("2006-01-02"), 0, nil)
+}
+
+//Save the rollup and its players replacing the ones of the same day
+func PutRollup(c appengine.Context, rollup DailyRollup) error {
+	parent := rollupKey(c, rollup.Date)
+
+	//Players of the previous rollup of the day
+	keys, err := datastore.NewQuery("Rollup Player").Ancestor(parent).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	err = inBatches(len(keys), writeBatch, func(start int, end int) error {
+		return datastore.DeleteMulti(c, keys[start:end])
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, parent, &rollup)
+	if err != nil {
+		return err
+	}
+
+	return inBatches(len(rollup.Players), writeBatch, func(start int, end int) error {
+		keys := make([]*datastore.Key, end-start)
+		players := make([]RollupPlayer, end-start)
+		for i, player := range rollup.Players[start:end] {
+			keys[i] = datastore.NewKey(c, "Rollup Player", player, 0, parent)
+			players[i] = RollupPlayer{Date: rollup.Date, Player: player}
+		}
+
+		_, err := datastore.PutMulti(c, keys, players)
+		return err
+	})
+}
+
+//Rollups of the days with their players, one query for the rollups and one for all their players
+func GetRollups(c appengine.Context, begin time.Time, end time.Time, rollups *[]DailyRollup) error {
+	q := datastore.NewQuery("Daily Rollup").Filter("Date >=", begin).Filter("Date <=", end).Order("Date")
+
+	var rollupsData []DailyRollup
+
+	keys, err := q.GetAll(c, &rollupsData)
+	if err != nil {
+		return err
+	}
+
+	//Players of all the days, the parent of a player is the rollup of its day
+	players, err := datastore.NewQuery("Rollup Player").Filter("Date >=", begin).Filter("Date <=", end).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	days := make(map[string][]string)
+	for _, player := range players {
+		day := player.Parent().StringID()
+		days[day] = append(days[day], player.StringID())
+	}
+
+	for i, key := range keys {
+		rollupsData[i].Players = days[key.StringID()]
+	}
+
+	*rollups = rollupsData
+
+	return nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+//Player segmentation of the last prediction with k-means, the segments are its children
+type Segmentation struct {
+	Variables  []string  `datastore:",noindex"` //Name of each centroid value
+	Means      []float64 `datastore:",noindex"` //Standardization of the variables
+	Deviations []float64 `datastore:",noindex"`
+	Silhouette float64
+	Inertia    float64
+	Created    time.Time
+}
+
+type Segment struct {
+	Index    int
+	Size     int       //Players of the segment
+	Centroid []float64 `datastore:",noindex"` //Center in the original units of the variables
+}
+
+//Only the latest segmentation is kept
+func segmentationKey(c appengine.Context) *datastore.Key {
+	return datastore.NewKey(c, "Segmentation", "latest", 0, nil)
+}
+
+//Replace the stored segmentation and its segments
+func PutSegmentation(c appengine.Context, segmentation Segmentation, segments []Segment) error {
+	parent := segmentationKey(c)
+
+	//Segments of the previous segmentation, k can be different
+	keys, err := datastore.NewQuery("Segment").Ancestor(parent).KeysOnly().GetAll(c, nil)
+	if err != nil {
+		return err
+	}
+
+	err = datastore.DeleteMulti(c, keys)
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, parent, &segmentation)
+	if err != nil {
+		return err
+	}
+
+	keys = make([]*datastore.Key, len(segments))
+	for i, segment := range segments {
+		keys[i] = datastore.NewKey(c, "Segment", "", int64(segment.Index+1), parent)
+	}
+
+	_, err = datastore.PutMulti(c, keys, segments)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//Latest segmentation with its segments by index, false when players were never segmented
+func GetSegmentation(c appengine.Context, segmentation *Segmentation, segments *[]Segment) (bool, error) {
+	parent := segmentationKey(c)
+
+	err := datastore.Get(c, parent, segmentation)
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var segmentsData []Segment
+
+	//Keys are ordered by the index without a composite index
+	_, err = datastore.NewQuery("Segment").Ancestor(parent).GetAll(c, &segmentsData)
+	if err != nil {
+		return false, err
+	}
+
+	*segments = segmentsData
+
+	return true, nil
+}
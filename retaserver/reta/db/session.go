@@ -0,0 +1,50 @@
+package db
+
+import (
+	"time"
+
+	"appengine"
+	"appengine/datastore"
+)
+
+//Run of events of one player without a long inactivity between them
+type Session struct {
+	Player  string
+	Version string //App version of the first event
+	Start   time.Time
+	End     time.Time
+	Events  int //Events and timed events
+}
+
+//Sessions are identified by player and start, so sessionizing the same events again replaces them
+func sessionKey(c appengine.Context, session Session) *datastore.Key {
+	return datastore.NewKey(c, "Session", session.Player+"/"+session.Start.UTC().Format(time.RFC3339Nano), 0, nil)
+}
+
+func PutSessions(c appengine.Context, sessions []Session) error {
+	return inBatches(len(sessions), writeBatch, func(start int, end int) error {
+		keys := make([]*datastore.Key, end-start)
+		for i, session := range sessions[start:end] {
+			keys[i] = sessionKey(c, session)
+		}
+
+		_, err := datastore.PutMulti(c, keys, sessions[start:end])
+		return err
+	})
+}
+
+//Stored sessions of the player ordered by start
+func GetPlayerSessions(c appengine.Context, player string, sessions *[]Session) error {
+	q := datastore.NewQuery("Session").Filter("Player =", player).Order("Start")
+
+	var sessionsData []Session
+
+	_, err := q.GetAll(c, &sessionsData)
+	if err != nil {
+		return err
+	}
+
+	*sessions = sessionsData
+
+	return nil
+}
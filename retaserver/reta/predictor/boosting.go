@@ -0,0 +1,376 @@
+package predictor
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+//Gradient-boosted regression trees with log-loss objective
+//
+//Starting from the log-odds of retention, every round fits a small tree to the gradient and hessian of the
+//log loss and adds its leaves scaled by the learning rate:
+//
+//	F(x) = F0 + learningRate * Sum(tree_m(x)), P(retained | x) = 1 / (1 + exp(-F(x)))
+//
+//Splits maximize G_L^2 / (H_L + lambda) + G_R^2 / (H_R + lambda) - G^2 / (H + lambda) and leaves are G / (H + lambda)
+//where G = Sum(w * (y - p)) and H = Sum(w * p * (1 - p)). Part of the training data is kept as a validation
+//split, boosting stops when its log loss does not improve for a number of rounds and the best round is kept.
+//
+//References:
+// - Friedman, J. (2001). Greedy Function Approximation: A Gradient Boosting Machine. Annals of Statistics 29(5)
+// - Chen, T. and Guestrin, C. (2016). XGBoost: A Scalable Tree Boosting System. KDD
+
+const boostingLambda = 1.0 //L2 regularization of the leaves
+
+type GradientBoosting struct {
+	featureEncoder
+	NumRounds          int        //Maximum number of trees, zero means 200
+	LearningRate       float64    //Zero means 0.1
+	MaxDepth           int        //Zero means 3
+	MinLeaf            float64    //Minimum weight of a leaf, zero means 5
+	ValidationFraction float64    //Part of the training data used for early stopping, zero means 0.2
+	Patience           int        //Rounds without improvement before stopping, zero means 10
+	Random             *rand.Rand //Used for the validation split
+
+	initial         float64 //Log-odds of retention
+	trees           []*treeNode
+	importance      []float64 //Total gain of each feature
+	trainingLoss    []float64 //Log loss after every round
+	validationLoss  []float64
+	bestRound       int
+	stoppedEarly    bool
+	validationCount int
+}
+
+//Round and loss of the boosting trace
+type boostingRound struct {
+	round      int
+	training   float64
+	validation float64
+}
+
+func (g *GradientBoosting) Fit(trainingData []DataPoint) error {
+	err := g.fitFeatures(trainingData)
+	if err != nil {
+		return err
+	}
+
+	if g.NumRounds <= 0 {
+		g.NumRounds = 200
+	}
+	if g.LearningRate <= 0 {
+		g.LearningRate = 0.1
+	}
+	if g.MaxDepth <= 0 {
+		g.MaxDepth = 3
+	}
+	if g.MinLeaf <= 0 {
+		g.MinLeaf = 5
+	}
+	if g.ValidationFraction <= 0 || g.ValidationFraction >= 1 {
+		g.ValidationFraction = 0.2
+	}
+	if g.Patience <= 0 {
+		g.Patience = 10
+	}
+	g.Random = classifierRandom(g.Random)
+
+	numData := len(trainingData)
+	x := make([][]float64, numData)
+	y := make([]float64, numData)
+	w := make([]float64, numData)
+	for i, data := range trainingData {
+		x[i] = g.encode(data)
+		y[i] = data.Result
+		w[i] = data.weight()
+	}
+
+	//Validation split, too little data is trained without early stopping
+	var training, validation []int
+	g.validationCount = int(float64(numData) * g.ValidationFraction)
+	if g.validationCount < 1 || numData-g.validationCount < 2 {
+		g.validationCount = 0
+	}
+	for k, i := range g.Random.Perm(numData) {
+		if k < g.validationCount {
+			validation = append(validation, i)
+		} else {
+			training = append(training, i)
+		}
+	}
+
+	//Start from the log-odds of the training split
+	positive, total := 0.0, 0.0
+	for _, i := range training {
+		positive += w[i] * y[i]
+		total += w[i]
+	}
+	p := 0.5
+	if total > 0 {
+		p = math.Min(math.Max(positive/total, 1e-6), 1.0-1e-6)
+	}
+	g.initial = math.Log(p / (1.0 - p))
+
+	score := make([]float64, numData)
+	for i := range score {
+		score[i] = g.initial
+	}
+
+	g.trees = nil
+	g.importance = make([]float64, len(g.featureNames()))
+	g.trainingLoss = nil
+	g.validationLoss = nil
+	g.bestRound = 0
+	g.stoppedEarly = false
+
+	gradient := make([]float64, numData)
+	hessian := make([]float64, numData)
+	bestLoss := math.Inf(1)
+	var gains [][]float64 //Split gain of each feature in every tree
+	for m := 0; m < g.NumRounds; m++ {
+		for _, i := range training {
+			p := sigmoid(score[i])
+			gradient[i] = w[i] * (y[i] - p)
+			hessian[i] = w[i] * p * (1.0 - p)
+		}
+
+		gain := make([]float64, len(g.importance))
+		tree := g.growNode(x, w, gradient, hessian, training, 0, gain)
+		g.trees = append(g.trees, tree)
+		gains = append(gains, gain)
+		for i := range score {
+			score[i] += g.LearningRate * findLeaf(tree, x[i]).value
+		}
+
+		g.trainingLoss = append(g.trainingLoss, boostingLoss(score, y, w, training))
+		if len(validation) == 0 {
+			g.bestRound = m + 1
+			continue
+		}
+
+		loss := boostingLoss(score, y, w, validation)
+		g.validationLoss = append(g.validationLoss, loss)
+		if loss < bestLoss-1e-9 {
+			bestLoss = loss
+			g.bestRound = m + 1
+		} else if m+1-g.bestRound >= g.Patience {
+			g.stoppedEarly = true
+			break
+		}
+	}
+
+	//Keep the trees of the best validation round, importance only counts their splits
+	g.trees = g.trees[:g.bestRound]
+	for _, gain := range gains[:g.bestRound] {
+		for f, val := range gain {
+			g.importance[f] += val
+		}
+	}
+
+	total = 0.0
+	for _, val := range g.importance {
+		total += val
+	}
+	if total > 0 {
+		for f := range g.importance {
+			g.importance[f] /= total
+		}
+	}
+
+	return nil
+}
+
+//Grow a tree of the gradients, the gain of every split is added to the gain of its feature
+func (g *GradientBoosting) growNode(x [][]float64, w []float64, gradient []float64, hessian []float64, indexes []int, depth int, gain []float64) *treeNode {
+	node := &treeNode{leaf: true}
+
+	sumGradient, sumHessian := 0.0, 0.0
+	for _, i := range indexes {
+		node.weight += w[i]
+		sumGradient += gradient[i]
+		sumHessian += hessian[i]
+	}
+	node.value = sumGradient / (sumHessian + boostingLambda)
+
+	if depth >= g.MaxDepth || node.weight < 2*g.MinLeaf {
+		return node
+	}
+
+	parentScore := sumGradient * sumGradient / (sumHessian + boostingLambda)
+	bestGain := 1e-12
+	bestFeature := -1
+	bestThreshold := 0.0
+
+	sorted := make([]int, len(indexes))
+	for f := range gain {
+		copy(sorted, indexes)
+		sort.Sort(byFeature{indexes: sorted, x: x, feature: f})
+
+		leftWeight, leftGradient, leftHessian := 0.0, 0.0, 0.0
+		for k := 0; k < len(sorted)-1; k++ {
+			i := sorted[k]
+			leftWeight += w[i]
+			leftGradient += gradient[i]
+			leftHessian += hessian[i]
+
+			//Only split between different values
+			current, next := x[i][f], x[sorted[k+1]][f]
+			if current == next {
+				continue
+			}
+
+			if leftWeight < g.MinLeaf || node.weight-leftWeight < g.MinLeaf {
+				continue
+			}
+
+			rightGradient, rightHessian := sumGradient-leftGradient, sumHessian-leftHessian
+			split := leftGradient*leftGradient/(leftHessian+boostingLambda) + rightGradient*rightGradient/(rightHessian+boostingLambda) - parentScore
+			if split > bestGain {
+				bestGain = split
+				bestFeature = f
+				bestThreshold = (current + next) / 2.0
+			}
+		}
+	}
+
+	if bestFeature == -1 {
+		return node
+	}
+
+	var left, right []int
+	for _, i := range indexes {
+		if x[i][bestFeature] <= bestThreshold {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+
+	gain[bestFeature] += bestGain
+
+	node.leaf = false
+	node.feature = bestFeature
+	node.threshold = bestThreshold
+	node.left = g.growNode(x, w, gradient, hessian, left, depth+1, gain)
+	node.right = g.growNode(x, w, gradient, hessian, right, depth+1, gain)
+
+	return node
+}
+
+//Weighted mean log loss of the scores of the given rows
+func boostingLoss(score []float64, y []float64, w []float64, indexes []int) float64 {
+	loss, total := 0.0, 0.0
+	for _, i := range indexes {
+		p := math.Min(math.Max(sigmoid(score[i]), 1e-15), 1.0-1e-15)
+		loss -= w[i] * (y[i]*math.Log(p) + (1.0-y[i])*math.Log(1.0-p))
+		total += w[i]
+	}
+
+	if total == 0 {
+		return 0.0
+	}
+
+	return loss / total
+}
+
+func sigmoid(z float64) float64 {
+	return 1.0 / (1.0 + math.Exp(-z))
+}
+
+func (g *GradientBoosting) PredictProba(data DataPoint) (float64, error) {
+	features := g.encode(data)
+
+	score := g.initial
+	for _, tree := range g.trees {
+		score += g.LearningRate * findLeaf(tree, features).value
+	}
+
+	return sigmoid(score), nil
+}
+
+func (g *GradientBoosting) Describe() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<div>Gradient-Boosted Trees with learning rate ")
+	buffer.WriteString(strconv.FormatFloat(g.LearningRate, 'f', -1, 64))
+	buffer.WriteString(", maximum depth ")
+	buffer.WriteString(strconv.Itoa(g.MaxDepth))
+	buffer.WriteString(" and log-loss objective</div>")
+
+	buffer.WriteString("<div>Trees: ")
+	buffer.WriteString(strconv.Itoa(len(g.trees)))
+	buffer.WriteString(" of ")
+	buffer.WriteString(strconv.Itoa(g.NumRounds))
+	if g.stoppedEarly {
+		buffer.WriteString(" (stopped early, no improvement of validation log loss in ")
+		buffer.WriteString(strconv.Itoa(g.Patience))
+		buffer.WriteString(" rounds)")
+	}
+	buffer.WriteString("</div>")
+
+	if g.validationCount > 0 {
+		buffer.WriteString("<div>Validation Split: ")
+		buffer.WriteString(strconv.Itoa(g.validationCount))
+		buffer.WriteString(" players</div>")
+	} else {
+		buffer.WriteString("<div>Not enough data for a validation split, early stopping disabled</div>")
+	}
+
+	buffer.WriteString(g.traceHTML())
+	buffer.WriteString(importanceHTML(g.featureNames(), g.importance))
+
+	return buffer.String()
+}
+
+//Training and validation log loss of every tenth round and the best round
+func (g *GradientBoosting) traceHTML() string {
+	var rounds []boostingRound
+	for m := range g.trainingLoss {
+		round := m + 1
+		if round%10 != 0 && round != 1 && round != g.bestRound && round != len(g.trainingLoss) {
+			continue
+		}
+
+		validation := math.NaN()
+		if m < len(g.validationLoss) {
+			validation = g.validationLoss[m]
+		}
+		rounds = append(rounds, boostingRound{round: round, training: g.trainingLoss[m], validation: validation})
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Boosting Log Loss</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Round</td>")
+	buffer.WriteString("<td>Training</td>")
+	buffer.WriteString("<td>Validation</td>")
+	buffer.WriteString("</tr>")
+
+	for _, round := range rounds {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(round.round))
+		if round.round == g.bestRound {
+			buffer.WriteString(" (best)")
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(round.training, 'f', 4, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if !math.IsNaN(round.validation) {
+			buffer.WriteString(strconv.FormatFloat(round.validation, 'f', 4, 64))
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
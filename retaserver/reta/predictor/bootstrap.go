@@ -0,0 +1,416 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+
+	"reta/errors"
+)
+
+//Bootstrap confidence intervals
+//
+//Training players are resampled with replacement and the model is refitted on every sample in parallel.
+//Intervals of coefficients, odds ratio, AUC and accuracy are taken from the distribution of the refitted values.
+//Metrics are computed on the testing data so only the variability of training is measured.
+//
+//References:
+// - Efron, B. and Tibshirani, R. (1993). An Introduction to the Bootstrap, chapter 13 and 14
+
+//Most jackknife refits of BCa: larger data deletes every group of players with the same index modulo the groups
+//instead of each player, which keeps the acceleration estimate close while bounding the number of refits
+const jackknifeGroups = 200
+
+type BootstrapInterval int
+
+const (
+	PercentileInterval BootstrapInterval = iota //2.5% and 97.5% quantile of the bootstrap values
+	BCaInterval                                 //Bias-corrected and accelerated percentile
+)
+
+func (b BootstrapInterval) String() string {
+	if b == BCaInterval {
+		return "BCa"
+	}
+
+	return "Percentile"
+}
+
+type Bootstrap struct {
+	Interval          BootstrapInterval
+	Samples           int //Successfully refitted samples
+	Failed            int //Samples which cannot be refitted (e.g. singular matrix or missing level)
+	LowerCoefficients []float64
+	UpperCoefficients []float64
+	LowerOddsRatio    []float64
+	UpperOddsRatio    []float64
+	HasMetrics        bool //Metric intervals need testing data
+	Accuracy          float64
+	LowerAccuracy     float64
+	UpperAccuracy     float64
+	AUC               float64
+	LowerAUC          float64
+	UpperAUC          float64
+	JackknifeGroups   int //Number of jackknife groups used for BCa acceleration
+}
+
+//Resample count of zero disables bootstrap
+func (r *Regression) SetBootstrap(samples int, interval BootstrapInterval, seed int64) {
+	r.bootstrapSamples = samples
+	r.bootstrapInterval = interval
+	r.bootstrapSeed = seed
+}
+
+//Statistics of a refitted model: coefficients followed by accuracy and AUC on testing data
+type bootstrapResult struct {
+	statistics []float64
+	ok         bool
+}
+
+//Generate bootstrap intervals for the already generated model, testData can be nil to skip the metrics
+func (r *Regression) Bootstrap(testData []DataPoint) error {
+	if r.bootstrapSamples <= 0 {
+		return nil
+	}
+
+	if len(r.model.Coefficients) == 0 {
+		return errors.New("Error: Coefficients in models are not generated yet")
+	}
+
+	numCoefficients := len(r.model.Coefficients)
+	hasMetrics := len(testData) > 0
+
+	//Statistics of the generated model, keeping the unseen levels of the last scoring pass
+	unseen := r.unseenLevels
+	estimate, ok := r.bootstrapStatistics(r, testData)
+	r.unseenLevels = unseen
+	if !ok {
+		return errors.New("Error: Model cannot be evaluated for bootstrap")
+	}
+
+	//Resample in parallel, each sample has its own seed so the result does not depend on scheduling
+	numData := len(r.dataPoints)
+	results := r.parallelRefit(r.bootstrapSamples, testData, func(sample int) []DataPoint {
+		random := rand.New(rand.NewSource(r.bootstrapSeed + int64(sample)))
+		points := make([]DataPoint, numData)
+		for i := range points {
+			points[i] = r.dataPoints[random.Intn(numData)]
+		}
+		return points
+	})
+
+	var replicates [][]float64
+	failed := 0
+	for _, result := range results {
+		if result.ok {
+			replicates = append(replicates, result.statistics)
+		} else {
+			failed++
+		}
+	}
+
+	if len(replicates) < 2 {
+		return errors.New("Error: Not enough bootstrap samples can be refitted")
+	}
+
+	//Jackknife for BCa acceleration, large data is deleted in groups
+	var jackknife [][]float64
+	if r.bootstrapInterval == BCaInterval {
+		groups := numData
+		if groups > jackknifeGroups {
+			groups = jackknifeGroups
+		}
+
+		results = r.parallelRefit(groups, testData, func(group int) []DataPoint {
+			var points []DataPoint
+			for i, data := range r.dataPoints {
+				if i%groups != group {
+					points = append(points, data)
+				}
+			}
+			return points
+		})
+
+		for _, result := range results {
+			if result.ok {
+				jackknife = append(jackknife, result.statistics)
+			}
+		}
+	}
+
+	bootstrap := Bootstrap{Interval: r.bootstrapInterval, Samples: len(replicates), Failed: failed, HasMetrics: hasMetrics}
+	bootstrap.JackknifeGroups = len(jackknife)
+	bootstrap.LowerCoefficients = make([]float64, numCoefficients)
+	bootstrap.UpperCoefficients = make([]float64, numCoefficients)
+	bootstrap.LowerOddsRatio = make([]float64, numCoefficients)
+	bootstrap.UpperOddsRatio = make([]float64, numCoefficients)
+
+	numStatistics := len(estimate)
+	for s := 0; s < numStatistics; s++ {
+		values := column(replicates, s)
+		var lower, upper float64
+		if r.bootstrapInterval == BCaInterval && len(jackknife) > 1 {
+			lower, upper = bcaInterval(values, estimate[s], column(jackknife, s), 0.05)
+		} else {
+			lower, upper = percentileInterval(values, 0.05)
+		}
+
+		if s < numCoefficients {
+			//Both intervals respect transformation so odds ratio is just exponential of the coefficient interval
+			bootstrap.LowerCoefficients[s] = lower
+			bootstrap.UpperCoefficients[s] = upper
+			bootstrap.LowerOddsRatio[s] = math.Exp(lower)
+			bootstrap.UpperOddsRatio[s] = math.Exp(upper)
+		} else if s == numCoefficients {
+			bootstrap.Accuracy = estimate[s]
+			bootstrap.LowerAccuracy = lower
+			bootstrap.UpperAccuracy = upper
+		} else {
+			bootstrap.AUC = estimate[s]
+			bootstrap.LowerAUC = lower
+			bootstrap.UpperAUC = upper
+		}
+	}
+
+	r.model.Bootstrap = &bootstrap
+
+	if r.debugMode {
+		r.debugContext.Infof("\nBootstrap: %+v", bootstrap)
+	}
+
+	return nil
+}
+
+//Refit the model on every sample using one goroutine per CPU
+func (r *Regression) parallelRefit(count int, testData []DataPoint, sample func(int) []DataPoint) []bootstrapResult {
+	results := make([]bootstrapResult, count)
+
+	jobs := make(chan int)
+	var wait sync.WaitGroup
+
+	workers := runtime.NumCPU()
+	for w := 0; w < workers; w++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for index := range jobs {
+				results[index] = r.refit(sample(index), testData)
+			}
+		}()
+	}
+
+	for index := 0; index < count; index++ {
+		jobs <- index
+	}
+	close(jobs)
+	wait.Wait()
+
+	return results
+}
+
+//Fit a model on the given data using the preprocessing of this model
+func (r *Regression) refit(points []DataPoint, testData []DataPoint) bootstrapResult {
+	refitted := r.cloneSettings()
+	refitted.presetPreprocessing = true
+	refitted.model.Imputation = r.model.Imputation
+	refitted.model.Outliers = r.model.Outliers
+	refitted.model.Scaling = r.model.Scaling
+	refitted.model.Factors = r.model.Factors
+
+	//Every dummy variable needs at least one player, otherwise the matrix is singular
+	for f, factor := range r.model.Factors {
+		for _, level := range factor.Levels {
+			found := false
+			for _, data := range points {
+				if data.Categories[f] == level {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return bootstrapResult{}
+			}
+		}
+	}
+
+	for _, data := range points {
+		if refitted.AddDataPoint(data) != nil {
+			return bootstrapResult{}
+		}
+	}
+
+	if _, err := refitted.GenerateModel(r.iteration); err != nil {
+		return bootstrapResult{}
+	}
+
+	statistics, ok := r.bootstrapStatistics(&refitted, testData)
+	return bootstrapResult{statistics: statistics, ok: ok}
+}
+
+func (r *Regression) bootstrapStatistics(model *Regression, testData []DataPoint) ([]float64, bool) {
+	if len(model.model.Coefficients) != len(r.model.Coefficients) {
+		return nil, false
+	}
+
+	statistics := make([]float64, len(model.model.Coefficients))
+	copy(statistics, model.model.Coefficients)
+
+	if len(testData) > 0 {
+		evaluation, err := model.Evaluate(testData)
+		if err != nil {
+			return nil, false
+		}
+		statistics = append(statistics, evaluation.Accuracy, evaluation.AUC)
+	}
+
+	for _, val := range statistics {
+		if math.IsInf(val, 0) {
+			return nil, false
+		}
+	}
+
+	return statistics, true
+}
+
+func column(rows [][]float64, index int) []float64 {
+	values := make([]float64, 0, len(rows))
+	for _, row := range rows {
+		if !math.IsNaN(row[index]) {
+			values = append(values, row[index])
+		}
+	}
+
+	return values
+}
+
+func percentileInterval(values []float64, alpha float64) (float64, float64) {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	return quantile(sorted, alpha/2), quantile(sorted, 1-alpha/2)
+}
+
+//BCa interval adjusts the percentiles using
+//- bias correction z0 = inv(Phi)(proportion of bootstrap values below the estimate)
+//- acceleration a = TotalAddition(mean - jackknife)^3 / (6 * (TotalAddition(mean - jackknife)^2)^1.5)
+func bcaInterval(values []float64, estimate float64, jackknife []float64, alpha float64) (float64, float64) {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	if len(sorted) == 0 || math.IsNaN(estimate) {
+		return math.NaN(), math.NaN()
+	}
+
+	//Bias correction, ties count as half
+	below := 0.0
+	for _, val := range sorted {
+		if val < estimate {
+			below += 1.0
+		} else if val == estimate {
+			below += 0.5
+		}
+	}
+	z0 := normalQuantile(below / float64(len(sorted)))
+
+	//Acceleration
+	mean := 0.0
+	for _, val := range jackknife {
+		mean += val
+	}
+	mean /= float64(len(jackknife))
+
+	numerator, denominator := 0.0, 0.0
+	for _, val := range jackknife {
+		diff := mean - val
+		numerator += diff * diff * diff
+		denominator += diff * diff
+	}
+
+	acceleration := 0.0
+	if denominator > 0 {
+		acceleration = numerator / (6.0 * math.Pow(denominator, 1.5))
+	}
+
+	//Estimate at the edge of the bootstrap distribution, fall back to percentile
+	if math.IsInf(z0, 0) {
+		return quantile(sorted, alpha/2), quantile(sorted, 1-alpha/2)
+	}
+
+	adjust := func(q float64) float64 {
+		z := normalQuantile(q)
+		return normalCDF(z0 + (z0+z)/(1-acceleration*(z0+z)))
+	}
+
+	return quantile(sorted, adjust(alpha/2)), quantile(sorted, adjust(1-alpha/2))
+}
+
+//Table of bootstrap intervals
+func (r *Regression) bootstrapHTML() string {
+	bootstrap := r.model.Bootstrap
+	if bootstrap == nil {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("<br/><div><h3>Bootstrap 95% Confidence Intervals (")
+	buffer.WriteString(bootstrap.Interval.String())
+	buffer.WriteString(")</h3></div>")
+	buffer.WriteString("<div>Refitted samples: ")
+	buffer.WriteString(strconv.Itoa(bootstrap.Samples))
+	buffer.WriteString(", failed: ")
+	buffer.WriteString(strconv.Itoa(bootstrap.Failed))
+	buffer.WriteString("</div>")
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Coefficient</td>")
+	buffer.WriteString("<td>Lower Coefficient</td>")
+	buffer.WriteString("<td>Upper Coefficient</td>")
+	buffer.WriteString("<td>Lower Odds Ratio</td>")
+	buffer.WriteString("<td>Upper Odds Ratio</td>")
+	buffer.WriteString("</tr>")
+
+	names := append([]string{"Intercept"}, r.designNames()...)
+	for i, name := range names {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		for _, val := range []float64{r.model.Coefficients[i], bootstrap.LowerCoefficients[i], bootstrap.UpperCoefficients[i], bootstrap.LowerOddsRatio[i], bootstrap.UpperOddsRatio[i]} {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(val, 'f', 6, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	if bootstrap.HasMetrics {
+		buffer.WriteString("<div>Accuracy: ")
+		buffer.WriteString(strconv.FormatFloat(bootstrap.Accuracy, 'f', 2, 64))
+		buffer.WriteString(" (")
+		buffer.WriteString(strconv.FormatFloat(bootstrap.LowerAccuracy, 'f', 2, 64))
+		buffer.WriteString(" - ")
+		buffer.WriteString(strconv.FormatFloat(bootstrap.UpperAccuracy, 'f', 2, 64))
+		buffer.WriteString(")</div>")
+		buffer.WriteString("<div>AUC: ")
+		buffer.WriteString(strconv.FormatFloat(bootstrap.AUC, 'f', 4, 64))
+		buffer.WriteString(" (")
+		buffer.WriteString(strconv.FormatFloat(bootstrap.LowerAUC, 'f', 4, 64))
+		buffer.WriteString(" - ")
+		buffer.WriteString(strconv.FormatFloat(bootstrap.UpperAUC, 'f', 4, 64))
+		buffer.WriteString(")</div>")
+	}
+
+	return buffer.String()
+}
@@ -0,0 +1,310 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strconv"
+
+	"github.com/skelterjohn/go.matrix"
+)
+
+//Categorical variables using reference-level dummy (one-hot) encoding
+//
+//Each categorical variable (factor) with k levels becomes k-1 dummy variables appended after the
+//numerical variables. The most frequent level in training data is the reference level and has no dummy.
+//Levels not seen in training data are scored as the reference level.
+
+type Factor struct {
+	Name      string
+	Reference string   //Level without dummy variable
+	Levels    []string //Level of each dummy variable
+}
+
+//Grouped test for all dummy variables of a factor
+type FactorTest struct {
+	Name                  string
+	DegreesOfFreedom      int
+	WaldStatistic         float64
+	WaldPValue            float64
+	LikelihoodRatio       float64
+	LikelihoodRatioPValue float64
+}
+
+func (r *Regression) AddCategoricalVariable(name string) {
+	r.categoricalNames = append(r.categoricalNames, name)
+}
+
+//Number of levels not in the training data seen by the last Evaluate, EvaluateClasses or TestModel, and by Predict since then
+func (r *Regression) UnseenLevels() int {
+	return r.unseenLevels
+}
+
+//Collect the levels of every factor from the training data
+func (r *Regression) computeFactors() {
+	r.model.Factors = make([]Factor, len(r.categoricalNames))
+
+	for f, name := range r.categoricalNames {
+		counts := make(map[string]float64)
+		for _, data := range r.dataPoints {
+			counts[data.Categories[f]] += data.weight()
+		}
+
+		levels := make([]string, 0, len(counts))
+		for level := range counts {
+			levels = append(levels, level)
+		}
+		sort.Strings(levels)
+
+		//Most frequent (by sample weight) level as reference
+		reference := ""
+		for _, level := range levels {
+			if reference == "" || counts[level] > counts[reference] {
+				reference = level
+			}
+		}
+
+		factor := Factor{Name: name, Reference: reference}
+		for _, level := range levels {
+			if level != reference {
+				factor.Levels = append(factor.Levels, level)
+			}
+		}
+
+		r.model.Factors[f] = factor
+	}
+
+	if r.debugMode && len(r.model.Factors) > 0 {
+		r.debugContext.Infof("\nFactors: %+v", r.model.Factors)
+	}
+}
+
+//Variables used in the design matrix: formula terms of the preprocessed and scaled numerical variables, missing indicators
+//and dummy variables
+func (r *Regression) designVariables(data DataPoint) []float64 {
+	variables := r.scaleVariables(r.preprocessVariables(data))
+
+	if r.interceptOnly {
+		variables = []float64{}
+	} else if len(r.terms) > 0 {
+		values := make([]float64, len(r.terms))
+		for i, term := range r.terms {
+			values[i] = term.Value(variables)
+		}
+		variables = values
+	}
+
+	if r.numIndicators() > 0 {
+		values := make([]float64, len(variables), len(variables)+r.numIndicators())
+		copy(values, variables)
+		variables = append(values, r.missingIndicators(data)...)
+	}
+
+	if len(r.model.Factors) == 0 {
+		return variables
+	}
+
+	design := make([]float64, len(variables), len(variables)+r.numDummies())
+	copy(design, variables)
+
+	for f, factor := range r.model.Factors {
+		level := ""
+		if f < len(data.Categories) {
+			level = data.Categories[f]
+		}
+
+		found := level == factor.Reference
+		for _, dummy := range factor.Levels {
+			if dummy == level {
+				design = append(design, 1.0)
+				found = true
+			} else {
+				design = append(design, 0.0)
+			}
+		}
+
+		if !found {
+			r.unseenLevels++
+		}
+	}
+
+	return design
+}
+
+//Name of every variable in the design matrix
+func (r *Regression) designNames() []string {
+	names := make([]string, 0, len(r.variableNames)+r.numDummies())
+	if len(r.terms) > 0 && !r.interceptOnly {
+		for _, term := range r.terms {
+			names = append(names, term.Name(r.variableNames))
+		}
+	} else if !r.interceptOnly {
+		names = append(names, r.variableNames...)
+	}
+
+	if !r.interceptOnly {
+		for _, index := range r.model.Imputation.Indicators {
+			names = append(names, r.variableNames[index]+" Missing")
+		}
+	}
+
+	for _, factor := range r.model.Factors {
+		for _, level := range factor.Levels {
+			names = append(names, factor.Name+" = "+level)
+		}
+	}
+
+	return names
+}
+
+//Number of formula terms (or variables) in the design matrix
+func (r *Regression) numTerms() int {
+	if r.interceptOnly {
+		return 0
+	} else if len(r.terms) > 0 {
+		return len(r.terms)
+	}
+
+	return len(r.variableNames)
+}
+
+func (r *Regression) numDummies() int {
+	total := 0
+	for _, factor := range r.model.Factors {
+		total += len(factor.Levels)
+	}
+	return total
+}
+
+//Test all dummy variables of each factor together
+//- Wald: W = b' inv(V) b where b and V are the coefficients and covariance of the dummy variables
+//- Likelihood ratio: LR = 2 * (ln LF full - ln LF reduced) where the reduced model is fitted without the factor
+//Both follow chi-square distribution with (levels - 1) degree of freedom.
+func (r *Regression) computeFactorTests(iteration int) error {
+	r.model.FactorTests = nil
+
+	offset := len(r.designNames()) - r.numDummies() + 1 //Intercept and numerical variables
+	for f, factor := range r.model.Factors {
+		//Dummy variables of the factor follow the ones of the previous factors
+		df := len(factor.Levels)
+		start := offset
+		offset += df
+		if df == 0 {
+			continue
+		}
+
+		test := FactorTest{Name: factor.Name, DegreesOfFreedom: df}
+
+		//Wald
+		if len(r.model.Covariance) == len(r.model.Coefficients) {
+			bVector := matrix.Zeros(df, 1)
+			vMatrix := matrix.Zeros(df, df)
+			for i := 0; i < df; i++ {
+				bVector.Set(i, 0, r.model.Coefficients[start+i])
+				for j := 0; j < df; j++ {
+					vMatrix.Set(i, j, r.model.Covariance[start+i][start+j])
+				}
+			}
+
+			inverse := matrix.Inverse(vMatrix)
+			if inverse != nil {
+				wald := matrix.Product(matrix.Transpose(bVector), inverse, bVector).Get(0, 0)
+				test.WaldStatistic = wald
+				test.WaldPValue = chiSquarePValue(wald, df)
+			}
+		}
+
+		//Likelihood ratio against the model without this factor
+		reduced := r.cloneSettings()
+		reduced.categoricalNames = nil
+		for g, name := range r.categoricalNames {
+			if g != f {
+				reduced.AddCategoricalVariable(name)
+			}
+		}
+
+		for _, data := range r.dataPoints {
+			point := DataPoint{Result: data.Result, Variables: data.Variables, Weight: data.Weight, Missing: data.Missing}
+			for g, level := range data.Categories {
+				if g != f {
+					point.Categories = append(point.Categories, level)
+				}
+			}
+
+			err := reduced.AddDataPoint(point)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err := reduced.GenerateModel(iteration)
+		if err == nil {
+			lr := 2.0 * (r.model.LogLikelihood - reduced.model.LogLikelihood)
+			test.LikelihoodRatio = lr
+			test.LikelihoodRatioPValue = chiSquarePValue(lr, df)
+		} else if r.debugMode {
+			r.debugContext.Infof("\nReduced model without %s cannot be generated: %v", factor.Name, err)
+		}
+
+		r.model.FactorTests = append(r.model.FactorTests, test)
+	}
+
+	return nil
+}
+
+//Table of grouped factor tests
+func (r *Regression) factorTestsHTML() string {
+	if len(r.model.FactorTests) == 0 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("<br/><div><h3>Categorical Variables</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Reference Level</td>")
+	buffer.WriteString("<td>Degree of Freedom</td>")
+	buffer.WriteString("<td>Wald Chi-Square</td>")
+	buffer.WriteString("<td>Wald p-Value</td>")
+	buffer.WriteString("<td>Likelihood Ratio</td>")
+	buffer.WriteString("<td>LR p-Value</td>")
+	buffer.WriteString("</tr>")
+
+	for _, test := range r.model.FactorTests {
+		reference := ""
+		for _, factor := range r.model.Factors {
+			if factor.Name == test.Name {
+				reference = factor.Reference
+			}
+		}
+
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(test.Name))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(reference))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(test.DegreesOfFreedom))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(test.WaldStatistic, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(test.WaldPValue, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(test.LikelihoodRatio, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(test.LikelihoodRatioPValue, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,86 @@
+package predictor
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/skelterjohn/go.matrix"
+)
+
+//Wald statistic of the dummy variables from start to start+df of the fitted model
+func waldStatistic(r *Regression, start int, df int) float64 {
+	bVector := matrix.Zeros(df, 1)
+	vMatrix := matrix.Zeros(df, df)
+	for i := 0; i < df; i++ {
+		bVector.Set(i, 0, r.model.Coefficients[start+i])
+		for j := 0; j < df; j++ {
+			vMatrix.Set(i, j, r.model.Covariance[start+i][start+j])
+		}
+	}
+
+	return matrix.Product(matrix.Transpose(bVector), matrix.Inverse(vMatrix), bVector).Get(0, 0)
+}
+
+func TestFactorTestsOfTwoFactors(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	var r Regression
+	r.Initialize(1)
+	r.SetVariableName(0, "Tutorial Momentum")
+	r.AddCategoricalVariable("App Version")
+	r.AddCategoricalVariable("Player Segment")
+
+	//Version changes retention, the segment does not
+	versions := []string{"1.0", "1.1"}
+	segments := []string{"Segment 1", "Segment 2", "Segment 3"}
+	for i := 0; i < 600; i++ {
+		version := versions[random.Intn(len(versions))]
+		segment := segments[random.Intn(len(segments))]
+		momentum := random.NormFloat64()
+
+		logit := 0.5*momentum - 1.0
+		if version == "1.1" {
+			logit += 2.0
+		}
+
+		result := 0.0
+		if random.Float64() < 1.0/(1.0+math.Exp(-logit)) {
+			result = 1.0
+		}
+
+		err := r.AddDataPoint(DataPoint{Result: result, Variables: []float64{momentum}, Categories: []string{version, segment}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := r.GenerateModel(20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.model.FactorTests) != 2 {
+		t.Fatalf("Factor tests: %d, want 2", len(r.model.FactorTests))
+	}
+
+	//Dummy variables follow the intercept and the numerical variable, factor by factor
+	start := 2
+	for f, test := range r.model.FactorTests {
+		df := len(r.model.Factors[f].Levels)
+		if test.DegreesOfFreedom != df {
+			t.Fatalf("%s: degrees of freedom %d, want %d", test.Name, test.DegreesOfFreedom, df)
+		}
+
+		wald := waldStatistic(&r, start, df)
+		if math.Abs(test.WaldStatistic-wald) > 1e-9 {
+			t.Errorf("%s: Wald statistic %v, want %v from its own dummy variables", test.Name, test.WaldStatistic, wald)
+		}
+
+		start += df
+	}
+
+	if r.model.FactorTests[0].WaldPValue > 0.001 || r.model.FactorTests[1].WaldPValue < 0.001 {
+		t.Errorf("Wald p-values %v and %v, want only the version significant", r.model.FactorTests[0].WaldPValue, r.model.FactorTests[1].WaldPValue)
+	}
+}
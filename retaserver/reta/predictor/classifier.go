@@ -0,0 +1,244 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"reta/errors"
+)
+
+//Common interface of the retention classifiers
+//
+//Every classifier is fitted on training data points and gives the probability of a data point being
+//retained, so all of them are validated and evaluated the same way.
+
+type Classifier interface {
+	Fit(trainingData []DataPoint) error
+	PredictProba(data DataPoint) (float64, error)
+	Describe() string //HTML description of the fitted model
+}
+
+type ClassificationMethod int
+
+const (
+	LogisticRegressionMethod ClassificationMethod = iota
+	DecisionTreeMethod
+	RandomForestMethod
+	NaiveBayesMethod
+	GradientBoostingMethod
+)
+
+var classificationMethods = []ClassificationMethod{LogisticRegressionMethod, DecisionTreeMethod, RandomForestMethod, NaiveBayesMethod, GradientBoostingMethod}
+
+func (m ClassificationMethod) String() string {
+	switch m {
+	case DecisionTreeMethod:
+		return "Decision Tree"
+	case RandomForestMethod:
+		return "Random Forest"
+	case NaiveBayesMethod:
+		return "Gaussian Naive Bayes"
+	case GradientBoostingMethod:
+		return "Gradient-Boosted Trees"
+	default:
+		return "Logistic Regression"
+	}
+}
+
+//Logistic regression as a classifier, variable names and settings must be set first
+func (r *Regression) Fit(trainingData []DataPoint) error {
+	for _, data := range trainingData {
+		err := r.AddDataPoint(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	iteration := r.iteration
+	if iteration == 0 {
+		iteration = 20
+	}
+
+	_, err := r.GenerateModel(iteration)
+	return err
+}
+
+func (r *Regression) PredictProba(data DataPoint) (float64, error) {
+	return r.Predict(data)
+}
+
+func (r *Regression) Describe() string {
+	return r.StringHTML()
+}
+
+//Evaluate any classifier against the testing data
+func evaluateClassifier(classifier Classifier, testData []DataPoint) (Evaluation, error) {
+	if len(testData) == 0 {
+		return Evaluation{}, errors.New("Error: Need some testing data to evaluate model")
+	}
+
+	probabilities := make([]float64, len(testData))
+	observed := make([]float64, len(testData))
+	for i, data := range testData {
+		predicted, err := classifier.PredictProba(data)
+		if err != nil {
+			return Evaluation{}, err
+		}
+		probabilities[i] = predicted
+		observed[i] = data.Result
+	}
+
+	return evaluateProbabilities(probabilities, observed), nil
+}
+
+//Feature vector used by the tree and Bayes classifiers: numerical variables with missing values replaced
+//by the training mean, followed by one indicator for every level of every categorical variable
+type featureEncoder struct {
+	variableNames    []string
+	categoricalNames []string
+	means            []float64  //Training mean of each numerical variable
+	levels           [][]string //Training levels of each categorical variable
+}
+
+func (e *featureEncoder) SetFeatureNames(variableNames []string, categoricalNames []string) {
+	e.variableNames = variableNames
+	e.categoricalNames = categoricalNames
+}
+
+func (e *featureEncoder) fitFeatures(trainingData []DataPoint) error {
+	if len(trainingData) == 0 {
+		return errors.New("Error: Need some data to fit the classifier")
+	}
+
+	numVariables := len(e.variableNames)
+	e.means = make([]float64, numVariables)
+	for j := 0; j < numVariables; j++ {
+		total, count := 0.0, 0
+		for _, data := range trainingData {
+			if len(data.Variables) != numVariables {
+				return errors.New("Error: Number of variables in the data != in the model")
+			}
+			if !data.isMissing(j) {
+				total += data.Variables[j]
+				count++
+			}
+		}
+		if count > 0 {
+			e.means[j] = total / float64(count)
+		}
+	}
+
+	e.levels = make([][]string, len(e.categoricalNames))
+	for f := range e.categoricalNames {
+		seen := make(map[string]bool)
+		for _, data := range trainingData {
+			if len(data.Categories) != len(e.categoricalNames) {
+				return errors.New("Error: Number of categorical variables in the data != in the model")
+			}
+			if !seen[data.Categories[f]] {
+				seen[data.Categories[f]] = true
+				e.levels[f] = append(e.levels[f], data.Categories[f])
+			}
+		}
+		sort.Strings(e.levels[f])
+	}
+
+	return nil
+}
+
+func (e *featureEncoder) encode(data DataPoint) []float64 {
+	features := make([]float64, 0, len(e.featureNames()))
+	for j := range e.variableNames {
+		if j >= len(data.Variables) || data.isMissing(j) {
+			features = append(features, e.means[j])
+		} else {
+			features = append(features, data.Variables[j])
+		}
+	}
+
+	for f, levels := range e.levels {
+		for _, level := range levels {
+			if f < len(data.Categories) && data.Categories[f] == level {
+				features = append(features, 1.0)
+			} else {
+				features = append(features, 0.0)
+			}
+		}
+	}
+
+	return features
+}
+
+func (e *featureEncoder) featureNames() []string {
+	names := make([]string, 0, len(e.variableNames))
+	names = append(names, e.variableNames...)
+	for f, levels := range e.levels {
+		for _, level := range levels {
+			names = append(names, e.categoricalNames[f]+" = "+level)
+		}
+	}
+
+	return names
+}
+
+//Mean metrics of every method on the same folds
+type MethodComparison struct {
+	Method      ClassificationMethod
+	Evaluations []Evaluation
+	Err         error
+}
+
+func comparisonHTML(comparisons []MethodComparison) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Method Comparison (mean of testing folds)</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Method</td>")
+	for _, name := range evaluationNames {
+		buffer.WriteString("<td>")
+		buffer.WriteString(name)
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for _, comparison := range comparisons {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(comparison.Method.String())
+		buffer.WriteString("</td>")
+
+		if comparison.Err != nil {
+			buffer.WriteString("<td colspan=\"")
+			buffer.WriteString(strconv.Itoa(len(evaluationNames)))
+			buffer.WriteString("\">")
+			buffer.WriteString(html.EscapeString(comparison.Err.Error()))
+			buffer.WriteString("</td>")
+		} else {
+			mean, _ := summarizeEvaluations(comparison.Evaluations)
+			for _, val := range mean.values() {
+				buffer.WriteString("<td>")
+				buffer.WriteString(strconv.FormatFloat(val, 'f', 4, 64))
+				buffer.WriteString("</td>")
+			}
+		}
+
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
+
+//Random source of a classifier, a fixed one when not given so the result is still reproducible
+func classifierRandom(random *rand.Rand) *rand.Rand {
+	if random == nil {
+		return rand.New(rand.NewSource(1))
+	}
+
+	return random
+}
@@ -0,0 +1,351 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//Multicollinearity diagnostics computed before fitting
+//
+//Variables which are (nearly) linear combinations of each other make X'WX close to singular,
+//the coefficients become unstable and their standard errors explode.
+//
+// - Correlation matrix of the design variables
+// - Variance inflation factor VIF[j] = 1 / (1 - R²[j]) where R²[j] is from regressing variable j on the others,
+//   equal to the diagonal of the inverse correlation matrix
+// - Condition number sqrt(largest eigenvalue / smallest eigenvalue) of the correlation matrix
+//
+//References:
+// - Belsley, D., Kuh, E. and Welsch, R. (1980). Regression Diagnostics. Wiley
+// - http://en.wikipedia.org/wiki/Variance_inflation_factor
+
+const (
+	vifThreshold       = 10.0 //Variables above this are reported as problematic
+	conditionThreshold = 30.0 //Moderate to strong dependencies above this
+)
+
+type Collinearity struct {
+	Names           []string
+	Correlations    [][]float64
+	VIF             []float64 //+Inf when the variable is an exact linear combination of the others
+	ConditionNumber float64
+	Problems        []string //Variables with VIF above the threshold
+	Dropped         []string //Variables removed before fitting when automatic drop is enabled
+}
+
+//Drop the variable with the highest VIF before fitting until every VIF is below the threshold, not used by streaming solvers
+func (r *Regression) SetCollinearityDrop(drop bool) {
+	r.collinearityDrop = drop
+}
+
+//Compute diagnostics of the design variables, drop formula terms first if enabled
+func (r *Regression) computeCollinearity() {
+	names := r.designNames()
+	numData := len(r.dataPoints)
+	numTerms := r.numTerms()
+
+	design := make([][]float64, numData)
+	for i, data := range r.dataPoints {
+		design[i] = r.designVariables(data)
+	}
+
+	//Every column starts active, dummy variables are never dropped
+	active := make([]int, len(names))
+	for j := range active {
+		active[j] = j
+	}
+
+	collinearity := collinearityOf(design, active, names)
+
+	var dropped []int
+	current := collinearity
+	for r.collinearityDrop {
+		worst := -1
+		for k, column := range active {
+			if column < numTerms && current.VIF[k] > vifThreshold && (worst == -1 || current.VIF[k] > current.VIF[worst]) {
+				worst = k
+			}
+		}
+		if worst == -1 {
+			break
+		}
+
+		dropped = append(dropped, active[worst])
+		collinearity.Dropped = append(collinearity.Dropped, names[active[worst]])
+		active = append(active[:worst], active[worst+1:]...)
+		current = collinearityOf(design, active, names)
+	}
+
+	//Keep the remaining terms in the model
+	if len(dropped) > 0 {
+		allTerms := r.selectionTerms()
+		var terms []Term
+		for j := 0; j < numTerms; j++ {
+			keep := true
+			for _, column := range dropped {
+				if column == j {
+					keep = false
+				}
+			}
+			if keep {
+				terms = append(terms, allTerms[j])
+			}
+		}
+
+		r.terms = terms
+		r.interceptOnly = len(terms) == 0
+		r.pruneIndicators()
+	}
+
+	r.model.Collinearity = &collinearity
+
+	if r.debugMode {
+		r.debugContext.Infof("\nVariance inflation factors: %v\nCondition number: %v\nDropped: %v", collinearity.VIF, collinearity.ConditionNumber, collinearity.Dropped)
+	}
+}
+
+//Diagnostics of the given columns of the design matrix
+func collinearityOf(design [][]float64, columns []int, names []string) Collinearity {
+	numData := len(design)
+	numColumns := len(columns)
+
+	collinearity := Collinearity{ConditionNumber: 1.0}
+	collinearity.Correlations = make([][]float64, numColumns)
+	collinearity.VIF = make([]float64, numColumns)
+	for k, column := range columns {
+		collinearity.Names = append(collinearity.Names, names[column])
+		collinearity.Correlations[k] = make([]float64, numColumns)
+	}
+
+	if numColumns == 0 || numData < 2 {
+		return collinearity
+	}
+
+	//Standardize every column
+	constant := make([]bool, numColumns)
+	standardized := make([][]float64, numColumns)
+	for k, column := range columns {
+		mean := 0.0
+		for i := 0; i < numData; i++ {
+			mean += design[i][column]
+		}
+		mean /= float64(numData)
+
+		deviation := 0.0
+		for i := 0; i < numData; i++ {
+			deviation += (design[i][column] - mean) * (design[i][column] - mean)
+		}
+		deviation = math.Sqrt(deviation)
+
+		standardized[k] = make([]float64, numData)
+		if deviation == 0 {
+			constant[k] = true
+			continue
+		}
+		for i := 0; i < numData; i++ {
+			standardized[k][i] = (design[i][column] - mean) / deviation
+		}
+	}
+
+	for a := 0; a < numColumns; a++ {
+		collinearity.Correlations[a][a] = 1.0
+		for b := a + 1; b < numColumns; b++ {
+			correlation := 0.0
+			for i := 0; i < numData; i++ {
+				correlation += standardized[a][i] * standardized[b][i]
+			}
+			collinearity.Correlations[a][b] = correlation
+			collinearity.Correlations[b][a] = correlation
+		}
+	}
+
+	//VIF[j] = TotalAddition(V[j][k]² / eigenvalue[k]), zero eigenvalues mean exact dependencies
+	values, vectors := symmetricEigen(collinearity.Correlations)
+
+	largest := 0.0
+	for _, value := range values {
+		largest = math.Max(largest, value)
+	}
+	tolerance := largest * 1e-10
+
+	smallest := math.Inf(1)
+	for _, value := range values {
+		smallest = math.Min(smallest, value)
+	}
+	if smallest <= tolerance {
+		collinearity.ConditionNumber = math.Inf(1)
+	} else {
+		collinearity.ConditionNumber = math.Sqrt(largest / smallest)
+	}
+
+	for j := 0; j < numColumns; j++ {
+		vif := 0.0
+		for k, value := range values {
+			loading := vectors[j][k] * vectors[j][k]
+			if value <= tolerance {
+				if loading > 1e-12 {
+					vif = math.Inf(1)
+					break
+				}
+				continue
+			}
+			vif += loading / value
+		}
+
+		//Constant variable is a multiple of the intercept
+		if constant[j] {
+			vif = math.Inf(1)
+		}
+
+		collinearity.VIF[j] = vif
+		if vif > vifThreshold {
+			collinearity.Problems = append(collinearity.Problems, collinearity.Names[j])
+		}
+	}
+
+	return collinearity
+}
+
+//Eigenvalues and eigenvectors (as columns) of a symmetric matrix using cyclic Jacobi rotations
+func symmetricEigen(a [][]float64) ([]float64, [][]float64) {
+	n := len(a)
+
+	m := make([][]float64, n)
+	v := make([][]float64, n)
+	for i := 0; i < n; i++ {
+		m[i] = make([]float64, n)
+		copy(m[i], a[i])
+		v[i] = make([]float64, n)
+		v[i][i] = 1.0
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		offDiagonal := 0.0
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				offDiagonal += m[p][q] * m[p][q]
+			}
+		}
+		if offDiagonal < 1e-22 {
+			break
+		}
+
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				if m[p][q] == 0 {
+					continue
+				}
+
+				//Rotation angle which zeroes m[p][q]
+				theta := (m[q][q] - m[p][p]) / (2.0 * m[p][q])
+				t := 1.0 / (math.Abs(theta) + math.Sqrt(theta*theta+1.0))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1.0 / math.Sqrt(t*t+1.0)
+				s := t * c
+
+				for k := 0; k < n; k++ {
+					mkp, mkq := m[k][p], m[k][q]
+					m[k][p] = c*mkp - s*mkq
+					m[k][q] = s*mkp + c*mkq
+				}
+				for k := 0; k < n; k++ {
+					mpk, mqk := m[p][k], m[q][k]
+					m[p][k] = c*mpk - s*mqk
+					m[q][k] = s*mpk + c*mqk
+				}
+				for k := 0; k < n; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p] = c*vkp - s*vkq
+					v[k][q] = s*vkp + c*vkq
+				}
+			}
+		}
+	}
+
+	values := make([]float64, n)
+	for i := 0; i < n; i++ {
+		values[i] = m[i][i]
+	}
+
+	return values, v
+}
+
+//Warning, VIF table and correlation matrix
+func (r *Regression) collinearityHTML() string {
+	collinearity := r.model.Collinearity
+	if collinearity == nil || len(collinearity.Names) < 2 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Multicollinearity Diagnostics</h3></div>")
+
+	if len(collinearity.Problems) > 0 || collinearity.ConditionNumber > conditionThreshold {
+		buffer.WriteString("<div><strong>Warning: ")
+		if len(collinearity.Problems) > 0 {
+			buffer.WriteString("High variance inflation (VIF > ")
+			buffer.WriteString(strconv.FormatFloat(vifThreshold, 'f', 0, 64))
+			buffer.WriteString(") on ")
+			buffer.WriteString(html.EscapeString(strings.Join(collinearity.Problems, ", ")))
+			buffer.WriteString(". ")
+		}
+		if collinearity.ConditionNumber > conditionThreshold {
+			buffer.WriteString("Condition number above ")
+			buffer.WriteString(strconv.FormatFloat(conditionThreshold, 'f', 0, 64))
+			buffer.WriteString(". ")
+		}
+		buffer.WriteString("</strong>")
+		if len(collinearity.Dropped) == 0 {
+			buffer.WriteString("Standard errors of these variables are inflated, consider removing some of them.")
+		}
+		buffer.WriteString("</div>")
+	}
+
+	if len(collinearity.Dropped) > 0 {
+		buffer.WriteString("<div>Dropped before fitting: ")
+		buffer.WriteString(html.EscapeString(strings.Join(collinearity.Dropped, ", ")))
+		buffer.WriteString("</div>")
+	}
+
+	buffer.WriteString("<div>Condition Number: ")
+	buffer.WriteString(strconv.FormatFloat(collinearity.ConditionNumber, 'f', 4, 64))
+	buffer.WriteString("</div>")
+
+	//VIF and correlation of every variable
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>VIF</td>")
+	for _, name := range collinearity.Names {
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for i, name := range collinearity.Names {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(collinearity.VIF[i], 'f', 4, 64))
+		buffer.WriteString("</td>")
+		for _, val := range collinearity.Correlations[i] {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(val, 'f', 4, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,174 @@
+package predictor
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/skelterjohn/go.matrix"
+)
+
+//Convergence report of the solver used by GenerateModel
+//
+//Every solver records why it stopped, how many iterations it used and the log likelihood, MSE and
+//gradient norm after each iteration (iteration 0 is the starting point). The gradient norm is the norm of
+//the score divided by the total sample weight, the penalized score for Firth.
+//Newton-Raphson keeps the coefficients with the lowest MSE so the model may not be the last row of its trace.
+
+type StopReason int
+
+const (
+	MaxIterationsReached  StopReason = iota //Iteration limit reached before any stop condition
+	CoefficientsConverged                   //All coefficients changed less than epsilon
+	GradientConverged                       //Gradient norm below tolerance
+	LikelihoodConverged                     //Log likelihood changed less than tolerance
+	SingularInformation                     //X'WX cannot be inverted
+	OutOfControl                            //A coefficient jumped more than the jump factor
+	WorseFit                                //New coefficients gave worse MSE too many times in a row
+	Diverged                                //Log likelihood is not finite
+	LineSearchFailed                        //No step along the search direction decreased the loss
+)
+
+func (s StopReason) String() string {
+	switch s {
+	case CoefficientsConverged:
+		return "Coefficients changed less than epsilon"
+	case GradientConverged:
+		return "Gradient norm below tolerance"
+	case LikelihoodConverged:
+		return "Log likelihood change below tolerance"
+	case SingularInformation:
+		return "Information matrix X'WX cannot be inverted"
+	case OutOfControl:
+		return "Coefficient jumped out of control"
+	case WorseFit:
+		return "Worse MSE five times in a row"
+	case Diverged:
+		return "Diverged, log likelihood is not finite"
+	case LineSearchFailed:
+		return "Line search could not decrease the loss"
+	default:
+		return "Maximum iterations reached"
+	}
+}
+
+type ConvergenceStep struct {
+	Iteration     int
+	LogLikelihood float64
+	MSE           float64
+	GradientNorm  float64
+}
+
+type Convergence struct {
+	Solver     FittingMode
+	Iterations int
+	Reason     StopReason
+	Trace      []ConvergenceStep
+}
+
+//Only stopping on a convergence criterion means the coefficients are the estimates
+func (c Convergence) Converged() bool {
+	return c.Reason == CoefficientsConverged || c.Reason == GradientConverged || c.Reason == LikelihoodConverged
+}
+
+//Convergence report of the fitted model, also returned by GenerateModel
+func (r *Regression) Convergence() Convergence {
+	return r.model.Convergence
+}
+
+//Trace step from the probabilities of the design matrix rows, score is X'v(y - p) when nil
+func (r *Regression) matrixStep(iteration int, xMatrix matrix.Matrix, yVector matrix.Matrix, pVector matrix.Matrix, score matrix.Matrix) ConvergenceStep {
+	step := ConvergenceStep{Iteration: iteration}
+
+	rows := pVector.Rows()
+	cols := xMatrix.Cols()
+	gradient := make([]float64, cols)
+	total := 0.0
+	for i := 0; i < rows; i++ {
+		pVal := pVector.Get(i, 0)
+		observedVal := yVector.Get(i, 0)
+		weight := r.sampleWeight(i)
+
+		//Clamp so the log likelihood stays finite
+		clamped := math.Min(math.Max(pVal, 1e-15), 1.0-1e-15)
+		step.LogLikelihood += weight * (observedVal*math.Log(clamped) + (1.0-observedVal)*math.Log(1.0-clamped))
+		step.MSE += weight * (pVal - observedVal) * (pVal - observedVal)
+
+		for j := 0; j < cols; j++ {
+			gradient[j] += weight * (observedVal - pVal) * xMatrix.Get(i, j)
+		}
+		total += weight
+	}
+
+	if score != nil {
+		for j := 0; j < cols; j++ {
+			gradient[j] = score.Get(j, 0)
+		}
+	}
+
+	if total > 0 {
+		step.MSE /= total
+		step.GradientNorm = norm(gradient) / total
+	}
+
+	return step
+}
+
+//Stop reason and trace of the solver with a warning when it did not converge, only the last rows of long traces are shown
+func (r *Regression) convergenceHTML() string {
+	convergence := r.model.Convergence
+	if len(convergence.Trace) == 0 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Convergence: ")
+	buffer.WriteString(convergence.Solver.String())
+	buffer.WriteString("</h3></div>")
+
+	if !convergence.Converged() {
+		buffer.WriteString("<div><strong>Warning: model did not converge (")
+		buffer.WriteString(convergence.Reason.String())
+		buffer.WriteString("), coefficients may not be the maximum likelihood estimates.</strong></div>")
+	}
+
+	buffer.WriteString("<div>Iterations: ")
+	buffer.WriteString(strconv.Itoa(convergence.Iterations))
+	buffer.WriteString(", Stop Reason: ")
+	buffer.WriteString(convergence.Reason.String())
+	buffer.WriteString("</div>")
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Iteration</td>")
+	buffer.WriteString("<td>Log Likelihood</td>")
+	buffer.WriteString("<td>MSE</td>")
+	buffer.WriteString("<td>Gradient Norm</td>")
+	buffer.WriteString("</tr>")
+
+	trace := convergence.Trace
+	if len(trace) > 25 {
+		trace = trace[len(trace)-25:]
+	}
+	for _, step := range trace {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(step.Iteration))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(step.LogLikelihood, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(step.MSE, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(step.GradientNorm, 'e', 3, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
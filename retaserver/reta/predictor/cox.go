@@ -0,0 +1,411 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"reta/errors"
+)
+
+//Cox proportional-hazards model of the player lifetime
+//
+//h(t | x) = h0(t) * exp(b1x1 + b2x2 + . . .), exp(b) is the hazard ratio of churning for one unit of the
+//variable. Coefficients maximize Breslow's partial likelihood for tied churn times with Newton-Raphson:
+//
+//	ln PL = Sum over churn times t of [Sum(x'b of players churning at t) - d(t) * ln Sum(exp(x'b) of players at risk at t)]
+//
+//Numerical variables use the training mean for missing values, categorical variables use one indicator per
+//level except the most common one. Columns are centered which does not change the coefficients.
+//
+//References:
+// - Cox, D. (1972). Regression Models and Life-Tables. Journal of the Royal Statistical Society B 34(2)
+// - Breslow, N. (1974). Covariance Analysis of Censored Survival Data. Biometrics 30(1)
+// - Harrell, F. et al. (1982). Evaluating the Yield of Medical Tests (concordance index)
+
+type CoxModel struct {
+	featureEncoder
+
+	Names                    []string
+	Coefficients             []float64
+	StandardErrors           []float64
+	HazardRatios             []float64
+	LowerConfidenceIntervals []float64 //95% interval of the hazard ratio
+	UpperConfidenceIntervals []float64
+	PValues                  []float64
+	LogLikelihood            float64 //Partial log likelihood
+	NullLogLikelihood        float64
+	Concordance              float64
+	Convergence              Convergence
+	Dropped                  []string //Constant columns left out of the model
+
+	columns []int     //Encoded features used as columns
+	means   []float64 //Mean of each column
+}
+
+func (m *CoxModel) Fit(points []SurvivalPoint, maxIteration int) error {
+	data := make([]DataPoint, len(points))
+	for i, point := range points {
+		data[i] = DataPoint{Variables: point.Variables, Categories: point.Categories, Missing: point.Missing}
+	}
+
+	err := m.fitFeatures(data)
+	if err != nil {
+		return err
+	}
+
+	churned := 0
+	for _, point := range points {
+		if point.Churned {
+			churned++
+		}
+	}
+	if churned == 0 {
+		return errors.New("Error: No churned player, hazard cannot be estimated")
+	}
+
+	encoded := make([][]float64, len(points))
+	for i, point := range data {
+		encoded[i] = m.encode(point)
+	}
+	m.selectColumns(encoded)
+
+	numColumns := len(m.columns)
+	if numColumns == 0 {
+		return errors.New("Error: No variable with more than one value")
+	}
+
+	x := make([][]float64, len(points))
+	for i := range encoded {
+		x[i] = m.columnValues(encoded[i])
+	}
+
+	//Players by descending lifetime so the risk set only grows
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Sort(byDescendingLifetime{indexes: order, points: points})
+
+	coefficients := make([]float64, numColumns)
+	logLikelihood, gradient, information := coxPartialLikelihood(points, x, order, coefficients)
+	m.NullLogLikelihood = logLikelihood
+
+	convergence := Convergence{Solver: NewtonRaphsonFitting, Reason: MaxIterationsReached}
+	convergence.Trace = append(convergence.Trace, ConvergenceStep{LogLikelihood: logLikelihood, GradientNorm: norm(gradient)})
+	for iteration := 0; iteration < maxIteration; iteration++ {
+		inverse := matrix.Inverse(information)
+		if inverse == nil {
+			convergence.Reason = SingularInformation
+			break
+		}
+
+		//Newton step inv(I)U, halved while the partial likelihood decreases
+		step := make([]float64, numColumns)
+		for j := 0; j < numColumns; j++ {
+			for k := 0; k < numColumns; k++ {
+				step[j] += inverse.Get(j, k) * gradient[k]
+			}
+		}
+
+		var candidate []float64
+		var newLogLikelihood float64
+		var newGradient []float64
+		var newInformation *matrix.DenseMatrix
+		for halving := 0; halving < 20; halving++ {
+			candidate = make([]float64, numColumns)
+			for j := range candidate {
+				candidate[j] = coefficients[j] + step[j]
+			}
+
+			newLogLikelihood, newGradient, newInformation = coxPartialLikelihood(points, x, order, candidate)
+			if newLogLikelihood >= logLikelihood {
+				break
+			}
+			step = scale(step, 0.5)
+		}
+
+		if math.IsNaN(newLogLikelihood) || math.IsInf(newLogLikelihood, 0) {
+			convergence.Reason = Diverged
+			break
+		}
+
+		change := newLogLikelihood - logLikelihood
+		coefficients, logLikelihood, gradient, information = candidate, newLogLikelihood, newGradient, newInformation
+		convergence.Iterations = iteration + 1
+		convergence.Trace = append(convergence.Trace, ConvergenceStep{Iteration: iteration + 1, LogLikelihood: logLikelihood, GradientNorm: norm(gradient)})
+
+		if math.Abs(change) <= 1e-9*(math.Abs(logLikelihood)+1.0) {
+			convergence.Reason = LikelihoodConverged
+			break
+		}
+	}
+
+	m.Convergence = convergence
+	m.Coefficients = coefficients
+	m.LogLikelihood = logLikelihood
+
+	//Standard errors from the inverse of the information at the estimates
+	m.StandardErrors = make([]float64, numColumns)
+	covariance := matrix.Inverse(information)
+	for j := 0; j < numColumns; j++ {
+		if covariance != nil {
+			m.StandardErrors[j] = math.Sqrt(covariance.Get(j, j))
+		} else {
+			m.StandardErrors[j] = math.NaN()
+		}
+	}
+
+	m.HazardRatios = make([]float64, numColumns)
+	m.LowerConfidenceIntervals = make([]float64, numColumns)
+	m.UpperConfidenceIntervals = make([]float64, numColumns)
+	m.PValues = make([]float64, numColumns)
+	for j, b := range coefficients {
+		se := m.StandardErrors[j]
+		m.HazardRatios[j] = math.Exp(b)
+		m.LowerConfidenceIntervals[j] = math.Exp(b - 1.96*se)
+		m.UpperConfidenceIntervals[j] = math.Exp(b + 1.96*se)
+		m.PValues[j] = 2.0 * (1.0 - normalCDF(math.Abs(b/se)))
+	}
+
+	m.Concordance = coxConcordance(points, x, coefficients)
+
+	return nil
+}
+
+//Keep the columns of the encoded features which are not constant, except the most common level of each categorical variable
+func (m *CoxModel) selectColumns(encoded [][]float64) {
+	names := m.featureNames()
+	numVariables := len(m.variableNames)
+
+	//First column of each categorical variable and its most common level
+	reference := make(map[int]bool)
+	offset := numVariables
+	for _, levels := range m.levels {
+		best, bestCount := -1, -1.0
+		for l := range levels {
+			count := 0.0
+			for _, row := range encoded {
+				count += row[offset+l]
+			}
+			if count > bestCount {
+				best, bestCount = offset+l, count
+			}
+		}
+		reference[best] = true
+		offset += len(levels)
+	}
+
+	m.columns = nil
+	m.means = nil
+	m.Names = nil
+	m.Dropped = nil
+	for j, name := range names {
+		if reference[j] {
+			continue
+		}
+
+		minimum, maximum, total := math.Inf(1), math.Inf(-1), 0.0
+		for _, row := range encoded {
+			minimum = math.Min(minimum, row[j])
+			maximum = math.Max(maximum, row[j])
+			total += row[j]
+		}
+		if minimum == maximum {
+			m.Dropped = append(m.Dropped, name)
+			continue
+		}
+
+		m.columns = append(m.columns, j)
+		m.means = append(m.means, total/float64(len(encoded)))
+		m.Names = append(m.Names, name)
+	}
+}
+
+//Centered values of the model columns
+func (m *CoxModel) columnValues(features []float64) []float64 {
+	values := make([]float64, len(m.columns))
+	for j, column := range m.columns {
+		values[j] = features[column] - m.means[j]
+	}
+
+	return values
+}
+
+//Breslow partial log likelihood, its gradient and the information matrix (negative Hessian)
+func coxPartialLikelihood(points []SurvivalPoint, x [][]float64, order []int, coefficients []float64) (float64, []float64, *matrix.DenseMatrix) {
+	numColumns := len(coefficients)
+
+	logLikelihood := 0.0
+	gradient := make([]float64, numColumns)
+	information := matrix.Zeros(numColumns, numColumns)
+
+	//Sums over the risk set of exp(x'b), exp(x'b)x and exp(x'b)xx'
+	riskTotal := 0.0
+	riskFirst := make([]float64, numColumns)
+	riskSecond := make([][]float64, numColumns)
+	for j := range riskSecond {
+		riskSecond[j] = make([]float64, numColumns)
+	}
+
+	for start := 0; start < len(order); {
+		time := points[order[start]].Lifetime
+		end := start
+		for end < len(order) && points[order[end]].Lifetime == time {
+			end++
+		}
+
+		//Everyone with this lifetime joins the risk set first
+		events := 0.0
+		for _, i := range order[start:end] {
+			risk := math.Exp(dot(coefficients, x[i]))
+			riskTotal += risk
+			for j := 0; j < numColumns; j++ {
+				riskFirst[j] += risk * x[i][j]
+				for k := 0; k < numColumns; k++ {
+					riskSecond[j][k] += risk * x[i][j] * x[i][k]
+				}
+			}
+
+			if points[i].Churned {
+				events++
+				logLikelihood += dot(coefficients, x[i])
+				for j := 0; j < numColumns; j++ {
+					gradient[j] += x[i][j]
+				}
+			}
+		}
+
+		if events > 0 {
+			logLikelihood -= events * math.Log(riskTotal)
+			for j := 0; j < numColumns; j++ {
+				meanJ := riskFirst[j] / riskTotal
+				gradient[j] -= events * meanJ
+				for k := 0; k < numColumns; k++ {
+					meanK := riskFirst[k] / riskTotal
+					information.Set(j, k, information.Get(j, k)+events*(riskSecond[j][k]/riskTotal-meanJ*meanK))
+				}
+			}
+		}
+
+		start = end
+	}
+
+	return logLikelihood, gradient, information
+}
+
+//Harrell's C: fraction of comparable pairs where the player churning first has the higher hazard
+func coxConcordance(points []SurvivalPoint, x [][]float64, coefficients []float64) float64 {
+	risk := make([]float64, len(points))
+	for i := range points {
+		risk[i] = dot(coefficients, x[i])
+	}
+
+	concordant, comparable := 0.0, 0.0
+	for i, first := range points {
+		if !first.Churned {
+			continue
+		}
+		for j, second := range points {
+			if second.Lifetime <= first.Lifetime {
+				continue
+			}
+
+			comparable++
+			if risk[i] > risk[j] {
+				concordant++
+			} else if risk[i] == risk[j] {
+				concordant += 0.5
+			}
+		}
+	}
+
+	if comparable == 0 {
+		return math.NaN()
+	}
+
+	return concordant / comparable
+}
+
+//Player indexes by descending lifetime
+type byDescendingLifetime struct {
+	indexes []int
+	points  []SurvivalPoint
+}
+
+func (b byDescendingLifetime) Len() int      { return len(b.indexes) }
+func (b byDescendingLifetime) Swap(i, j int) { b.indexes[i], b.indexes[j] = b.indexes[j], b.indexes[i] }
+func (b byDescendingLifetime) Less(i, j int) bool {
+	return b.points[b.indexes[i]].Lifetime > b.points[b.indexes[j]].Lifetime
+}
+
+func (m *CoxModel) StringHTML() string {
+	var buffer bytes.Buffer
+
+	if !m.Convergence.Converged() {
+		buffer.WriteString("<div><strong>Warning: Cox model did not converge (")
+		buffer.WriteString(m.Convergence.Reason.String())
+		buffer.WriteString("), hazard ratios may not be the maximum likelihood estimates.</strong></div>")
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Coefficient</td>")
+	buffer.WriteString("<td>Hazard Ratio</td>")
+	buffer.WriteString("<td>Std. Error</td>")
+	buffer.WriteString("<td>p-Value</td>")
+	buffer.WriteString("<td>Lower Confidence (HR)</td>")
+	buffer.WriteString("<td>Upper Confidence (HR)</td>")
+	buffer.WriteString("</tr>")
+
+	for j, name := range m.Names {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		for _, val := range []float64{m.Coefficients[j], m.HazardRatios[j], m.StandardErrors[j], m.PValues[j], m.LowerConfidenceIntervals[j], m.UpperConfidenceIntervals[j]} {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(val, 'f', 6, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	//Likelihood ratio test against the model without variables
+	chiSquare := 2.0 * (m.LogLikelihood - m.NullLogLikelihood)
+	df := len(m.Coefficients)
+
+	buffer.WriteString("<div>Partial Log Likelihood: ")
+	buffer.WriteString(strconv.FormatFloat(m.LogLikelihood, 'f', 6, 64))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Likelihood Ratio Test: ")
+	buffer.WriteString(strconv.FormatFloat(chiSquare, 'f', 4, 64))
+	buffer.WriteString(" on ")
+	buffer.WriteString(strconv.Itoa(df))
+	buffer.WriteString(" df, p-Value ")
+	buffer.WriteString(strconv.FormatFloat(chiSquarePValue(chiSquare, df), 'f', 6, 64))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Concordance (C-index): ")
+	buffer.WriteString(strconv.FormatFloat(m.Concordance, 'f', 4, 64))
+	buffer.WriteString("</div>")
+
+	if len(m.Dropped) > 0 {
+		buffer.WriteString("<div>Constant variables left out: ")
+		for i, name := range m.Dropped {
+			if i > 0 {
+				buffer.WriteString(", ")
+			}
+			buffer.WriteString(html.EscapeString(name))
+		}
+		buffer.WriteString("</div>")
+	}
+
+	return buffer.String()
+}
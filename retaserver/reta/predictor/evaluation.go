@@ -0,0 +1,403 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+
+	"reta/errors"
+)
+
+//Evaluation metrics of a model against testing data, retained (1.0) is the positive class
+type Evaluation struct {
+	Accuracy  float64 //Percentage of correct prediction using 0.5 threshold
+	Precision float64 //Percentage of predicted retained players who are retained
+	Recall    float64 //Percentage of retained players predicted as retained
+	F1        float64 //Harmonic mean of precision and recall
+	AUC       float64 //Area under ROC curve
+	LogLoss   float64 //Mean negative log likelihood
+	Brier     float64 //Mean squared error of the probabilities
+}
+
+var evaluationNames = []string{"Accuracy (%)", "Precision (%)", "Recall (%)", "F1 Score (%)", "AUC", "Log Loss", "Brier Score"}
+
+func (e Evaluation) values() []float64 {
+	return []float64{e.Accuracy, e.Precision, e.Recall, e.F1, e.AUC, e.LogLoss, e.Brier}
+}
+
+func evaluationFromValues(values []float64) Evaluation {
+	return Evaluation{
+		Accuracy:  values[0],
+		Precision: values[1],
+		Recall:    values[2],
+		F1:        values[3],
+		AUC:       values[4],
+		LogLoss:   values[5],
+		Brier:     values[6],
+	}
+}
+
+//Probability of each testing data being retained
+func (r *Regression) predictProbabilities(testData []DataPoint) ([]float64, error) {
+	probabilities := make([]float64, len(testData))
+	for i, data := range testData {
+		predicted, err := r.Predict(data)
+		if err != nil {
+			return nil, err
+		}
+		probabilities[i] = predicted
+	}
+
+	return probabilities, nil
+}
+
+func (r *Regression) Evaluate(testData []DataPoint) (Evaluation, error) {
+	if len(testData) == 0 {
+		return Evaluation{}, errors.New("Error: Need some testing data to evaluate model")
+	}
+	r.unseenLevels = 0
+
+	probabilities, err := r.predictProbabilities(testData)
+	if err != nil {
+		return Evaluation{}, err
+	}
+
+	observed := make([]float64, len(testData))
+	for i, data := range testData {
+		observed[i] = data.Result
+	}
+
+	return evaluateProbabilities(probabilities, observed), nil
+}
+
+func evaluateProbabilities(probabilities []float64, observed []float64) Evaluation {
+	var evaluation Evaluation
+
+	truePositive, falsePositive, trueNegative, falseNegative := 0, 0, 0, 0
+	logLoss := 0.0
+	brier := 0.0
+
+	//Clamp probabilities so log loss stays finite
+	epsilon := 1e-15
+
+	total := len(probabilities)
+	for i := 0; i < total; i++ {
+		pVal := probabilities[i]
+		observedVal := observed[i]
+
+		if pVal >= 0.50 && observedVal == 1.0 {
+			truePositive++
+		} else if pVal >= 0.50 {
+			falsePositive++
+		} else if observedVal == 0.0 {
+			trueNegative++
+		} else {
+			falseNegative++
+		}
+
+		clamped := math.Min(math.Max(pVal, epsilon), 1-epsilon)
+		if observedVal == 1.0 {
+			logLoss -= math.Log(clamped)
+		} else {
+			logLoss -= math.Log(1 - clamped)
+		}
+
+		brier += (pVal - observedVal) * (pVal - observedVal)
+	}
+
+	if total > 0 {
+		evaluation.Accuracy = 100.0 * float64(truePositive+trueNegative) / float64(total)
+		evaluation.LogLoss = logLoss / float64(total)
+		evaluation.Brier = brier / float64(total)
+	}
+	if truePositive+falsePositive > 0 {
+		evaluation.Precision = 100.0 * float64(truePositive) / float64(truePositive+falsePositive)
+	}
+	if truePositive+falseNegative > 0 {
+		evaluation.Recall = 100.0 * float64(truePositive) / float64(truePositive+falseNegative)
+	}
+	if evaluation.Precision+evaluation.Recall > 0 {
+		evaluation.F1 = 2 * evaluation.Precision * evaluation.Recall / (evaluation.Precision + evaluation.Recall)
+	}
+
+	evaluation.AUC = areaUnderCurve(probabilities, observed)
+
+	return evaluation
+}
+
+//AUC is the probability that a random retained player gets higher probability than a random churned player,
+//computed from the rank sum (Mann-Whitney U) with ties getting the average rank
+func areaUnderCurve(probabilities []float64, observed []float64) float64 {
+	total := len(probabilities)
+	indexes := make([]int, total)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.Sort(byProbability{indexes: indexes, probabilities: probabilities})
+
+	positives := 0
+	rankSum := 0.0
+	for i := 0; i < total; {
+		//Group of ties
+		j := i
+		for j < total && probabilities[indexes[j]] == probabilities[indexes[i]] {
+			j++
+		}
+
+		rank := float64(i+j+1) / 2.0
+		for k := i; k < j; k++ {
+			if observed[indexes[k]] == 1.0 {
+				positives++
+				rankSum += rank
+			}
+		}
+		i = j
+	}
+
+	negatives := total - positives
+	if positives == 0 || negatives == 0 {
+		return math.NaN()
+	}
+
+	return (rankSum - float64(positives*(positives+1))/2.0) / float64(positives*negatives)
+}
+
+//Sort indexes by their probability
+type byProbability struct {
+	indexes       []int
+	probabilities []float64
+}
+
+func (b byProbability) Len() int      { return len(b.indexes) }
+func (b byProbability) Swap(i, j int) { b.indexes[i], b.indexes[j] = b.indexes[j], b.indexes[i] }
+func (b byProbability) Less(i, j int) bool {
+	return b.probabilities[b.indexes[i]] < b.probabilities[b.indexes[j]]
+}
+
+//Mean and sample standard deviation of every metric, undefined values (e.g. AUC of a fold with one class) are skipped
+func summarizeEvaluations(evaluations []Evaluation) (mean Evaluation, deviation Evaluation) {
+	numMetrics := len(evaluationNames)
+	means := make([]float64, numMetrics)
+	deviations := make([]float64, numMetrics)
+
+	for m := 0; m < numMetrics; m++ {
+		var values []float64
+		for _, evaluation := range evaluations {
+			val := evaluation.values()[m]
+			if !math.IsNaN(val) {
+				values = append(values, val)
+			}
+		}
+
+		count := len(values)
+		if count == 0 {
+			means[m] = math.NaN()
+			deviations[m] = math.NaN()
+			continue
+		}
+
+		for _, val := range values {
+			means[m] += val
+		}
+		means[m] /= float64(count)
+
+		if count > 1 {
+			for _, val := range values {
+				deviations[m] += (val - means[m]) * (val - means[m])
+			}
+			deviations[m] = math.Sqrt(deviations[m] / float64(count-1))
+		}
+	}
+
+	return evaluationFromValues(means), evaluationFromValues(deviations)
+}
+
+//Table of mean and standard deviation of every metric across folds
+func evaluationsHTML(evaluations []Evaluation) string {
+	mean, deviation := summarizeEvaluations(evaluations)
+	meanValues := mean.values()
+	deviationValues := deviation.values()
+
+	var buffer bytes.Buffer
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Metric</td>")
+	buffer.WriteString("<td>Mean</td>")
+	buffer.WriteString("<td>Std. Deviation</td>")
+	buffer.WriteString("</tr>")
+
+	for m, name := range evaluationNames {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(name)
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(meanValues[m], 'f', 4, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(deviationValues[m], 'f', 4, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
+
+//Evaluation of a multinomial model, every player is predicted as its most probable class
+type ClassEvaluation struct {
+	Classes   []string
+	Confusion [][]int //Players of each observed class (row) predicted as each class (column)
+	Accuracy  float64 //Percentage of correct prediction
+	Precision []float64
+	Recall    []float64
+	F1        []float64
+	MacroF1   float64 //Mean F1 of the classes
+	LogLoss   float64 //Mean negative log probability of the observed class
+}
+
+func evaluateClasses(classes []string, probabilities [][]float64, observed []int) ClassEvaluation {
+	evaluation := ClassEvaluation{Classes: classes}
+	evaluation.Confusion = make([][]int, len(classes))
+	for k := range evaluation.Confusion {
+		evaluation.Confusion[k] = make([]int, len(classes))
+	}
+
+	logLoss := 0.0
+	for i, predicted := range probabilities {
+		best := 0
+		for k, probability := range predicted {
+			if probability > predicted[best] {
+				best = k
+			}
+		}
+
+		evaluation.Confusion[observed[i]][best]++
+		logLoss -= math.Log(math.Max(predicted[observed[i]], 1e-15))
+	}
+
+	if len(probabilities) > 0 {
+		evaluation.LogLoss = logLoss / float64(len(probabilities))
+	}
+	evaluation.computeMetrics()
+
+	return evaluation
+}
+
+//Accuracy and per-class metrics from the confusion matrix
+func (e *ClassEvaluation) computeMetrics() {
+	numClasses := len(e.Classes)
+	e.Precision = make([]float64, numClasses)
+	e.Recall = make([]float64, numClasses)
+	e.F1 = make([]float64, numClasses)
+
+	correct, total := 0, 0
+	for k := 0; k < numClasses; k++ {
+		observedTotal, predictedTotal := 0, 0
+		for l := 0; l < numClasses; l++ {
+			observedTotal += e.Confusion[k][l]
+			predictedTotal += e.Confusion[l][k]
+		}
+		correct += e.Confusion[k][k]
+		total += observedTotal
+
+		if predictedTotal > 0 {
+			e.Precision[k] = 100.0 * float64(e.Confusion[k][k]) / float64(predictedTotal)
+		}
+		if observedTotal > 0 {
+			e.Recall[k] = 100.0 * float64(e.Confusion[k][k]) / float64(observedTotal)
+		}
+		if e.Precision[k]+e.Recall[k] > 0 {
+			e.F1[k] = 2 * e.Precision[k] * e.Recall[k] / (e.Precision[k] + e.Recall[k])
+		}
+		e.MacroF1 += e.F1[k] / float64(numClasses)
+	}
+
+	if total > 0 {
+		e.Accuracy = 100.0 * float64(correct) / float64(total)
+	}
+}
+
+//Sum of the confusion matrices of every fold, log loss is weighted by the players of each fold
+func poolClassEvaluations(evaluations []ClassEvaluation) ClassEvaluation {
+	if len(evaluations) == 0 {
+		return ClassEvaluation{}
+	}
+
+	pooled := ClassEvaluation{Classes: evaluations[0].Classes}
+	pooled.Confusion = make([][]int, len(pooled.Classes))
+	for k := range pooled.Confusion {
+		pooled.Confusion[k] = make([]int, len(pooled.Classes))
+	}
+
+	total := 0
+	for _, evaluation := range evaluations {
+		players := 0
+		for k, row := range evaluation.Confusion {
+			for l, count := range row {
+				pooled.Confusion[k][l] += count
+				players += count
+			}
+		}
+		pooled.LogLoss += evaluation.LogLoss * float64(players)
+		total += players
+	}
+
+	if total > 0 {
+		pooled.LogLoss /= float64(total)
+	}
+	pooled.computeMetrics()
+
+	return pooled
+}
+
+//Confusion matrix with precision, recall and F1 of every class
+func classEvaluationHTML(evaluation ClassEvaluation) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<div>Accuracy (%): ")
+	buffer.WriteString(strconv.FormatFloat(evaluation.Accuracy, 'f', 2, 64))
+	buffer.WriteString(", Macro F1 (%): ")
+	buffer.WriteString(strconv.FormatFloat(evaluation.MacroF1, 'f', 2, 64))
+	buffer.WriteString(", Log Loss: ")
+	buffer.WriteString(strconv.FormatFloat(evaluation.LogLoss, 'f', 4, 64))
+	buffer.WriteString("</div>")
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Observed \\ Predicted</td>")
+	for _, name := range evaluation.Classes {
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("<td>Precision (%)</td>")
+	buffer.WriteString("<td>Recall (%)</td>")
+	buffer.WriteString("<td>F1 Score (%)</td>")
+	buffer.WriteString("</tr>")
+
+	for k, name := range evaluation.Classes {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		for _, count := range evaluation.Confusion[k] {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.Itoa(count))
+			buffer.WriteString("</td>")
+		}
+		for _, val := range []float64{evaluation.Precision[k], evaluation.Recall[k], evaluation.F1[k]} {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(val, 'f', 2, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,409 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"reta/errors"
+)
+
+//Firth's bias-reduced logistic regression and separation detection
+//
+//References:
+// - Firth, D. (1993). Bias reduction of maximum likelihood estimates. Biometrika 80(1)
+// - Heinze, G. and Schemper, M. (2002). A solution to the problem of separation in logistic regression
+// - http://cran.r-project.org/web/packages/logistf/
+
+type FittingMode int
+
+const (
+	NewtonRaphsonFitting FittingMode = iota //Plain maximum likelihood using IRLS | Newton-Raphson
+	FirthFitting                            //Firth's penalized likelihood, finite even when data is separated
+	SGDFitting                              //Mini-batch stochastic gradient descent streaming the data points
+	LBFGSFitting                            //Limited-memory BFGS streaming the data points
+)
+
+func (m FittingMode) String() string {
+	switch m {
+	case FirthFitting:
+		return "Firth Penalized Likelihood"
+	case SGDFitting:
+		return "Mini-Batch Stochastic Gradient Descent (Adam)"
+	case LBFGSFitting:
+		return "L-BFGS"
+	default:
+		return "IRLS | Newton-Raphson"
+	}
+}
+
+//Streaming solvers never build the design matrix, so debug mode does not log it row by row either
+func (m FittingMode) streaming() bool {
+	return m == SGDFitting || m == LBFGSFitting
+}
+
+//Separation happens when some variables (or a combination of them) predict the observed value perfectly.
+//Maximum likelihood coefficients do not exist in that case, they just keep growing on every iteration.
+type Separation struct {
+	Detected  bool     //Is there any separation in the training data
+	Complete  bool     //Complete separation (no overlap at all), otherwise quasi-complete
+	Variables []string //Name of the variables causing the separation
+}
+
+//Check every variable on its own: if all retained players lie on one side of a value and all
+//churned players on the other, the variable separates the outcome.
+//After fitting, also check whether the fitted probabilities reproduce the outcome exactly which means
+//a combination of variables is separating it.
+func (r *Regression) detectSeparation() {
+	separation := Separation{}
+
+	names := r.designNames()
+	design := make([][]float64, len(r.dataPoints))
+	for i, data := range r.dataPoints {
+		design[i] = r.designVariables(data)
+	}
+
+	numVariables := len(names)
+	for j := 0; j < numVariables; j++ {
+		minPositive, maxPositive := math.Inf(1), math.Inf(-1)
+		minNegative, maxNegative := math.Inf(1), math.Inf(-1)
+		positives, negatives := 0, 0
+
+		for i, data := range r.dataPoints {
+			val := design[i][j]
+			if data.Result == 1.0 {
+				minPositive = math.Min(minPositive, val)
+				maxPositive = math.Max(maxPositive, val)
+				positives++
+			} else {
+				minNegative = math.Min(minNegative, val)
+				maxNegative = math.Max(maxNegative, val)
+				negatives++
+			}
+		}
+
+		//Nothing to separate when there is only one class
+		if positives == 0 || negatives == 0 {
+			continue
+		}
+
+		//Constant variable does not separate anything
+		if minPositive == maxPositive && minNegative == maxNegative && minPositive == minNegative {
+			continue
+		}
+
+		if maxNegative < minPositive || maxPositive < minNegative {
+			separation.Detected = true
+			separation.Complete = true
+			separation.Variables = append(separation.Variables, names[j])
+		} else if maxNegative <= minPositive || maxPositive <= minNegative {
+			separation.Detected = true
+			separation.Variables = append(separation.Variables, names[j])
+		}
+	}
+
+	r.model.Separation = separation
+
+	if r.debugMode && separation.Detected {
+		r.debugContext.Infof("\nSeparation detected (complete: %v) on variables: %v", separation.Complete, separation.Variables)
+	}
+}
+
+//Called after the coefficients are generated, fitted probabilities of exactly 0 or 1 for every
+//observation means the data is separated by a linear combination of the variables.
+//Probabilities are computed one data point at a time so it works for the streaming solvers too.
+func (r *Regression) detectFittedSeparation() error {
+	if len(r.model.Coefficients) == 0 {
+		return errors.New("Error: Coefficients in models are not generated yet")
+	}
+
+	tolerance := 1e-6
+	for _, data := range r.dataPoints {
+		pVal := sigmoid(r.linearPredictor(r.designVariables(data)))
+		observedVal := data.Result
+		if observedVal == 1.0 && pVal < 1.0-tolerance {
+			return nil
+		}
+		if observedVal == 0.0 && pVal > tolerance {
+			return nil
+		}
+	}
+
+	//Every observation is predicted perfectly
+	if !r.model.Separation.Detected {
+		r.model.Separation.Detected = true
+		r.model.Separation.Complete = true
+		r.model.Separation.Variables = append(r.model.Separation.Variables, "Combination of variables")
+	}
+
+	if r.debugMode {
+		r.debugContext.Infof("\nFitted probabilities reproduce the observed values exactly -- data is separated")
+	}
+
+	return nil
+}
+
+//Firth's penalized likelihood: l*(b) = l(b) + 0.5 * ln|X'WX|
+//Maximized with a modified Newton-Raphson where the score function is U*(b) = X'(v(y - p) + h(0.5 - p)) with sample weights v
+//and h is the diagonal of the hat matrix H = W^(1/2) X inv(X'WX) X' W^(1/2).
+//
+//The step is limited to maxStep on every coefficient and halved while the penalized likelihood decreases,
+//fitting stops without convergence when no halving improves it. Standard errors use inv(X'WX) at the final
+//coefficients.
+func (r *Regression) computeFirthCoefficients(xTrainingVector matrix.Matrix, yTrainingVector matrix.Matrix, maxIteration int, epsilon float64) error {
+	xRows := xTrainingVector.Rows()
+	xCols := xTrainingVector.Cols()
+
+	if xRows != yTrainingVector.Rows() {
+		return errors.New("Error: Training vectors are not compatible to generate model")
+	}
+
+	maxStep := 5.0
+	maxHalving := 25
+
+	//Initial coefficients
+	coeffVector := matrix.Zeros(xCols, 1)
+
+	penalized, err := r.penalizedLogLikelihood(xTrainingVector, yTrainingVector, coeffVector)
+	if err != nil {
+		return err
+	}
+
+	if r.debugMode {
+		r.debugContext.Infof("\nInitial penalized log likelihood:\n%f", penalized)
+	}
+
+	//Trace rows are the coefficients each step starts from
+	convergence := Convergence{Solver: FirthFitting, Reason: MaxIterationsReached}
+
+	Xt := matrix.Transpose(xTrainingVector)
+	for i := 0; i < maxIteration; i++ {
+		pVector, A, C, err := r.firthInformation(xTrainingVector, coeffVector)
+		if err != nil {
+			return err
+		}
+		if C == nil {
+			if r.debugMode {
+				r.debugContext.Infof("\nInformation matrix cannot be inverted -- stopping")
+			}
+			convergence.Reason = SingularInformation
+			break
+		}
+
+		//Hat diagonal h[i] = w[i] * x[i]' inv(X'WX) x[i]
+		D := matrix.Product(xTrainingVector, C)
+		modified := matrix.Zeros(xRows, 1)
+		for k := 0; k < xRows; k++ {
+			h := 0.0
+			for j := 0; j < xCols; j++ {
+				h += D.Get(k, j) * A.Get(k, j)
+			}
+
+			pVal := pVector.Get(k, 0)
+			modified.Set(k, 0, r.sampleWeight(k)*(yTrainingVector.Get(k, 0)-pVal)+h*(0.5-pVal))
+		}
+
+		//Newton step inv(X'WX) U*
+		U := matrix.Product(Xt, modified)
+		delta := matrix.Product(C, U)
+
+		convergence.Trace = append(convergence.Trace, r.matrixStep(i, xTrainingVector, yTrainingVector, pVector, U))
+
+		largest := 0.0
+		for j := 0; j < xCols; j++ {
+			largest = math.Max(largest, math.Abs(delta.Get(j, 0)))
+		}
+		scale := 1.0
+		if largest > maxStep {
+			scale = maxStep / largest
+		}
+
+		//Step halving until the penalized likelihood improves
+		var newCoeffVector *matrix.DenseMatrix
+		var newPenalized float64
+		improved := false
+		for halving := 0; halving <= maxHalving; halving++ {
+			newCoeffVector = matrix.Zeros(xCols, 1)
+			for j := 0; j < xCols; j++ {
+				newCoeffVector.Set(j, 0, coeffVector.Get(j, 0)+scale*delta.Get(j, 0))
+			}
+
+			newPenalized, err = r.penalizedLogLikelihood(xTrainingVector, yTrainingVector, newCoeffVector)
+			if err != nil {
+				return err
+			}
+
+			if newPenalized >= penalized {
+				improved = true
+				break
+			}
+			scale /= 2.0
+		}
+
+		//Keep the last coefficients instead of a step lowering the penalized likelihood
+		if !improved {
+			if r.debugMode {
+				r.debugContext.Infof("\nNo step halving improved the penalized log likelihood -- stopping")
+			}
+			convergence.Reason = LineSearchFailed
+			break
+		}
+
+		if r.debugMode {
+			r.debugContext.Infof("\nNew Firth coefficients vector:\n%s", newCoeffVector.String())
+			r.debugContext.Infof("\nNew penalized log likelihood:\n%f", newPenalized)
+		}
+
+		converged := r.noChange(coeffVector, newCoeffVector, epsilon)
+
+		coeffVector = newCoeffVector
+		penalized = newPenalized
+		convergence.Iterations = i + 1
+
+		if converged {
+			if r.debugMode {
+				r.debugContext.Infof("\nNo significant change between old beta values and new beta values -- stopping")
+			}
+			convergence.Reason = CoefficientsConverged
+			break
+		}
+
+		if r.debugMode && i == maxIteration-1 {
+			r.debugContext.Infof("\nExceeded max iterations -- stopping")
+		}
+	}
+
+	//Done, put coefficients and standard errors from matrix to arrays
+	r.model.Coefficients = make([]float64, xCols)
+	for j := 0; j < xCols; j++ {
+		r.model.Coefficients[j] = coeffVector.Get(j, 0)
+	}
+
+	//Standard errors from the information at the accepted coefficients
+	_, _, covariance, err := r.firthInformation(xTrainingVector, coeffVector)
+	if err != nil {
+		return err
+	}
+	if covariance != nil {
+		r.saveCovariance(covariance)
+	}
+
+	r.model.Convergence = convergence
+
+	return nil
+}
+
+//Probabilities, WX and inv(X'WX) at the coefficients, the inverse is nil when X'WX is singular
+func (r *Regression) firthInformation(xMatrix matrix.Matrix, bVector matrix.Matrix) (matrix.Matrix, matrix.Matrix, matrix.Matrix, error) {
+	pVector, err := r.constructProbVector(xMatrix, bVector)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	A, err := r.computeXtilde(pVector, xMatrix) // WX
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	C := matrix.Inverse(matrix.Product(matrix.Transpose(xMatrix), A)) // inv(X'WX)
+	if C == nil {
+		return pVector, A, nil, nil
+	}
+
+	return pVector, A, C, nil
+}
+
+//l*(b) = TotalAddition[Vi * ((Yi * ln Pi) + (1 - Yi) * ln (1 - Pi))] + 0.5 * ln|X'WX|
+func (r *Regression) penalizedLogLikelihood(xMatrix matrix.Matrix, yVector matrix.Matrix, bVector matrix.Matrix) (float64, error) {
+	pVector, err := r.constructProbVector(xMatrix, bVector)
+	if err != nil {
+		return 0.0, err
+	}
+
+	logLikelihood := 0.0
+	rows := pVector.Rows()
+	for i := 0; i < rows; i++ {
+		pVal := pVector.Get(i, 0)
+		if yVector.Get(i, 0) == 1.0 {
+			logLikelihood += r.sampleWeight(i) * math.Log(pVal)
+		} else {
+			logLikelihood += r.sampleWeight(i) * math.Log(1-pVal)
+		}
+	}
+
+	A, err := r.computeXtilde(pVector, xMatrix)
+	if err != nil {
+		return 0.0, err
+	}
+
+	logDeterminant, ok := choleskyLogDeterminant(matrix.Product(matrix.Transpose(xMatrix), A))
+	if !ok {
+		return math.Inf(-1), nil
+	}
+
+	return logLikelihood + 0.5*logDeterminant, nil
+}
+
+//ln|A| = 2 * Sum(ln Ljj) with A = LL', the determinant itself underflows or overflows on large data
+//False when A is not positive definite
+func choleskyLogDeterminant(a matrix.Matrix) (float64, bool) {
+	n := a.Rows()
+	L := matrix.Zeros(n, n)
+
+	logDeterminant := 0.0
+	for j := 0; j < n; j++ {
+		diagonal := a.Get(j, j)
+		for k := 0; k < j; k++ {
+			diagonal -= L.Get(j, k) * L.Get(j, k)
+		}
+		if diagonal <= 0 || math.IsNaN(diagonal) {
+			return 0.0, false
+		}
+
+		L.Set(j, j, math.Sqrt(diagonal))
+		logDeterminant += math.Log(diagonal)
+
+		for i := j + 1; i < n; i++ {
+			val := a.Get(i, j)
+			for k := 0; k < j; k++ {
+				val -= L.Get(i, k) * L.Get(j, k)
+			}
+			L.Set(i, j, val/L.Get(j, j))
+		}
+	}
+
+	return logDeterminant, true
+}
+
+//Separation warning shown below the model table
+func (r *Regression) separationHTML() string {
+	if !r.model.Separation.Detected {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("<div><strong>Warning: ")
+	if r.model.Separation.Complete {
+		buffer.WriteString("Complete")
+	} else {
+		buffer.WriteString("Quasi-complete")
+	}
+	buffer.WriteString(" separation detected on ")
+
+	for i, name := range r.model.Separation.Variables {
+		if i > 0 {
+			buffer.WriteString(", ")
+		}
+		buffer.WriteString(html.EscapeString(name))
+	}
+	buffer.WriteString(".</strong>")
+
+	if r.fittingMode != FirthFitting {
+		buffer.WriteString(" Maximum likelihood coefficients do not exist, consider using Firth penalized likelihood.")
+	}
+	buffer.WriteString("</div>")
+
+	return buffer.String()
+}
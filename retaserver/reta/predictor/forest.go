@@ -0,0 +1,151 @@
+package predictor
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strconv"
+)
+
+//Random forest of classification trees
+//
+//Every tree is grown on a bootstrap sample of the training data trying a random subset of the features on
+//every split, the retention probability is the mean of all trees. Players left out of a tree's sample give
+//an out-of-bag estimate of the accuracy without any testing data.
+//
+//References:
+// - Breiman, L. (2001). Random Forests. Machine Learning 45(1)
+
+type RandomForest struct {
+	featureEncoder
+	NumTrees    int        //Zero means 50
+	MaxDepth    int        //Zero means 8
+	MinLeaf     float64    //Zero means 3
+	MaxFeatures int        //Zero means square root of the number of features
+	Random      *rand.Rand //Used for the bootstrap samples and the features of every split
+
+	trees      []DecisionTree
+	importance []float64
+	outOfBag   Evaluation
+	hasOOB     bool
+}
+
+func (f *RandomForest) Fit(trainingData []DataPoint) error {
+	err := f.fitFeatures(trainingData)
+	if err != nil {
+		return err
+	}
+
+	if f.NumTrees <= 0 {
+		f.NumTrees = 50
+	}
+	if f.MaxDepth <= 0 {
+		f.MaxDepth = 8
+	}
+	if f.MinLeaf <= 0 {
+		f.MinLeaf = 3
+	}
+	numFeatures := len(f.featureNames())
+	if f.MaxFeatures <= 0 {
+		f.MaxFeatures = int(math.Max(1.0, math.Floor(math.Sqrt(float64(numFeatures)))))
+	}
+	f.Random = classifierRandom(f.Random)
+
+	numData := len(trainingData)
+	x := make([][]float64, numData)
+	y := make([]float64, numData)
+	w := make([]float64, numData)
+	for i, data := range trainingData {
+		x[i] = f.encode(data)
+		y[i] = data.Result
+		w[i] = data.weight()
+	}
+
+	oobTotal := make([]float64, numData)
+	oobCount := make([]int, numData)
+
+	f.trees = make([]DecisionTree, f.NumTrees)
+	f.importance = make([]float64, numFeatures)
+	for k := range f.trees {
+		//Bootstrap sample
+		sample := make([]int, numData)
+		inBag := make([]bool, numData)
+		for i := range sample {
+			sample[i] = f.Random.Intn(numData)
+			inBag[sample[i]] = true
+		}
+
+		tree := &f.trees[k]
+		tree.featureEncoder = f.featureEncoder
+		tree.MaxDepth = f.MaxDepth
+		tree.MinLeaf = f.MinLeaf
+		tree.MaxFeatures = f.MaxFeatures
+		tree.Random = f.Random
+		tree.growTree(x, y, w, sample)
+
+		for j, val := range tree.importance {
+			f.importance[j] += val / float64(f.NumTrees)
+		}
+
+		for i := 0; i < numData; i++ {
+			if !inBag[i] {
+				oobTotal[i] += tree.predictFeatures(x[i])
+				oobCount[i]++
+			}
+		}
+	}
+
+	//Out-of-bag metrics of the players left out by at least one tree
+	var probabilities, observed []float64
+	for i := 0; i < numData; i++ {
+		if oobCount[i] > 0 {
+			probabilities = append(probabilities, oobTotal[i]/float64(oobCount[i]))
+			observed = append(observed, y[i])
+		}
+	}
+	f.hasOOB = len(probabilities) > 0
+	if f.hasOOB {
+		f.outOfBag = evaluateProbabilities(probabilities, observed)
+	}
+
+	return nil
+}
+
+func (f *RandomForest) PredictProba(data DataPoint) (float64, error) {
+	if len(f.trees) == 0 {
+		return 0.5, nil
+	}
+
+	features := f.encode(data)
+
+	total := 0.0
+	for i := range f.trees {
+		total += f.trees[i].predictFeatures(features)
+	}
+
+	return total / float64(len(f.trees)), nil
+}
+
+func (f *RandomForest) Describe() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<div>Random Forest of ")
+	buffer.WriteString(strconv.Itoa(f.NumTrees))
+	buffer.WriteString(" trees with maximum depth ")
+	buffer.WriteString(strconv.Itoa(f.MaxDepth))
+	buffer.WriteString(", ")
+	buffer.WriteString(strconv.Itoa(f.MaxFeatures))
+	buffer.WriteString(" features tried on every split</div>")
+
+	if f.hasOOB {
+		buffer.WriteString("<div>Out-of-bag Accuracy: ")
+		buffer.WriteString(strconv.FormatFloat(f.outOfBag.Accuracy, 'f', 2, 64))
+		buffer.WriteString(", AUC: ")
+		buffer.WriteString(strconv.FormatFloat(f.outOfBag.AUC, 'f', 4, 64))
+		buffer.WriteString("</div>")
+	}
+
+	buffer.WriteString(importanceHTML(f.featureNames(), f.importance))
+
+	return buffer.String()
+}
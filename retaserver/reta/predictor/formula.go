@@ -0,0 +1,367 @@
+package predictor
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"reta/errors"
+)
+
+//Model formula used to build the design matrix from the variables
+//
+//Syntax (similar to R formulas):
+//	retained ~ tutorial + social * level + poly(progression,2)
+//
+// - a + b         both variables as main effects
+// - a : b         interaction only (a times b)
+// - a * b         main effects and interaction (a + b + a:b)
+// - poly(a, n)    polynomial terms a, a^2, ..., a^n
+//
+//Variables are matched against the variable names set in the regression, ignoring case and spaces.
+//An unambiguous prefix is enough, so "tutorial" matches "Tutorial Momentum".
+//The left side of "~" is only a label for the observed variable. Categorical variables can only be main
+//effects, they are added as dummy variables after the formula terms.
+
+type Term struct {
+	Variables []int //Index of each variable in the product
+	Powers    []int //Power of each variable in the product
+}
+
+type Formula struct {
+	Observed string
+	Terms    []Term
+	Factors  []int //Index of each categorical variable in the formula
+}
+
+//Parse formula and resolve the numerical and categorical variables using the given names
+func ParseFormula(formula string, variableNames []string, categoricalNames []string) (Formula, error) {
+	parser := formulaParser{variableNames: append(append([]string{}, variableNames...), categoricalNames...)}
+	parser.tokenize(formula)
+
+	var result Formula
+
+	//Observed variable label
+	for i, token := range parser.tokens {
+		if token == "~" {
+			result.Observed = strings.Join(parser.tokens[:i], " ")
+			parser.position = i + 1
+			break
+		}
+	}
+
+	terms, err := parser.parseSum()
+	if err != nil {
+		return result, err
+	}
+
+	if parser.position < len(parser.tokens) {
+		return result, errors.New("Error: Unexpected '" + parser.tokens[parser.position] + "' in formula")
+	}
+
+	if len(terms) == 0 {
+		return result, errors.New("Error: Formula does not contain any term")
+	}
+
+	//Categorical variables are resolved after the numerical ones
+	for _, term := range terms {
+		categorical := -1
+		for _, index := range term.Variables {
+			if index >= len(variableNames) {
+				categorical = index
+			}
+		}
+
+		if categorical < 0 {
+			result.Terms = append(result.Terms, term)
+		} else if len(term.Variables) == 1 && term.Powers[0] == 1 {
+			result.Factors = append(result.Factors, categorical-len(variableNames))
+		} else {
+			return result, errors.New("Error: Categorical variable '" + parser.variableNames[categorical] + "' can only be a main effect in formula")
+		}
+	}
+
+	return result, nil
+}
+
+//Name of the term in the design matrix, e.g. "Social Activity:Level" or "Progression^2"
+func (t Term) Name(variableNames []string) string {
+	parts := make([]string, len(t.Variables))
+	for i, index := range t.Variables {
+		parts[i] = variableNames[index]
+		if t.Powers[i] > 1 {
+			parts[i] += "^" + strconv.Itoa(t.Powers[i])
+		}
+	}
+
+	return strings.Join(parts, ":")
+}
+
+//Value of the term from the variables of a data point
+func (t Term) Value(variables []float64) float64 {
+	value := 1.0
+	for i, index := range t.Variables {
+		value *= math.Pow(variables[index], float64(t.Powers[i]))
+	}
+
+	return value
+}
+
+//Term with a single variable and power of one, returns the variable index
+func (t Term) isLinear() (int, bool) {
+	if len(t.Variables) == 1 && t.Powers[0] == 1 {
+		return t.Variables[0], true
+	}
+
+	return -1, false
+}
+
+//Product of two terms, powers of the same variable are added
+func (t Term) times(other Term) Term {
+	powers := make(map[int]int)
+	for i, index := range t.Variables {
+		powers[index] += t.Powers[i]
+	}
+	for i, index := range other.Variables {
+		powers[index] += other.Powers[i]
+	}
+
+	var result Term
+	for index := range powers {
+		result.Variables = append(result.Variables, index)
+	}
+	sort.Ints(result.Variables)
+
+	result.Powers = make([]int, len(result.Variables))
+	for i, index := range result.Variables {
+		result.Powers[i] = powers[index]
+	}
+
+	return result
+}
+
+func (t Term) key() string {
+	var key string
+	for i, index := range t.Variables {
+		key += strconv.Itoa(index) + "^" + strconv.Itoa(t.Powers[i]) + " "
+	}
+
+	return key
+}
+
+type formulaParser struct {
+	variableNames []string
+	tokens        []string
+	position      int
+}
+
+func (p *formulaParser) tokenize(formula string) {
+	current := ""
+	flush := func() {
+		if current != "" {
+			p.tokens = append(p.tokens, current)
+			current = ""
+		}
+	}
+
+	for _, ch := range formula {
+		if strings.ContainsRune("~+*:(),", ch) {
+			flush()
+			p.tokens = append(p.tokens, string(ch))
+		} else if unicode.IsSpace(ch) {
+			flush()
+		} else {
+			current += string(ch)
+		}
+	}
+	flush()
+}
+
+func (p *formulaParser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+
+	return ""
+}
+
+func (p *formulaParser) expect(token string) error {
+	if p.peek() != token {
+		return errors.New("Error: Expected '" + token + "' in formula")
+	}
+	p.position++
+
+	return nil
+}
+
+//sum := product ('+' product)*
+func (p *formulaParser) parseSum() ([]Term, error) {
+	terms, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "+" {
+		p.position++
+
+		next, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		terms = appendTerms(terms, next)
+	}
+
+	return terms, nil
+}
+
+//product := factor (('*' | ':') factor)*
+func (p *formulaParser) parseProduct() ([]Term, error) {
+	terms, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peek() == "*" || p.peek() == ":" {
+		operator := p.peek()
+		p.position++
+
+		next, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+
+		var interactions []Term
+		for _, left := range terms {
+			for _, right := range next {
+				interactions = appendTerms(interactions, []Term{left.times(right)})
+			}
+		}
+
+		if operator == "*" {
+			terms = appendTerms(appendTerms(terms, next), interactions)
+		} else {
+			terms = interactions
+		}
+	}
+
+	return terms, nil
+}
+
+//factor := variable | poly '(' variable ',' degree ')' | '(' sum ')'
+func (p *formulaParser) parseFactor() ([]Term, error) {
+	token := p.peek()
+	if token == "" {
+		return nil, errors.New("Error: Unexpected end of formula")
+	}
+
+	if token == "(" {
+		p.position++
+		terms, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+
+		return terms, p.expect(")")
+	}
+
+	if strings.ToLower(token) == "poly" && p.position+1 < len(p.tokens) && p.tokens[p.position+1] == "(" {
+		p.position += 2
+
+		index, err := p.resolve(p.peek())
+		if err != nil {
+			return nil, err
+		}
+		p.position++
+
+		err = p.expect(",")
+		if err != nil {
+			return nil, err
+		}
+
+		degree, err := strconv.Atoi(p.peek())
+		if err != nil || degree < 1 {
+			return nil, errors.New("Error: Polynomial degree must be a positive number")
+		}
+		p.position++
+
+		err = p.expect(")")
+		if err != nil {
+			return nil, err
+		}
+
+		terms := make([]Term, degree)
+		for i := 0; i < degree; i++ {
+			terms[i] = Term{Variables: []int{index}, Powers: []int{i + 1}}
+		}
+
+		return terms, nil
+	}
+
+	index, err := p.resolve(token)
+	if err != nil {
+		return nil, err
+	}
+	p.position++
+
+	return []Term{{Variables: []int{index}, Powers: []int{1}}}, nil
+}
+
+//Find variable index by exact name or unambiguous prefix, ignoring case and spaces
+func (p *formulaParser) resolve(name string) (int, error) {
+	normalize := func(s string) string {
+		return strings.ToLower(strings.Map(func(ch rune) rune {
+			if unicode.IsSpace(ch) || ch == '_' || ch == '-' {
+				return -1
+			}
+			return ch
+		}, s))
+	}
+
+	target := normalize(name)
+	if target == "" || strings.ContainsAny(target, "~+*:(),") {
+		return -1, errors.New("Error: Expected variable name in formula")
+	}
+
+	found := -1
+	matches := 0
+	for i, variable := range p.variableNames {
+		normalized := normalize(variable)
+		if normalized == target {
+			return i, nil
+		}
+
+		if strings.HasPrefix(normalized, target) {
+			found = i
+			matches++
+		}
+	}
+
+	if matches == 0 {
+		return -1, errors.New("Error: Unknown variable '" + name + "' in formula")
+	} else if matches > 1 {
+		return -1, errors.New("Error: Ambiguous variable '" + name + "' in formula")
+	}
+
+	return found, nil
+}
+
+//Append terms which are not in the list yet
+func appendTerms(terms []Term, others []Term) []Term {
+	for _, other := range others {
+		exist := false
+		for _, term := range terms {
+			if term.key() == other.key() {
+				exist = true
+				break
+			}
+		}
+
+		if !exist {
+			terms = append(terms, other)
+		}
+	}
+
+	return terms
+}
@@ -0,0 +1,106 @@
+package predictor
+
+import (
+	"math/rand"
+)
+
+//Class imbalance handling of the training data
+//
+//Most players churn on day 1, a model fitted on the raw data mostly learns to predict "not retained".
+//Only the training data is balanced, testing data is always used as it is so the metrics stay honest.
+
+type ImbalanceMode int
+
+const (
+	NoBalancing     ImbalanceMode = iota //Use training data as it is
+	BalancedWeights                      //Weight of each class is total / (2 * class size)
+	Undersampling                        //Randomly drop players of the larger class
+	Oversampling                         //Randomly duplicate players of the smaller class
+)
+
+func (m ImbalanceMode) String() string {
+	switch m {
+	case BalancedWeights:
+		return "Class-Balanced Weights"
+	case Undersampling:
+		return "Random Undersampling"
+	case Oversampling:
+		return "Random Oversampling"
+	default:
+		return "None"
+	}
+}
+
+//Return balanced copy of the training data points
+func balanceDataPoints(points []DataPoint, mode ImbalanceMode, random *rand.Rand) []DataPoint {
+	var positives, negatives []DataPoint
+	for _, point := range points {
+		if point.Result == 1.0 {
+			positives = append(positives, point)
+		} else {
+			negatives = append(negatives, point)
+		}
+	}
+
+	//Nothing to balance with a single class
+	if len(positives) == 0 || len(negatives) == 0 {
+		return points
+	}
+
+	smaller, larger := positives, negatives
+	if len(smaller) > len(larger) {
+		smaller, larger = larger, smaller
+	}
+
+	switch mode {
+	case BalancedWeights:
+		total := float64(len(points))
+		positiveWeight := total / (2.0 * float64(len(positives)))
+		negativeWeight := total / (2.0 * float64(len(negatives)))
+
+		balanced := make([]DataPoint, len(points))
+		for i, point := range points {
+			balanced[i] = point
+			if point.Result == 1.0 {
+				balanced[i].Weight = point.weight() * positiveWeight
+			} else {
+				balanced[i].Weight = point.weight() * negativeWeight
+			}
+		}
+		return balanced
+	case Undersampling:
+		//Keep a random subset of the larger class with the size of the smaller one
+		indexes := random.Perm(len(larger))[:len(smaller)]
+
+		balanced := make([]DataPoint, 0, 2*len(smaller))
+		balanced = append(balanced, smaller...)
+		for _, index := range indexes {
+			balanced = append(balanced, larger[index])
+		}
+		return balanced
+	case Oversampling:
+		//Draw from the smaller class with replacement until both classes have the same size
+		balanced := make([]DataPoint, 0, 2*len(larger))
+		balanced = append(balanced, larger...)
+		balanced = append(balanced, smaller...)
+		for i := len(smaller); i < len(larger); i++ {
+			balanced = append(balanced, smaller[random.Intn(len(smaller))])
+		}
+		return balanced
+	default:
+		return points
+	}
+}
+
+//Number of retained and not retained data points, weighted by the sample weights
+func classTotals(points []DataPoint) (positive float64, negative float64) {
+	for _, point := range points {
+		if point.Result == 1.0 {
+			positive += point.weight()
+		} else {
+			negative += point.weight()
+		}
+	}
+
+	return positive, negative
+}
@@ -0,0 +1,357 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/skelterjohn/go.matrix"
+)
+
+//Kaplan-Meier estimate of the survival function
+//
+//S(t) = Product over churn times t[i] <= t of (1 - d[i] / n[i]) where d[i] players churned at t[i] and n[i]
+//players were still at risk. Censored players leave the risk set without lowering the curve.
+//Confidence intervals use Greenwood's variance on the log(-log S(t)) scale so they stay within [0, 1].
+//
+//References:
+// - Kaplan, E. and Meier, P. (1958). Nonparametric Estimation from Incomplete Observations. JASA 53(282)
+// - Mantel, N. (1966). Evaluation of survival data and two new rank order statistics (log-rank test)
+
+type KaplanMeier struct {
+	Segment  string
+	Players  int
+	Churned  int
+	Times    []float64 //Distinct churn times in days
+	AtRisk   []int
+	Events   []int
+	Survival []float64
+	Lower    []float64 //95% confidence interval of the survival
+	Upper    []float64
+	Median   float64 //First time survival is 0.5 or less, NaN when it never is
+}
+
+func kaplanMeier(segment string, points []SurvivalPoint) KaplanMeier {
+	curve := KaplanMeier{Segment: segment, Players: len(points), Median: math.NaN()}
+
+	sorted := make([]SurvivalPoint, len(points))
+	copy(sorted, points)
+	sort.Sort(byLifetime(sorted))
+
+	survival := 1.0
+	greenwood := 0.0
+	atRisk := len(sorted)
+	for i := 0; i < len(sorted); {
+		//Players churning and censored at the same time
+		time := sorted[i].Lifetime
+		events, leaving := 0, 0
+		for ; i < len(sorted) && sorted[i].Lifetime == time; i++ {
+			if sorted[i].Churned {
+				events++
+			}
+			leaving++
+		}
+
+		if events > 0 {
+			survival *= 1.0 - float64(events)/float64(atRisk)
+			if atRisk > events {
+				greenwood += float64(events) / (float64(atRisk) * float64(atRisk-events))
+			}
+
+			lower, upper := survivalInterval(survival, greenwood)
+
+			curve.Times = append(curve.Times, time)
+			curve.AtRisk = append(curve.AtRisk, atRisk)
+			curve.Events = append(curve.Events, events)
+			curve.Survival = append(curve.Survival, survival)
+			curve.Lower = append(curve.Lower, lower)
+			curve.Upper = append(curve.Upper, upper)
+			curve.Churned += events
+
+			if math.IsNaN(curve.Median) && survival <= 0.5 {
+				curve.Median = time
+			}
+		}
+
+		atRisk -= leaving
+	}
+
+	return curve
+}
+
+//exp(-exp(log(-log S) -+ 1.96 * se)) where se^2 = Greenwood / (log S)^2
+func survivalInterval(survival float64, greenwood float64) (float64, float64) {
+	if survival <= 0 || survival >= 1 {
+		return survival, survival
+	}
+
+	logSurvival := math.Log(survival)
+	se := math.Sqrt(greenwood) / math.Abs(logSurvival)
+	center := math.Log(-logSurvival)
+
+	return math.Exp(-math.Exp(center + 1.96*se)), math.Exp(-math.Exp(center - 1.96*se))
+}
+
+//Index of the last churn time at or before the given time, -1 before the first one
+func (k KaplanMeier) index(time float64) int {
+	index := -1
+	for i, t := range k.Times {
+		if t > time {
+			break
+		}
+		index = i
+	}
+
+	return index
+}
+
+//Survival at the given time, the curve is a step function
+func (k KaplanMeier) At(time float64) float64 {
+	i := k.index(time)
+	if i == -1 {
+		return 1.0
+	}
+
+	return k.Survival[i]
+}
+
+//Lifetime, churn and segment of a player in the log-rank test
+type rankPoint struct {
+	lifetime float64
+	churned  bool
+	segment  int
+}
+
+type byRankLifetime []rankPoint
+
+func (b byRankLifetime) Len() int           { return len(b) }
+func (b byRankLifetime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byRankLifetime) Less(i, j int) bool { return b[i].lifetime < b[j].lifetime }
+
+//Log-rank test of equal survival in every segment, chi-square with segments - 1 degrees of freedom
+func logRankTest(segments [][]SurvivalPoint) (chiSquare float64, df int, pValue float64) {
+	numSegments := len(segments)
+	if numSegments < 2 {
+		return 0.0, 0, 1.0
+	}
+
+	//Players of all segments sorted by lifetime
+	var all []rankPoint
+	for g, points := range segments {
+		for _, point := range points {
+			all = append(all, rankPoint{lifetime: point.Lifetime, churned: point.Churned, segment: g})
+		}
+	}
+	sort.Sort(byRankLifetime(all))
+
+	//Observed minus expected churns and their covariance, the last segment is left out
+	k := numSegments - 1
+	difference := make([]float64, k)
+	covariance := make([][]float64, k)
+	for g := range covariance {
+		covariance[g] = make([]float64, k)
+	}
+
+	//Every player is at risk until its lifetime, the counts go down while walking the sorted lifetimes
+	atRisk := make([]float64, numSegments)
+	total := 0.0
+	for g, points := range segments {
+		atRisk[g] = float64(len(points))
+		total += atRisk[g]
+	}
+
+	events := make([]float64, numSegments)
+	for i := 0; i < len(all); {
+		//Players with the same lifetime
+		next := i
+		totalEvents := 0.0
+		for g := range events {
+			events[g] = 0.0
+		}
+		for next < len(all) && all[next].lifetime == all[i].lifetime {
+			if all[next].churned {
+				events[all[next].segment]++
+				totalEvents++
+			}
+			next++
+		}
+
+		if totalEvents > 0 && total >= 2 {
+			for g := 0; g < k; g++ {
+				difference[g] += events[g] - totalEvents*atRisk[g]/total
+				for h := 0; h < k; h++ {
+					shared := totalEvents * (total - totalEvents) / (total - 1)
+					if g == h {
+						covariance[g][h] += shared * atRisk[g] / total * (1.0 - atRisk[g]/total)
+					} else {
+						covariance[g][h] -= shared * atRisk[g] * atRisk[h] / (total * total)
+					}
+				}
+			}
+		}
+
+		//Churned and censored players leave the risk set after their lifetime
+		for ; i < next; i++ {
+			atRisk[all[i].segment]--
+			total--
+		}
+	}
+
+	V := matrix.Zeros(k, k)
+	for g := 0; g < k; g++ {
+		for h := 0; h < k; h++ {
+			V.Set(g, h, covariance[g][h])
+		}
+	}
+
+	inverse := matrix.Inverse(V)
+	if inverse == nil {
+		return 0.0, k, 1.0
+	}
+
+	for g := 0; g < k; g++ {
+		for h := 0; h < k; h++ {
+			chiSquare += difference[g] * inverse.Get(g, h) * difference[h]
+		}
+	}
+
+	return chiSquare, k, chiSquarePValue(chiSquare, k)
+}
+
+//Survival points by lifetime, churned before censored at the same time
+type byLifetime []SurvivalPoint
+
+func (b byLifetime) Len() int      { return len(b) }
+func (b byLifetime) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byLifetime) Less(i, j int) bool {
+	if b[i].Lifetime == b[j].Lifetime {
+		return b[i].Churned && !b[j].Churned
+	}
+	return b[i].Lifetime < b[j].Lifetime
+}
+
+var curveColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f"}
+
+//Step plot of the survival curves as inline SVG
+func kaplanMeierSVG(curves []KaplanMeier) string {
+	width, height := 600.0, 300.0
+	left, bottom := 50.0, 30.0
+
+	maxTime := 1.0
+	for _, curve := range curves {
+		for _, t := range curve.Times {
+			maxTime = math.Max(maxTime, t)
+		}
+	}
+
+	x := func(t float64) string {
+		return strconv.FormatFloat(left+t/maxTime*(width-left-10.0), 'f', 1, 64)
+	}
+	y := func(s float64) string {
+		return strconv.FormatFloat(10.0+(1.0-s)*(height-bottom-10.0), 'f', 1, 64)
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"")
+	buffer.WriteString(strconv.FormatFloat(width+160.0, 'f', 0, 64))
+	buffer.WriteString("\" height=\"")
+	buffer.WriteString(strconv.FormatFloat(height, 'f', 0, 64))
+	buffer.WriteString("\" font-size=\"11\">")
+
+	//Axes with survival and days labels
+	buffer.WriteString("<line x1=\"" + x(0) + "\" y1=\"" + y(0) + "\" x2=\"" + x(maxTime) + "\" y2=\"" + y(0) + "\" stroke=\"black\"/>")
+	buffer.WriteString("<line x1=\"" + x(0) + "\" y1=\"" + y(0) + "\" x2=\"" + x(0) + "\" y2=\"" + y(1) + "\" stroke=\"black\"/>")
+	for _, s := range []float64{0.0, 0.25, 0.5, 0.75, 1.0} {
+		buffer.WriteString("<text x=\"5\" y=\"" + y(s) + "\">" + strconv.FormatFloat(s, 'f', 2, 64) + "</text>")
+	}
+	for i := 0; i <= 4; i++ {
+		t := maxTime * float64(i) / 4.0
+		buffer.WriteString("<text x=\"" + x(t) + "\" y=\"" + strconv.FormatFloat(height-10.0, 'f', 1, 64) + "\">" + strconv.FormatFloat(t, 'f', 0, 64) + "</text>")
+	}
+	buffer.WriteString("<text x=\"" + x(maxTime/2.0) + "\" y=\"" + strconv.FormatFloat(height, 'f', 1, 64) + "\">Days</text>")
+
+	for c, curve := range curves {
+		color := curveColors[c%len(curveColors)]
+
+		buffer.WriteString("<path fill=\"none\" stroke=\"" + color + "\" d=\"M" + x(0) + " " + y(1))
+		for i, t := range curve.Times {
+			buffer.WriteString(" H" + x(t) + " V" + y(curve.Survival[i]))
+		}
+		buffer.WriteString("\"/>")
+
+		//Legend
+		legendY := strconv.FormatFloat(20.0+float64(c)*15.0, 'f', 1, 64)
+		buffer.WriteString("<rect x=\"" + strconv.FormatFloat(width+5.0, 'f', 1, 64) + "\" y=\"" + legendY + "\" width=\"10\" height=\"10\" fill=\"" + color + "\"/>")
+		buffer.WriteString("<text x=\"" + strconv.FormatFloat(width+20.0, 'f', 1, 64) + "\" y=\"" + strconv.FormatFloat(30.0+float64(c)*15.0, 'f', 1, 64) + "\">" + html.EscapeString(curve.Segment) + "</text>")
+	}
+
+	buffer.WriteString("</svg>")
+
+	return buffer.String()
+}
+
+//Players, churn, median lifetime and survival at fixed days of every curve
+func kaplanMeierHTML(curves []KaplanMeier) string {
+	days := []float64{1, 7, 14, 30}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Segment</td>")
+	buffer.WriteString("<td>Players</td>")
+	buffer.WriteString("<td>Churned</td>")
+	buffer.WriteString("<td>Censored</td>")
+	buffer.WriteString("<td>Median Lifetime (days)</td>")
+	for _, day := range days {
+		buffer.WriteString("<td>Survival Day ")
+		buffer.WriteString(strconv.FormatFloat(day, 'f', 0, 64))
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for _, curve := range curves {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(curve.Segment))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(curve.Players))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(curve.Churned))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(curve.Players - curve.Churned))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if math.IsNaN(curve.Median) {
+			buffer.WriteString("Not reached")
+		} else {
+			buffer.WriteString(strconv.FormatFloat(curve.Median, 'f', 0, 64))
+		}
+		buffer.WriteString("</td>")
+		for _, day := range days {
+			buffer.WriteString("<td>")
+			if i := curve.index(day); i == -1 {
+				buffer.WriteString("1.0000")
+			} else {
+				buffer.WriteString(strconv.FormatFloat(curve.Survival[i], 'f', 4, 64))
+				buffer.WriteString(" (")
+				buffer.WriteString(strconv.FormatFloat(curve.Lower[i], 'f', 4, 64))
+				buffer.WriteString(" - ")
+				buffer.WriteString(strconv.FormatFloat(curve.Upper[i], 'f', 4, 64))
+				buffer.WriteString(")")
+			}
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,485 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"appengine"
+
+	"reta/db"
+	"reta/errors"
+)
+
+//Player segmentation with k-means clustering
+//
+//Numerical variables are standardized to z-scores (missing values use the mean) so every variable has the
+//same weight in the distance. Centers start with k-means++, each next center is picked with probability
+//proportional to the squared distance to the nearest chosen center, and Lloyd iterations move every player
+//to the nearest center until no assignment changes. The start with the lowest inertia of a few is kept.
+//
+//Without a fixed k every k from 2 to MaxK is fitted and the one with the highest mean silhouette is used,
+//silhouette is (b - a) / max(a, b) where a is the mean distance to the own segment and b to the nearest other.
+//
+//References:
+// - Arthur, D. and Vassilvitskii, S. (2007). k-means++: The Advantages of Careful Seeding. SODA
+// - Rousseeuw, P. (1987). Silhouettes: a Graphical Aid to the Interpretation and Validation of Cluster Analysis
+
+const silhouetteSample = 1000 //Players used for the silhouette, which needs every pairwise distance
+
+type KMeans struct {
+	K             int        //Number of segments, zero picks k by silhouette
+	MaxK          int        //Largest k tried when picking, zero means 8
+	MaxIterations int        //Zero means 100
+	Restarts      int        //k-means++ starts of every k, zero means 5
+	Random        *rand.Rand //Used for k-means++ and the silhouette sample
+
+	Centroids   [][]float64 //Center of each segment in the original units
+	Sizes       []int       //Players of each segment
+	Inertia     float64     //Sum of squared standardized distances to the centers
+	Silhouette  float64     //Mean silhouette of the chosen k
+	Silhouettes []float64   //Mean silhouette of every k tried, starting from 2
+
+	variableNames []string
+	means         []float64
+	deviations    []float64
+	centers       [][]float64 //Standardized centers
+	candidates    []int       //Every k tried
+}
+
+func (k *KMeans) SetVariableNames(names []string) {
+	k.variableNames = names
+}
+
+func (k *KMeans) Fit(data []DataPoint) error {
+	numVariables := len(k.variableNames)
+	if numVariables == 0 {
+		return errors.New("Error: Need some variables to segment players")
+	}
+
+	if k.MaxK <= 1 {
+		k.MaxK = 8
+	}
+	if k.MaxIterations <= 0 {
+		k.MaxIterations = 100
+	}
+	if k.Restarts <= 0 {
+		k.Restarts = 5
+	}
+	k.Random = classifierRandom(k.Random)
+
+	//Standardize with the mean and deviation of the observed values
+	k.means = make([]float64, numVariables)
+	k.deviations = make([]float64, numVariables)
+	for j := 0; j < numVariables; j++ {
+		var values []float64
+		for _, point := range data {
+			if len(point.Variables) != numVariables {
+				return errors.New("Error: Number of variables in the data != in the model")
+			}
+			if !point.isMissing(j) {
+				values = append(values, point.Variables[j])
+			}
+		}
+
+		for _, val := range values {
+			k.means[j] += val
+		}
+		if len(values) > 0 {
+			k.means[j] /= float64(len(values))
+		}
+		for _, val := range values {
+			k.deviations[j] += (val - k.means[j]) * (val - k.means[j])
+		}
+		if len(values) > 1 {
+			k.deviations[j] = math.Sqrt(k.deviations[j] / float64(len(values)-1))
+		}
+	}
+
+	x := make([][]float64, len(data))
+	for i, point := range data {
+		x[i] = k.standardize(point)
+	}
+
+	//Fixed k or every k up to the maximum
+	k.candidates = nil
+	if k.K > 0 {
+		k.candidates = []int{k.K}
+	} else {
+		for candidate := 2; candidate <= k.MaxK; candidate++ {
+			k.candidates = append(k.candidates, candidate)
+		}
+	}
+	if k.candidates[0] > len(data) {
+		return errors.New("Error: Need more players than segments")
+	}
+
+	k.Silhouettes = nil
+	k.Silhouette = math.Inf(-1)
+	k.centers = nil
+	for _, candidate := range k.candidates {
+		if candidate > len(data) {
+			break
+		}
+
+		centers, assignments, inertia := k.bestClustering(x, candidate)
+		silhouette := k.meanSilhouette(x, assignments, candidate)
+		k.Silhouettes = append(k.Silhouettes, silhouette)
+
+		//A single k is kept even when its silhouette is undefined
+		if silhouette > k.Silhouette || k.centers == nil || len(k.candidates) == 1 {
+			k.Silhouette = silhouette
+			k.centers = centers
+			k.Inertia = inertia
+			k.Sizes = make([]int, candidate)
+			for _, assignment := range assignments {
+				k.Sizes[assignment]++
+			}
+		}
+	}
+
+	//Centroids back in the original units
+	k.Centroids = make([][]float64, len(k.centers))
+	for s, center := range k.centers {
+		k.Centroids[s] = make([]float64, numVariables)
+		for j, val := range center {
+			k.Centroids[s][j] = k.means[j] + val*k.deviations[j]
+		}
+	}
+
+	return nil
+}
+
+//Z-scores of the variables, missing and constant variables are zero
+func (k *KMeans) standardize(data DataPoint) []float64 {
+	features := make([]float64, len(k.means))
+	for j := range features {
+		if j < len(data.Variables) && !data.isMissing(j) && k.deviations[j] > 0 {
+			features[j] = (data.Variables[j] - k.means[j]) / k.deviations[j]
+		}
+	}
+
+	return features
+}
+
+//Lowest inertia of the k-means++ starts
+func (k *KMeans) bestClustering(x [][]float64, numSegments int) ([][]float64, []int, float64) {
+	var bestCenters [][]float64
+	var bestAssignments []int
+	bestInertia := math.Inf(1)
+	for restart := 0; restart < k.Restarts; restart++ {
+		centers, assignments, inertia := k.lloyd(x, k.initialCenters(x, numSegments))
+		if inertia < bestInertia {
+			bestCenters, bestAssignments, bestInertia = centers, assignments, inertia
+		}
+	}
+
+	return bestCenters, bestAssignments, bestInertia
+}
+
+//k-means++ seeding
+func (k *KMeans) initialCenters(x [][]float64, numSegments int) [][]float64 {
+	centers := [][]float64{copyFloats(x[k.Random.Intn(len(x))])}
+
+	distances := make([]float64, len(x))
+	for len(centers) < numSegments {
+		total := 0.0
+		for i, row := range x {
+			_, distances[i] = nearestCenter(row, centers)
+			total += distances[i]
+		}
+
+		//Every player is on a center already, pick any
+		if total == 0 {
+			centers = append(centers, copyFloats(x[k.Random.Intn(len(x))]))
+			continue
+		}
+
+		target := k.Random.Float64() * total
+		chosen := len(x) - 1
+		for i, distance := range distances {
+			target -= distance
+			if target <= 0 {
+				chosen = i
+				break
+			}
+		}
+		centers = append(centers, copyFloats(x[chosen]))
+	}
+
+	return centers
+}
+
+//Lloyd iterations until no player changes segment
+func (k *KMeans) lloyd(x [][]float64, centers [][]float64) ([][]float64, []int, float64) {
+	assignments := make([]int, len(x))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+
+	inertia := 0.0
+	converged := false
+	for iteration := 0; iteration < k.MaxIterations; iteration++ {
+		changed := false
+		inertia = 0.0
+		for i, row := range x {
+			nearest, distance := nearestCenter(row, centers)
+			if nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+			inertia += distance
+		}
+
+		if !changed {
+			converged = true
+			break
+		}
+
+		//Move every center to the mean of its players, empty segments keep their center
+		sums := make([][]float64, len(centers))
+		counts := make([]int, len(centers))
+		for s := range sums {
+			sums[s] = make([]float64, len(centers[s]))
+		}
+		for i, row := range x {
+			counts[assignments[i]]++
+			for j, val := range row {
+				sums[assignments[i]][j] += val
+			}
+		}
+		for s := range centers {
+			if counts[s] == 0 {
+				continue
+			}
+			for j := range centers[s] {
+				centers[s][j] = sums[s][j] / float64(counts[s])
+			}
+		}
+	}
+
+	//Centers moved after the last assignment when the iterations ran out
+	if !converged {
+		inertia = 0.0
+		for i, row := range x {
+			nearest, distance := nearestCenter(row, centers)
+			assignments[i] = nearest
+			inertia += distance
+		}
+	}
+
+	return centers, assignments, inertia
+}
+
+//Mean silhouette of a random sample of players
+func (k *KMeans) meanSilhouette(x [][]float64, assignments []int, numSegments int) float64 {
+	indexes := k.Random.Perm(len(x))
+	if len(indexes) > silhouetteSample {
+		indexes = indexes[:silhouetteSample]
+	}
+
+	total, count := 0.0, 0
+	for _, i := range indexes {
+		sums := make([]float64, numSegments)
+		counts := make([]int, numSegments)
+		for _, j := range indexes {
+			if i == j {
+				continue
+			}
+			sums[assignments[j]] += math.Sqrt(squaredDistance(x[i], x[j]))
+			counts[assignments[j]]++
+		}
+
+		own := assignments[i]
+		if counts[own] == 0 {
+			//Alone in its segment
+			count++
+			continue
+		}
+
+		a := sums[own] / float64(counts[own])
+		b := math.Inf(1)
+		for s := range sums {
+			if s != own && counts[s] > 0 {
+				b = math.Min(b, sums[s]/float64(counts[s]))
+			}
+		}
+		if math.IsInf(b, 1) {
+			continue
+		}
+
+		if math.Max(a, b) > 0 {
+			total += (b - a) / math.Max(a, b)
+		}
+		count++
+	}
+
+	if count == 0 {
+		return math.NaN()
+	}
+
+	return total / float64(count)
+}
+
+//Index of the nearest center and the squared distance to it
+func nearestCenter(row []float64, centers [][]float64) (int, float64) {
+	nearest, best := 0, math.Inf(1)
+	for s, center := range centers {
+		distance := squaredDistance(row, center)
+		if distance < best {
+			nearest, best = s, distance
+		}
+	}
+
+	return nearest, best
+}
+
+func squaredDistance(a []float64, b []float64) float64 {
+	total := 0.0
+	for j := range a {
+		total += (a[j] - b[j]) * (a[j] - b[j])
+	}
+
+	return total
+}
+
+func copyFloats(values []float64) []float64 {
+	copied := make([]float64, len(values))
+	copy(copied, values)
+
+	return copied
+}
+
+//Segment index of the data point
+func (k *KMeans) Assign(data DataPoint) int {
+	nearest, _ := nearestCenter(k.standardize(data), k.centers)
+
+	return nearest
+}
+
+//Store the segmentation so players can be assigned to its segments later
+func storeSegmentation(c appengine.Context, kmeans *KMeans) error {
+	segmentation := db.Segmentation{
+		Variables:  kmeans.variableNames,
+		Means:      kmeans.means,
+		Deviations: kmeans.deviations,
+		Silhouette: kmeans.Silhouette,
+		Inertia:    kmeans.Inertia,
+		Created:    time.Now(),
+	}
+
+	segments := make([]db.Segment, len(kmeans.Centroids))
+	for s, centroid := range kmeans.Centroids {
+		segments[s] = db.Segment{Index: s, Size: kmeans.Sizes[s], Centroid: centroid}
+	}
+
+	return db.PutSegmentation(c, segmentation, segments)
+}
+
+//Segment of the player in the stored segmentation, empty when players were never segmented with the same variables
+func PlayerSegment(c appengine.Context, info PlayerInfo) (string, error) {
+	var segmentation db.Segmentation
+	var segments []db.Segment
+	found, err := db.GetSegmentation(c, &segmentation, &segments)
+	if err != nil || !found || len(segments) == 0 {
+		return "", err
+	}
+
+	if strings.Join(segmentation.Variables, ",") != strings.Join(playerVariableNames, ",") {
+		return "", nil
+	}
+
+	kmeans := KMeans{variableNames: segmentation.Variables, means: segmentation.Means, deviations: segmentation.Deviations}
+	for _, segment := range segments {
+		center := make([]float64, len(segment.Centroid))
+		for j, val := range segment.Centroid {
+			if kmeans.deviations[j] > 0 {
+				center[j] = (val - kmeans.means[j]) / kmeans.deviations[j]
+			}
+		}
+		kmeans.centers = append(kmeans.centers, center)
+	}
+
+	return segmentName(kmeans.Assign(playerDataPoint(info, nil))), nil
+}
+
+//Name of the segment used as the categorical level
+func segmentName(segment int) string {
+	return "Segment " + strconv.Itoa(segment+1)
+}
+
+//Silhouette of every k, and size, retention rate and centroid of every segment of the data
+func (k *KMeans) StringHTML(data []DataPoint) string {
+	retained := make([]float64, len(k.centers))
+	totals := make([]float64, len(k.centers))
+	for _, point := range data {
+		segment := k.Assign(point)
+		retained[segment] += point.weight() * point.Result
+		totals[segment] += point.weight()
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Player Segments (k-means, k = ")
+	buffer.WriteString(strconv.Itoa(len(k.centers)))
+	buffer.WriteString(")</h3></div>")
+
+	if len(k.candidates) > 1 {
+		buffer.WriteString("<div>Mean silhouette of every k: ")
+		for c, silhouette := range k.Silhouettes {
+			if c > 0 {
+				buffer.WriteString(", ")
+			}
+			buffer.WriteString(strconv.Itoa(k.candidates[c]))
+			buffer.WriteString(": ")
+			buffer.WriteString(strconv.FormatFloat(silhouette, 'f', 4, 64))
+		}
+		buffer.WriteString("</div>")
+	}
+	buffer.WriteString("<div>Silhouette: ")
+	buffer.WriteString(strconv.FormatFloat(k.Silhouette, 'f', 4, 64))
+	buffer.WriteString(", Inertia: ")
+	buffer.WriteString(strconv.FormatFloat(k.Inertia, 'f', 2, 64))
+	buffer.WriteString("</div>")
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Segment</td>")
+	buffer.WriteString("<td>Size</td>")
+	buffer.WriteString("<td>Retention Rate (%)</td>")
+	for _, name := range k.variableNames {
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for s, centroid := range k.Centroids {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(segmentName(s))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(k.Sizes[s]))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if totals[s] > 0 {
+			buffer.WriteString(strconv.FormatFloat(100.0*retained[s]/totals[s], 'f', 2, 64))
+		}
+		buffer.WriteString("</td>")
+		for _, val := range centroid {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(val, 'f', 4, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
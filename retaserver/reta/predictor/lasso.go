@@ -0,0 +1,283 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"strconv"
+)
+
+//LASSO (L1 penalized) logistic regression path
+//
+//Minimize -(1/n) ln LF + lambda * TotalAddition(|b[j]|) over a decreasing lambda grid using IRLS with
+//cyclic coordinate descent on standardized columns, warm starting each lambda from the previous one.
+//With sample weights, ln LF is the weighted log likelihood and n is the total weight.
+//
+//References:
+// - Friedman, J., Hastie, T. and Tibshirani, R. (2010). Regularization Paths for Generalized Linear Models
+//   via Coordinate Descent. Journal of Statistical Software 33(1)
+
+type LassoPath struct {
+	Names        []string
+	Lambdas      []float64
+	Coefficients [][]float64 //Standardized coefficients of every design variable for each lambda
+}
+
+func (r *Regression) lassoSelection() (*LassoPath, []CandidateModel, error) {
+	names := r.designNames()
+	numData := len(r.dataPoints)
+	numColumns := len(names)
+
+	//Design matrix with standardized columns
+	x := make([][]float64, numData)
+	y := make([]float64, numData)
+	v := make([]float64, numData)
+	totalWeight := 0.0
+	for i, data := range r.dataPoints {
+		//Copied since the design can share the variables of the data point, which are standardized below
+		x[i] = append([]float64(nil), r.designVariables(data)...)
+		y[i] = data.Result
+		v[i] = data.weight()
+		totalWeight += v[i]
+	}
+
+	for j := 0; j < numColumns; j++ {
+		mean, deviation := 0.0, 0.0
+		for i := 0; i < numData; i++ {
+			mean += x[i][j]
+		}
+		mean /= float64(numData)
+		for i := 0; i < numData; i++ {
+			deviation += (x[i][j] - mean) * (x[i][j] - mean)
+		}
+		deviation = math.Sqrt(deviation / float64(numData))
+		if deviation == 0 {
+			deviation = 1.0
+		}
+		for i := 0; i < numData; i++ {
+			x[i][j] = (x[i][j] - mean) / deviation
+		}
+	}
+
+	//Largest lambda where every coefficient is zero
+	meanY := 0.0
+	for i, val := range y {
+		meanY += v[i] * val
+	}
+	meanY /= totalWeight
+
+	lambdaMax := 0.0
+	for j := 0; j < numColumns; j++ {
+		gradient := 0.0
+		for i := 0; i < numData; i++ {
+			gradient += v[i] * x[i][j] * (y[i] - meanY)
+		}
+		lambdaMax = math.Max(lambdaMax, math.Abs(gradient)/totalWeight)
+	}
+
+	path := &LassoPath{Names: names}
+
+	numLambdas := 20
+	ratio := 0.001
+	beta := make([]float64, numColumns)
+	intercept := 0.0
+	if meanY > 0 && meanY < 1 {
+		intercept = math.Log(meanY / (1 - meanY))
+	}
+
+	for l := 0; l < numLambdas; l++ {
+		lambda := lambdaMax * math.Pow(ratio, float64(l)/float64(numLambdas-1))
+		intercept = lassoFit(x, y, v, beta, intercept, lambda)
+
+		coefficients := make([]float64, numColumns)
+		copy(coefficients, beta)
+		path.Lambdas = append(path.Lambdas, lambda)
+		path.Coefficients = append(path.Coefficients, coefficients)
+	}
+
+	if r.debugMode {
+		r.debugContext.Infof("\nLASSO path lambdas: %v", path.Lambdas)
+	}
+
+	//Map design column to selection unit, missing indicators are kept in every candidate
+	numTerms := r.numTerms()
+	columnUnit := make([]int, numColumns)
+	for j := 0; j < numTerms; j++ {
+		columnUnit[j] = j
+	}
+	column := numTerms
+	for k := 0; k < r.numIndicators(); k++ {
+		columnUnit[column] = -1
+		column++
+	}
+	for f, factor := range r.model.Factors {
+		for k := 0; k < len(factor.Levels); k++ {
+			columnUnit[column] = numTerms + f
+			column++
+		}
+	}
+
+	//Refit every distinct active set without penalty
+	var candidates []CandidateModel
+	seen := make(map[string]bool)
+	for l, coefficients := range path.Coefficients {
+		units := make([]bool, numTerms+len(r.model.Factors))
+		for j, val := range coefficients {
+			if val != 0 && columnUnit[j] >= 0 {
+				units[columnUnit[j]] = true
+			}
+		}
+
+		key := unitsKey(units)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var terms, factors []int
+		for unit, in := range units {
+			if in && unit < numTerms {
+				terms = append(terms, unit)
+			} else if in {
+				factors = append(factors, unit-numTerms)
+			}
+		}
+
+		candidate, err := r.newCandidate(terms, factors)
+		if err != nil {
+			continue
+		}
+		candidate.Lambda = path.Lambdas[l]
+		candidates = append(candidates, candidate)
+	}
+
+	return path, candidates, nil
+}
+
+//IRLS with coordinate descent for a single lambda, beta is updated in place and intercept is returned
+func lassoFit(x [][]float64, y []float64, v []float64, beta []float64, intercept float64, lambda float64) float64 {
+	numData := len(y)
+	numColumns := len(beta)
+
+	totalWeight := 0.0
+	for _, val := range v {
+		totalWeight += val
+	}
+
+	eta := make([]float64, numData)
+	weights := make([]float64, numData)
+	working := make([]float64, numData)
+	residual := make([]float64, numData)
+
+	for outer := 0; outer < 100; outer++ {
+		//Quadratic approximation around current coefficients
+		for i := 0; i < numData; i++ {
+			eta[i] = intercept
+			for j := 0; j < numColumns; j++ {
+				eta[i] += x[i][j] * beta[j]
+			}
+
+			p := 1.0 / (1.0 + math.Exp(-eta[i]))
+			variance := math.Max(p*(1-p), 1e-5)
+			weights[i] = v[i] * variance
+			working[i] = eta[i] + (y[i]-p)/variance
+			residual[i] = working[i] - eta[i]
+		}
+
+		maxChange := 0.0
+		for inner := 0; inner < 100; inner++ {
+			innerChange := 0.0
+
+			//Intercept is not penalized
+			sumWeights, sumResidual := 0.0, 0.0
+			for i := 0; i < numData; i++ {
+				sumWeights += weights[i]
+				sumResidual += weights[i] * residual[i]
+			}
+			delta := sumResidual / sumWeights
+			intercept += delta
+			for i := 0; i < numData; i++ {
+				residual[i] -= delta
+			}
+			innerChange = math.Max(innerChange, math.Abs(delta))
+
+			for j := 0; j < numColumns; j++ {
+				numerator, denominator := 0.0, 0.0
+				for i := 0; i < numData; i++ {
+					numerator += weights[i] * x[i][j] * (residual[i] + x[i][j]*beta[j])
+					denominator += weights[i] * x[i][j] * x[i][j]
+				}
+				numerator /= totalWeight
+				denominator /= totalWeight
+
+				newBeta := 0.0
+				if denominator > 0 {
+					newBeta = softThreshold(numerator, lambda) / denominator
+				}
+
+				change := newBeta - beta[j]
+				if change != 0 {
+					for i := 0; i < numData; i++ {
+						residual[i] -= x[i][j] * change
+					}
+					beta[j] = newBeta
+				}
+				innerChange = math.Max(innerChange, math.Abs(change))
+			}
+
+			maxChange = math.Max(maxChange, innerChange)
+			if innerChange < 1e-6 {
+				break
+			}
+		}
+
+		if maxChange < 1e-6 {
+			break
+		}
+	}
+
+	return intercept
+}
+
+func softThreshold(val float64, threshold float64) float64 {
+	if val > threshold {
+		return val - threshold
+	} else if val < -threshold {
+		return val + threshold
+	}
+
+	return 0.0
+}
+
+//Table of coefficients along the path, one row per lambda
+func (l *LassoPath) StringHTML() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>LASSO Regularization Path (standardized coefficients)</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Lambda</td>")
+	for _, name := range l.Names {
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for i, lambda := range l.Lambdas {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(lambda, 'g', 4, 64))
+		buffer.WriteString("</td>")
+		for _, val := range l.Coefficients[i] {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(val, 'f', 4, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,236 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strconv"
+)
+
+//Missing value handling of the numerical variables
+//
+//A data point marks its missing variables explicitly, the stored value is only a placeholder.
+//Imputed values are computed from the training data and kept with the model so Predict and TestModel
+//fill the same values. Every variable of the design matrix with missing training values also gets an
+//indicator variable (1 when missing) so the model can tell an imputed value from an observed one.
+
+type ImputationMode int
+
+const (
+	NoImputation       ImputationMode = iota //Use the placeholder value as it is, no indicator
+	MeanImputation                           //Mean of the observed training values
+	MedianImputation                         //Median of the observed training values
+	ConstantImputation                       //Fixed value
+)
+
+func (m ImputationMode) String() string {
+	switch m {
+	case MeanImputation:
+		return "Mean Imputation"
+	case MedianImputation:
+		return "Median Imputation"
+	case ConstantImputation:
+		return "Constant Imputation"
+	default:
+		return "None"
+	}
+}
+
+type Imputation struct {
+	Mode       ImputationMode
+	Values     []float64 //Value filled in for each variable
+	Missing    []int     //Number of missing training values of each variable
+	Indicators []int     //Variables with a missing indicator in the design matrix
+}
+
+//Imputation of missing values, constant is only used by constant imputation
+func (r *Regression) SetImputation(mode ImputationMode, constant float64) {
+	r.imputationMode = mode
+	r.imputationConstant = constant
+}
+
+func (d DataPoint) isMissing(index int) bool {
+	return index < len(d.Missing) && d.Missing[index]
+}
+
+//Compute the imputed value of every variable from the training data points
+func (r *Regression) computeImputation() {
+	numVariables := len(r.variableNames)
+
+	imputation := Imputation{Mode: r.imputationMode}
+	imputation.Values = make([]float64, numVariables)
+	imputation.Missing = make([]int, numVariables)
+
+	for j := 0; j < numVariables; j++ {
+		var observed []float64
+		for _, data := range r.dataPoints {
+			if data.isMissing(j) {
+				imputation.Missing[j]++
+			} else {
+				observed = append(observed, data.Variables[j])
+			}
+		}
+
+		value := r.imputationConstant
+		if len(observed) > 0 {
+			switch r.imputationMode {
+			case MeanImputation:
+				value = 0.0
+				for _, val := range observed {
+					value += val
+				}
+				value /= float64(len(observed))
+			case MedianImputation:
+				sort.Float64s(observed)
+				value = quantile(observed, 0.5)
+			}
+		}
+		imputation.Values[j] = value
+
+		if r.imputationMode != NoImputation && imputation.Missing[j] > 0 && r.variableInDesign(j) {
+			imputation.Indicators = append(imputation.Indicators, j)
+		}
+	}
+
+	r.model.Imputation = imputation
+
+	if r.debugMode && r.imputationMode != NoImputation {
+		r.debugContext.Infof("\nImputed values: %v\nMissing values: %v", imputation.Values, imputation.Missing)
+	}
+}
+
+//Whether a term of the design matrix uses the variable, every variable is used without formula
+func (r *Regression) variableInDesign(index int) bool {
+	if r.interceptOnly {
+		return false
+	} else if len(r.terms) == 0 {
+		return true
+	}
+
+	for _, term := range r.terms {
+		for _, variable := range term.Variables {
+			if variable == index {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//Remove the indicators of variables no longer in the design matrix after terms are dropped
+func (r *Regression) pruneIndicators() {
+	var indicators []int
+	for _, index := range r.model.Imputation.Indicators {
+		if r.variableInDesign(index) {
+			indicators = append(indicators, index)
+		}
+	}
+
+	r.model.Imputation.Indicators = indicators
+}
+
+//Return the variables with missing values replaced using the imputation stored in the model
+func (r *Regression) imputeVariables(data DataPoint) []float64 {
+	imputation := r.model.Imputation
+	if imputation.Mode == NoImputation || len(data.Missing) == 0 || len(imputation.Values) != len(data.Variables) {
+		return data.Variables
+	}
+
+	imputed := make([]float64, len(data.Variables))
+	for j, val := range data.Variables {
+		if data.isMissing(j) {
+			imputed[j] = imputation.Values[j]
+		} else {
+			imputed[j] = val
+		}
+	}
+
+	return imputed
+}
+
+//Missing indicator values of the data point
+func (r *Regression) missingIndicators(data DataPoint) []float64 {
+	if r.interceptOnly {
+		return nil
+	}
+
+	indicators := make([]float64, len(r.model.Imputation.Indicators))
+	for i, index := range r.model.Imputation.Indicators {
+		if data.isMissing(index) {
+			indicators[i] = 1.0
+		}
+	}
+
+	return indicators
+}
+
+func (r *Regression) numIndicators() int {
+	if r.interceptOnly {
+		return 0
+	}
+
+	return len(r.model.Imputation.Indicators)
+}
+
+//Missing count, imputed value and indicator of every variable
+func (r *Regression) imputationHTML() string {
+	imputation := r.model.Imputation
+
+	total := 0
+	for _, missing := range imputation.Missing {
+		total += missing
+	}
+	if total == 0 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Missing Values: ")
+	buffer.WriteString(imputation.Mode.String())
+	buffer.WriteString("</h3></div>")
+
+	if imputation.Mode == NoImputation {
+		buffer.WriteString("<div><strong>Warning: Missing values are used as their placeholder value, consider imputing them.</strong></div>")
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Missing</td>")
+	buffer.WriteString("<td>Imputed Value</td>")
+	buffer.WriteString("<td>Indicator</td>")
+	buffer.WriteString("</tr>")
+
+	for j, name := range r.variableNames {
+		if imputation.Missing[j] == 0 {
+			continue
+		}
+
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(imputation.Missing[j]))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if imputation.Mode != NoImputation {
+			buffer.WriteString(strconv.FormatFloat(imputation.Values[j], 'f', 6, 64))
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		for _, index := range imputation.Indicators {
+			if index == j {
+				buffer.WriteString(html.EscapeString(name + " Missing"))
+			}
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,410 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"strconv"
+
+	"github.com/skelterjohn/go.matrix"
+
+	"reta/errors"
+)
+
+//Multinomial logistic regression of more than two observed classes
+//
+//The first class is the reference and every other class k has its own coefficients:
+//
+//	P(class k | x) = exp(x'b[k]) / (1 + Sum over other classes j of exp(x'b[j])), b[reference] = 0
+//
+//exp(b[k]) is the relative risk ratio of being in class k instead of the reference for one unit of the variable.
+//All coefficients are fitted together with Newton-Raphson on the (classes - 1) * (variables + 1) parameters,
+//halving the step while the log likelihood decreases. The data point result is the class index.
+//
+//References:
+// - Hosmer, D. and Lemeshow, S. (2000). Applied Logistic Regression, Chapter 8
+// - Agresti, A. (2002). Categorical Data Analysis, Section 7.1
+
+type ClassModel struct {
+	Name                     string
+	Coefficients             []float64
+	StandardErrors           []float64
+	PValues                  []float64
+	RiskRatios               []float64 //exp(coefficient) against the reference class
+	LowerConfidenceIntervals []float64 //95% interval of the coefficient
+	UpperConfidenceIntervals []float64
+	OriginalCoefficients     []float64 //Coefficients transformed back to the unscaled variables
+	OriginalRiskRatios       []float64
+}
+
+//Names of the observed classes, more than two fits multinomial logistic regression with the first class as reference
+func (r *Regression) SetClassNames(names []string) {
+	r.classNames = names
+}
+
+func (r *Regression) multinomial() bool {
+	return len(r.classNames) > 2
+}
+
+//Fit the coefficients of every class against the reference class with Newton-Raphson
+func (r *Regression) computeMultinomialModel(iteration int) error {
+	numClasses := len(r.classNames)
+	numData := len(r.dataPoints)
+	numColumns := len(r.designNames()) + 1
+
+	//Design rows with the intercept and the class of every data point
+	x := make([][]float64, numData)
+	y := make([]int, numData)
+	w := make([]float64, numData)
+	classWeights := make([]float64, numClasses)
+	for i, data := range r.dataPoints {
+		class := int(data.Result)
+		if float64(class) != data.Result || class < 0 || class >= numClasses {
+			return errors.New("Error: Observed value must be a class index from 0 to " + strconv.Itoa(numClasses-1))
+		}
+
+		x[i] = append([]float64{1.0}, r.designVariables(data)...)
+		y[i] = class
+		w[i] = data.weight()
+		classWeights[class] += w[i]
+	}
+
+	for k, name := range r.classNames {
+		if classWeights[k] == 0 {
+			return errors.New("Error: No player in class '" + name + "' of the training data")
+		}
+	}
+
+	//Start from the class proportions, which is also the null model
+	coefficients := make([]float64, (numClasses-1)*numColumns)
+	for k := 1; k < numClasses; k++ {
+		coefficients[(k-1)*numColumns] = math.Log(classWeights[k] / classWeights[0])
+	}
+
+	logLikelihood, gradient, information, mse := multinomialLikelihood(x, y, w, numClasses, coefficients)
+	totalWeight := 0.0
+	for _, weight := range classWeights {
+		totalWeight += weight
+	}
+	nullLogLikelihood := logLikelihood
+
+	convergence := Convergence{Solver: NewtonRaphsonFitting, Reason: MaxIterationsReached}
+	convergence.Trace = append(convergence.Trace, ConvergenceStep{LogLikelihood: logLikelihood, MSE: mse, GradientNorm: norm(gradient) / totalWeight})
+	for it := 0; it < iteration; it++ {
+		inverse := matrix.Inverse(information)
+		if inverse == nil {
+			convergence.Reason = SingularInformation
+			break
+		}
+
+		//Newton step inv(I)U, halved while the log likelihood decreases
+		step := make([]float64, len(coefficients))
+		for j := range step {
+			for k := range gradient {
+				step[j] += inverse.Get(j, k) * gradient[k]
+			}
+		}
+
+		var candidate, newGradient []float64
+		var newLogLikelihood, newMSE float64
+		var newInformation *matrix.DenseMatrix
+		for halving := 0; halving < 20; halving++ {
+			candidate = make([]float64, len(coefficients))
+			for j := range candidate {
+				candidate[j] = coefficients[j] + step[j]
+			}
+
+			newLogLikelihood, newGradient, newInformation, newMSE = multinomialLikelihood(x, y, w, numClasses, candidate)
+			if newLogLikelihood >= logLikelihood {
+				break
+			}
+			step = scale(step, 0.5)
+		}
+
+		if math.IsNaN(newLogLikelihood) || math.IsInf(newLogLikelihood, 0) {
+			convergence.Reason = Diverged
+			break
+		}
+
+		change := 0.0
+		for j := range step {
+			change = math.Max(change, math.Abs(candidate[j]-coefficients[j]))
+		}
+
+		coefficients, logLikelihood, gradient, information = candidate, newLogLikelihood, newGradient, newInformation
+		convergence.Iterations = it + 1
+		convergence.Trace = append(convergence.Trace, ConvergenceStep{Iteration: it + 1, LogLikelihood: logLikelihood, MSE: newMSE, GradientNorm: norm(gradient) / totalWeight})
+
+		if change < 1e-6 {
+			convergence.Reason = CoefficientsConverged
+			break
+		}
+	}
+
+	if r.debugMode {
+		r.debugContext.Infof("\nMultinomial coefficients: %v\nStop reason: %v", coefficients, convergence.Reason)
+	}
+
+	//Standard errors from the inverse of the information at the estimates
+	covariance := matrix.Inverse(information)
+	r.model.Classes = make([]ClassModel, numClasses-1)
+	for k := 1; k < numClasses; k++ {
+		class := ClassModel{Name: r.classNames[k]}
+		class.Coefficients = make([]float64, numColumns)
+		class.StandardErrors = make([]float64, numColumns)
+		class.PValues = make([]float64, numColumns)
+		class.RiskRatios = make([]float64, numColumns)
+		class.LowerConfidenceIntervals = make([]float64, numColumns)
+		class.UpperConfidenceIntervals = make([]float64, numColumns)
+
+		for j := 0; j < numColumns; j++ {
+			index := (k-1)*numColumns + j
+			b := coefficients[index]
+			se := math.NaN()
+			if covariance != nil {
+				se = math.Sqrt(covariance.Get(index, index))
+			}
+
+			class.Coefficients[j] = b
+			class.StandardErrors[j] = se
+			class.PValues[j] = 2.0 * (1.0 - normalCDF(math.Abs(b/se)))
+			class.RiskRatios[j] = math.Exp(b)
+			class.LowerConfidenceIntervals[j] = b - 1.96*se
+			class.UpperConfidenceIntervals[j] = b + 1.96*se
+		}
+
+		class.OriginalCoefficients = r.originalCoefficients(class.Coefficients)
+		class.OriginalRiskRatios = make([]float64, numColumns)
+		for j, b := range class.OriginalCoefficients {
+			class.OriginalRiskRatios[j] = math.Exp(b)
+		}
+
+		r.model.Classes[k-1] = class
+	}
+
+	r.model.Convergence = convergence
+	r.model.LogLikelihood = logLikelihood
+	r.model.Deviance = -2 * logLikelihood
+	r.model.AIC = r.model.Deviance + 2*float64(len(coefficients))
+	r.model.ChiSquare = 2 * (logLikelihood - nullLogLikelihood)
+	r.model.NullLogLikelihood = nullLogLikelihood
+
+	return nil
+}
+
+//Log likelihood, its gradient, the information matrix and the mean squared error of the class probabilities
+func multinomialLikelihood(x [][]float64, y []int, w []float64, numClasses int, coefficients []float64) (float64, []float64, *matrix.DenseMatrix, float64) {
+	numColumns := len(coefficients) / (numClasses - 1)
+	numParameters := len(coefficients)
+
+	logLikelihood, mse, total := 0.0, 0.0, 0.0
+	gradient := make([]float64, numParameters)
+	information := matrix.Zeros(numParameters, numParameters)
+
+	for i := range x {
+		probabilities := multinomialProbabilities(x[i], numClasses, coefficients)
+
+		clamped := math.Max(probabilities[y[i]], 1e-15)
+		logLikelihood += w[i] * math.Log(clamped)
+		for k, probability := range probabilities {
+			observed := 0.0
+			if k == y[i] {
+				observed = 1.0
+			}
+			mse += w[i] * (probability - observed) * (probability - observed)
+		}
+		total += w[i]
+
+		for k := 1; k < numClasses; k++ {
+			observed := 0.0
+			if k == y[i] {
+				observed = 1.0
+			}
+			for j := 0; j < numColumns; j++ {
+				gradient[(k-1)*numColumns+j] += w[i] * (observed - probabilities[k]) * x[i][j]
+			}
+
+			//Block (k, l) is Sum(w * p[k] * (delta(k, l) - p[l]) * x * x')
+			for l := 1; l < numClasses; l++ {
+				factor := -probabilities[k] * probabilities[l]
+				if k == l {
+					factor += probabilities[k]
+				}
+				factor *= w[i]
+
+				for j := 0; j < numColumns; j++ {
+					row := (k-1)*numColumns + j
+					for m := 0; m < numColumns; m++ {
+						column := (l-1)*numColumns + m
+						information.Set(row, column, information.Get(row, column)+factor*x[i][j]*x[i][m])
+					}
+				}
+			}
+		}
+	}
+
+	if total > 0 {
+		mse /= total
+	}
+
+	return logLikelihood, gradient, information, mse
+}
+
+//Probability of every class from the design row with the intercept
+func multinomialProbabilities(row []float64, numClasses int, coefficients []float64) []float64 {
+	numColumns := len(coefficients) / (numClasses - 1)
+
+	//Subtract the largest score so exp does not overflow
+	scores := make([]float64, numClasses)
+	largest := 0.0
+	for k := 1; k < numClasses; k++ {
+		scores[k] = dot(coefficients[(k-1)*numColumns:k*numColumns], row)
+		largest = math.Max(largest, scores[k])
+	}
+
+	probabilities := make([]float64, numClasses)
+	total := 0.0
+	for k := range scores {
+		probabilities[k] = math.Exp(scores[k] - largest)
+		total += probabilities[k]
+	}
+	for k := range probabilities {
+		probabilities[k] /= total
+	}
+
+	return probabilities
+}
+
+//Probability of every class for the data point
+func (r *Regression) PredictClasses(testData DataPoint) ([]float64, error) {
+	if !r.multinomial() || len(r.model.Classes) == 0 {
+		return nil, errors.New("Error: Multinomial model is not generated yet")
+	}
+
+	row := append([]float64{1.0}, r.designVariables(testData)...)
+
+	var coefficients []float64
+	for _, class := range r.model.Classes {
+		if len(class.Coefficients) != len(row) {
+			return nil, errors.New("Error:Bad dimensions for variables or coefficients in PredictClasses()")
+		}
+		coefficients = append(coefficients, class.Coefficients...)
+	}
+
+	return multinomialProbabilities(row, len(r.classNames), coefficients), nil
+}
+
+//Confusion matrix and metrics of the predicted classes against testing data
+func (r *Regression) EvaluateClasses(testData []DataPoint) (ClassEvaluation, error) {
+	if len(testData) == 0 {
+		return ClassEvaluation{}, errors.New("Error: Need some testing data to evaluate model")
+	}
+	r.unseenLevels = 0
+
+	probabilities := make([][]float64, len(testData))
+	observed := make([]int, len(testData))
+	for i, data := range testData {
+		predicted, err := r.PredictClasses(data)
+		if err != nil {
+			return ClassEvaluation{}, err
+		}
+		probabilities[i] = predicted
+		observed[i] = int(data.Result)
+	}
+
+	return evaluateClasses(r.classNames, probabilities, observed), nil
+}
+
+//Coefficient table of every class against the reference class
+func (r *Regression) multinomialHTML() string {
+	var buffer bytes.Buffer
+
+	//Show original-scale coefficients next to the standardized ones
+	scaled := r.model.Scaling.Mode != NoScaling
+	if scaled {
+		buffer.WriteString("<div>Variables scaled using ")
+		buffer.WriteString(r.model.Scaling.Mode.String())
+		buffer.WriteString(", coefficients and risk ratios are per scaled unit</div>")
+	}
+
+	buffer.WriteString("<div>Reference class: ")
+	buffer.WriteString(html.EscapeString(r.classNames[0]))
+	buffer.WriteString(", risk ratio is exp(coefficient) of being in the class instead of the reference</div>")
+
+	names := append([]string{"Intercept"}, r.designNames()...)
+	for _, class := range r.model.Classes {
+		buffer.WriteString("<br/><div><h3>")
+		buffer.WriteString(html.EscapeString(class.Name))
+		buffer.WriteString(" vs ")
+		buffer.WriteString(html.EscapeString(r.classNames[0]))
+		buffer.WriteString("</h3></div>")
+
+		buffer.WriteString("<table>")
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>Name</td>")
+		buffer.WriteString("<td>Coefficient</td>")
+		buffer.WriteString("<td>Risk Ratio</td>")
+		buffer.WriteString("<td>Std. Error</td>")
+		buffer.WriteString("<td>p-Value</td>")
+		buffer.WriteString("<td>Lower Confidence</td>")
+		buffer.WriteString("<td>Upper Confidence</td>")
+		if scaled {
+			buffer.WriteString("<td>Original Coefficient</td>")
+			buffer.WriteString("<td>Original Risk Ratio</td>")
+		}
+		buffer.WriteString("</tr>")
+
+		for j, name := range names {
+			buffer.WriteString("<tr>")
+			buffer.WriteString("<td>")
+			buffer.WriteString(html.EscapeString(name))
+			buffer.WriteString("</td>")
+			values := []float64{class.Coefficients[j], class.RiskRatios[j], class.StandardErrors[j], class.PValues[j], class.LowerConfidenceIntervals[j], class.UpperConfidenceIntervals[j]}
+			if scaled {
+				values = append(values, class.OriginalCoefficients[j], class.OriginalRiskRatios[j])
+			}
+			for _, val := range values {
+				buffer.WriteString("<td>")
+				buffer.WriteString(strconv.FormatFloat(val, 'f', 6, 64))
+				buffer.WriteString("</td>")
+			}
+			buffer.WriteString("</tr>")
+		}
+
+		buffer.WriteString("</table>")
+	}
+
+	buffer.WriteString(r.convergenceHTML())
+	buffer.WriteString(r.imputationHTML())
+	buffer.WriteString(r.outliersHTML())
+	buffer.WriteString(r.collinearityHTML())
+
+	//Likelihood ratio test against the class proportions
+	df := len(r.model.Classes) * (len(names) - 1)
+	pseudoR2 := 0.0
+	if r.model.NullLogLikelihood != 0 {
+		pseudoR2 = 1.0 - r.model.LogLikelihood/r.model.NullLogLikelihood
+	}
+
+	buffer.WriteString("<div>Log Likelihood: ")
+	buffer.WriteString(strconv.FormatFloat(r.model.LogLikelihood, 'f', 6, 64))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>-2 * Log Likelihood (Deviance): ")
+	buffer.WriteString(strconv.FormatFloat(r.model.Deviance, 'f', 6, 64))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Akaike Information Criterion (AIC): ")
+	buffer.WriteString(strconv.FormatFloat(r.model.AIC, 'f', 6, 64))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Likelihood Ratio Chi-Square: ")
+	buffer.WriteString(strconv.FormatFloat(r.model.ChiSquare, 'f', 6, 64))
+	buffer.WriteString(" on ")
+	buffer.WriteString(strconv.Itoa(df))
+	buffer.WriteString(" df, p-Value ")
+	buffer.WriteString(strconv.FormatFloat(chiSquarePValue(r.model.ChiSquare, df), 'f', 6, 64))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>McFadden Pseudo R-Square: ")
+	buffer.WriteString(strconv.FormatFloat(pseudoR2, 'f', 4, 64))
+	buffer.WriteString("</div>")
+
+	return buffer.String()
+}
@@ -0,0 +1,148 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"strconv"
+)
+
+//Gaussian naive Bayes
+//
+//Every feature is assumed normally distributed and independent of the others within each class:
+//P(retained | x) is proportional to P(retained) * Product(N(x[j]; mean[retained][j], variance[retained][j])).
+//A small fraction of the largest variance is added to every variance so constant features stay finite.
+
+type NaiveBayes struct {
+	featureEncoder
+
+	priors    [2]float64   //Not retained, retained
+	means     [2][]float64 //Mean of each feature in each class
+	variances [2][]float64
+}
+
+func (b *NaiveBayes) Fit(trainingData []DataPoint) error {
+	err := b.fitFeatures(trainingData)
+	if err != nil {
+		return err
+	}
+
+	numFeatures := len(b.featureNames())
+
+	var totals [2]float64
+	for c := 0; c < 2; c++ {
+		b.means[c] = make([]float64, numFeatures)
+		b.variances[c] = make([]float64, numFeatures)
+	}
+
+	encoded := make([][]float64, len(trainingData))
+	for i, data := range trainingData {
+		encoded[i] = b.encode(data)
+
+		c := int(data.Result)
+		totals[c] += data.weight()
+		for j, val := range encoded[i] {
+			b.means[c][j] += data.weight() * val
+		}
+	}
+
+	for c := 0; c < 2; c++ {
+		for j := 0; j < numFeatures; j++ {
+			if totals[c] > 0 {
+				b.means[c][j] /= totals[c]
+			}
+		}
+	}
+
+	largest := 0.0
+	for i, data := range trainingData {
+		c := int(data.Result)
+		for j, val := range encoded[i] {
+			b.variances[c][j] += data.weight() * (val - b.means[c][j]) * (val - b.means[c][j])
+		}
+	}
+	for c := 0; c < 2; c++ {
+		for j := 0; j < numFeatures; j++ {
+			if totals[c] > 0 {
+				b.variances[c][j] /= totals[c]
+			}
+			largest = math.Max(largest, b.variances[c][j])
+		}
+	}
+
+	smoothing := 1e-9 * math.Max(largest, 1.0)
+	for c := 0; c < 2; c++ {
+		for j := 0; j < numFeatures; j++ {
+			b.variances[c][j] += smoothing
+		}
+	}
+
+	for c := 0; c < 2; c++ {
+		b.priors[c] = totals[c] / (totals[0] + totals[1])
+	}
+
+	return nil
+}
+
+func (b *NaiveBayes) PredictProba(data DataPoint) (float64, error) {
+	features := b.encode(data)
+
+	//Log of the joint probability of each class
+	var logJoint [2]float64
+	for c := 0; c < 2; c++ {
+		if b.priors[c] == 0 {
+			logJoint[c] = math.Inf(-1)
+			continue
+		}
+
+		logJoint[c] = math.Log(b.priors[c])
+		for j, val := range features {
+			variance := b.variances[c][j]
+			logJoint[c] -= 0.5*math.Log(2.0*math.Pi*variance) + (val-b.means[c][j])*(val-b.means[c][j])/(2.0*variance)
+		}
+	}
+
+	if math.IsInf(logJoint[1], -1) {
+		return 0.0, nil
+	}
+
+	//P(retained | x) = 1 / (1 + exp(logJoint[0] - logJoint[1]))
+	return 1.0 / (1.0 + math.Exp(logJoint[0]-logJoint[1])), nil
+}
+
+func (b *NaiveBayes) Describe() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<div>Prior probability of retention: ")
+	buffer.WriteString(strconv.FormatFloat(b.priors[1], 'f', 4, 64))
+	buffer.WriteString("</div>")
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Mean (Not Retained)</td>")
+	buffer.WriteString("<td>Std. Deviation (Not Retained)</td>")
+	buffer.WriteString("<td>Mean (Retained)</td>")
+	buffer.WriteString("<td>Std. Deviation (Retained)</td>")
+	buffer.WriteString("</tr>")
+
+	for j, name := range b.featureNames() {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		for c := 0; c < 2; c++ {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(b.means[c][j], 'f', 4, 64))
+			buffer.WriteString("</td>")
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(math.Sqrt(b.variances[c][j]), 'f', 4, 64))
+			buffer.WriteString("</td>")
+		}
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
@@ -0,0 +1,302 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+)
+
+//Outlier detection and treatment of the numerical variables
+//
+//Bounds are computed from the observed training values of every variable, variables with values outside
+//the bounds are treated and the treatment is kept with the model so Predict and TestModel apply the same one.
+//Treatment is applied after imputation and before scaling.
+//
+// - IQR: outside [Q1 - 1.5 * IQR, Q3 + 1.5 * IQR]
+// - Z-score: more than 3 standard deviations from the mean
+
+type OutlierDetection int
+
+const (
+	NoOutlierDetection OutlierDetection = iota
+	IQROutliers
+	ZScoreOutliers
+)
+
+func (d OutlierDetection) String() string {
+	switch d {
+	case IQROutliers:
+		return "Interquartile Range (1.5 IQR)"
+	case ZScoreOutliers:
+		return "Z-Score (3 standard deviations)"
+	default:
+		return "None"
+	}
+}
+
+type OutlierTreatment int
+
+const (
+	ReportOutliers       OutlierTreatment = iota //Only show them
+	ClipOutliers                                 //Clip to the detection bounds
+	WinsorizeOutliers                            //Replace values beyond the 5th and 95th percentile with the percentile
+	LogTransformOutliers                         //ln(1 + x - shift) where shift is the minimum when it is negative
+)
+
+func (t OutlierTreatment) String() string {
+	switch t {
+	case ClipOutliers:
+		return "Clipping"
+	case WinsorizeOutliers:
+		return "Winsorizing (5% - 95%)"
+	case LogTransformOutliers:
+		return "Log Transform"
+	default:
+		return "Report Only"
+	}
+}
+
+type VariableSummary struct {
+	Mean              float64
+	StandardDeviation float64
+	Min               float64
+	Max               float64
+}
+
+type Outliers struct {
+	Detection   OutlierDetection
+	Treatment   OutlierTreatment
+	Lower       []float64 //Detection bounds of each variable
+	Upper       []float64
+	Counts      []int  //Number of outliers in the training data
+	Transformed []bool //Variables where the treatment is applied
+	ClipLower   []float64
+	ClipUpper   []float64
+	Shifts      []float64
+	Before      []VariableSummary
+	After       []VariableSummary
+}
+
+//Outlier detection and the treatment of the variables having them
+func (r *Regression) SetOutlierHandling(detection OutlierDetection, treatment OutlierTreatment) {
+	r.outlierDetection = detection
+	r.outlierTreatment = treatment
+}
+
+//Detect outliers of every variable from the training data points and prepare their treatment
+func (r *Regression) computeOutliers() {
+	numVariables := len(r.variableNames)
+
+	outliers := Outliers{Detection: r.outlierDetection, Treatment: r.outlierTreatment}
+	if r.outlierDetection == NoOutlierDetection {
+		r.model.Outliers = outliers
+		return
+	}
+
+	outliers.Lower = make([]float64, numVariables)
+	outliers.Upper = make([]float64, numVariables)
+	outliers.Counts = make([]int, numVariables)
+	outliers.Transformed = make([]bool, numVariables)
+	outliers.ClipLower = make([]float64, numVariables)
+	outliers.ClipUpper = make([]float64, numVariables)
+	outliers.Shifts = make([]float64, numVariables)
+
+	imputed := make([][]float64, len(r.dataPoints))
+	for i, data := range r.dataPoints {
+		imputed[i] = r.imputeVariables(data)
+	}
+
+	for j := 0; j < numVariables; j++ {
+		var observed []float64
+		for i, data := range r.dataPoints {
+			if !data.isMissing(j) {
+				observed = append(observed, imputed[i][j])
+			}
+		}
+		if len(observed) == 0 {
+			outliers.Lower[j], outliers.Upper[j] = math.Inf(-1), math.Inf(1)
+			continue
+		}
+		sort.Float64s(observed)
+
+		if r.outlierDetection == IQROutliers {
+			q1 := quantile(observed, 0.25)
+			q3 := quantile(observed, 0.75)
+			outliers.Lower[j] = q1 - 1.5*(q3-q1)
+			outliers.Upper[j] = q3 + 1.5*(q3-q1)
+		} else {
+			summary := summarizeValues(observed)
+			outliers.Lower[j] = summary.Mean - 3.0*summary.StandardDeviation
+			outliers.Upper[j] = summary.Mean + 3.0*summary.StandardDeviation
+		}
+
+		for _, val := range observed {
+			if val < outliers.Lower[j] || val > outliers.Upper[j] {
+				outliers.Counts[j]++
+			}
+		}
+
+		outliers.Transformed[j] = outliers.Counts[j] > 0 && r.outlierTreatment != ReportOutliers
+
+		switch r.outlierTreatment {
+		case ClipOutliers:
+			outliers.ClipLower[j] = outliers.Lower[j]
+			outliers.ClipUpper[j] = outliers.Upper[j]
+		case WinsorizeOutliers:
+			outliers.ClipLower[j] = quantile(observed, 0.05)
+			outliers.ClipUpper[j] = quantile(observed, 0.95)
+		case LogTransformOutliers:
+			outliers.Shifts[j] = math.Min(0.0, observed[0])
+		}
+	}
+
+	//Summary of every variable before and after the treatment
+	r.model.Outliers = outliers
+	transformed := make([][]float64, len(imputed))
+	for i := range imputed {
+		transformed[i] = r.transformVariables(imputed[i])
+	}
+
+	r.model.Outliers.Before = make([]VariableSummary, numVariables)
+	r.model.Outliers.After = make([]VariableSummary, numVariables)
+	for j := 0; j < numVariables; j++ {
+		before := make([]float64, len(imputed))
+		after := make([]float64, len(imputed))
+		for i := range imputed {
+			before[i] = imputed[i][j]
+			after[i] = transformed[i][j]
+		}
+
+		r.model.Outliers.Before[j] = summarizeValues(before)
+		r.model.Outliers.After[j] = summarizeValues(after)
+	}
+
+	if r.debugMode {
+		r.debugContext.Infof("\nOutlier bounds: %v - %v\nOutliers: %v", outliers.Lower, outliers.Upper, outliers.Counts)
+	}
+}
+
+//Return the variables with the outlier treatment stored in the model
+func (r *Regression) transformVariables(variables []float64) []float64 {
+	outliers := r.model.Outliers
+	if len(outliers.Transformed) != len(variables) {
+		return variables
+	}
+
+	transformed := make([]float64, len(variables))
+	for j, val := range variables {
+		if outliers.Transformed[j] {
+			switch outliers.Treatment {
+			case ClipOutliers, WinsorizeOutliers:
+				val = math.Max(outliers.ClipLower[j], math.Min(outliers.ClipUpper[j], val))
+			case LogTransformOutliers:
+				val = math.Log1p(math.Max(0.0, val-outliers.Shifts[j]))
+			}
+		}
+		transformed[j] = val
+	}
+
+	return transformed
+}
+
+//Variables after imputation and outlier treatment, before scaling
+func (r *Regression) preprocessVariables(data DataPoint) []float64 {
+	return r.transformVariables(r.imputeVariables(data))
+}
+
+func summarizeValues(values []float64) VariableSummary {
+	summary := VariableSummary{Min: math.Inf(1), Max: math.Inf(-1)}
+	if len(values) == 0 {
+		return summary
+	}
+
+	for _, val := range values {
+		summary.Mean += val
+		summary.Min = math.Min(summary.Min, val)
+		summary.Max = math.Max(summary.Max, val)
+	}
+	summary.Mean /= float64(len(values))
+
+	for _, val := range values {
+		summary.StandardDeviation += (val - summary.Mean) * (val - summary.Mean)
+	}
+	if len(values) > 1 {
+		summary.StandardDeviation = math.Sqrt(summary.StandardDeviation / float64(len(values)-1))
+	} else {
+		summary.StandardDeviation = 0.0
+	}
+
+	return summary
+}
+
+//Bounds, outlier count and before/after summary of every variable
+func (r *Regression) outliersHTML() string {
+	outliers := r.model.Outliers
+	if outliers.Detection == NoOutlierDetection || len(outliers.Counts) == 0 {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Outliers: ")
+	buffer.WriteString(outliers.Detection.String())
+	buffer.WriteString(" with ")
+	buffer.WriteString(outliers.Treatment.String())
+	buffer.WriteString("</h3></div>")
+
+	if outliers.Treatment == LogTransformOutliers {
+		buffer.WriteString("<div>Coefficients of log transformed variables are per ln(1 + x) unit</div>")
+	}
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Lower Bound</td>")
+	buffer.WriteString("<td>Upper Bound</td>")
+	buffer.WriteString("<td>Outliers</td>")
+	buffer.WriteString("<td>Treated</td>")
+	buffer.WriteString("<td>Mean (Before / After)</td>")
+	buffer.WriteString("<td>Std. Deviation (Before / After)</td>")
+	buffer.WriteString("<td>Min (Before / After)</td>")
+	buffer.WriteString("<td>Max (Before / After)</td>")
+	buffer.WriteString("</tr>")
+
+	for j, name := range r.variableNames {
+		before, after := outliers.Before[j], outliers.After[j]
+
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(outliers.Lower[j], 'f', 4, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(outliers.Upper[j], 'f', 4, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(outliers.Counts[j]))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if outliers.Transformed[j] {
+			buffer.WriteString("Yes")
+		}
+		buffer.WriteString("</td>")
+
+		for _, pair := range [][2]float64{{before.Mean, after.Mean}, {before.StandardDeviation, after.StandardDeviation}, {before.Min, after.Min}, {before.Max, after.Max}} {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(pair[0], 'f', 4, 64))
+			buffer.WriteString(" / ")
+			buffer.WriteString(strconv.FormatFloat(pair[1], 'f', 4, 64))
+			buffer.WriteString("</td>")
+		}
+
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
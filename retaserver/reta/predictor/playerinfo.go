@@ -1,16 +1,23 @@
 package predictor
 
 import (
+	"bytes"
+	"html"
+	"sort"
 	"strconv"
 	"time"
 
 	"appengine"
 
+	"reta/analytics"
 	"reta/db"
 )
 
 type PlayerInfo struct {
 	Name             string
+	Version          string
+	FirstDate        time.Time
+	LastDate         time.Time
 	TutorialMomentum float64
 	LevelMomentum    float64
 	GameplayConsumed int
@@ -18,6 +25,15 @@ type PlayerInfo struct {
 	Progression      float64
 	Level            int
 	Day1Retention    bool
+	Actions          map[string]int //Number of events and timed events of each action
+	Segment          string         //Segment found by k-means, empty without segmentation
+	SessionCount     int            //Sessions of the first day, later sessions would tell the retention
+	SessionLength    float64        //Mean session length of the first day in minutes
+	SessionInterval  float64        //Mean time between sessions of the first day in minutes
+
+	MissingTutorial      bool //No "Tutorial Duration" event, TutorialMomentum is only a placeholder
+	MissingLevelDuration bool //No "Level Duration" event, LevelMomentum is only a placeholder
+	MissingSessionGap    bool //Only one session on the first day, SessionInterval is only a placeholder
 }
 
 func GetPlayerInformation(c appengine.Context, begin time.Time, end time.Time, infos *[]PlayerInfo) (int, error) {
@@ -35,6 +51,11 @@ func GetPlayerInformation(c appengine.Context, begin time.Time, end time.Time, i
 		return 0, err
 	}
 
+	return PlayersInformation(c, end, eventsData, timedeventsData, infos), nil
+}
+
+//Information of every player from events already read, returns the number retained
+func PlayersInformation(c appengine.Context, end time.Time, eventsData []db.Event, timedeventsData []db.TimedEvent, infos *[]PlayerInfo) int {
 	//Create result array
 	var playerinfos []PlayerInfo
 	playerlen := len(playerinfos)
@@ -61,7 +82,7 @@ func GetPlayerInformation(c appengine.Context, begin time.Time, end time.Time, i
 
 			//Add if still in region
 			if duration.Hours() >= 0 {
-				info := PlayerInfo{Name: eventsData[i].Player}
+				info := PlayerInfo{Name: eventsData[i].Player, Version: eventsData[i].Version}
 				playerinfos = append(playerinfos, info)
 				playerlen++
 			}
@@ -72,92 +93,224 @@ func GetPlayerInformation(c appengine.Context, begin time.Time, end time.Time, i
 	playerlen = len(playerinfos)
 	//c.Debugf("Total Player: %v\n", playerlen)
 	for i := 0; i < playerlen; i++ {
-		//Prepare data
-		social := 0
-		gameplay := 0
-		progression := 0.0
-
-		var first, last time.Time
-		assigned := false
-
-		length := len(eventsData)
-		for j := 0; j < length; j++ {
-			if eventsData[j].Player == playerinfos[i].Name {
-				if eventsData[j].Action == "Game Feature Consumed" { //Save gameplay feature when consumed
-					gameplay++
-				} else if eventsData[j].Action == "Social Feature Consumed" { //Save social feature when consumed
-					social++
-				} else if eventsData[j].Action == "Game Progression" { //Save gameplay progression
-					//Increase progression
-					paramlen := len(eventsData[j].Parameters)
-					for k := 0; k < paramlen; k++ {
-						if eventsData[j].Parameters[k].Key == "Increase" {
-							progress, _ := strconv.ParseFloat(eventsData[j].Parameters[k].Value, 64)
-							progression += progress
-						}
+		//Compute features
+		computePlayerInfo(&playerinfos[i], eventsData, timedeventsData)
+		if playerinfos[i].Day1Retention {
+			retented += 1
+		}
+
+		c.Debugf("Player:\n%+v\n", playerinfos[i])
+		c.Debugf("Retented:\n%v\n", playerinfos[i].Day1Retention)
+	}
+
+	*infos = playerinfos
+
+	return retented
+}
+
+//Player information from every event of one player, false when the player has no event
+func PlayerInformation(player string, eventsData []db.Event, timedeventsData []db.TimedEvent) (PlayerInfo, bool) {
+	if len(eventsData) == 0 {
+		return PlayerInfo{}, false
+	}
+
+	//Events are ordered by date, version is the one of the first event
+	info := PlayerInfo{Name: player, Version: eventsData[0].Version}
+	computePlayerInfo(&info, eventsData, timedeventsData)
+
+	return info, true
+}
+
+//Player variables computed from the events or timed events of each action
+var actionVariables = map[string][]string{
+	"Game Feature Consumed":   {"Gameplay Consumed"},
+	"Social Feature Consumed": {"Social Activity"},
+	"Game Progression":        {"Progression", "Level"},
+	"Tutorial Duration":       {"Tutorial Momentum"},
+	"Level Duration":          {"Level Momentum"},
+}
+
+//Compute the features and retention of the player from its events, its name and version must be set
+func computePlayerInfo(info *PlayerInfo, eventsData []db.Event, timedeventsData []db.TimedEvent) {
+	//Prepare data
+	social := 0
+	gameplay := 0
+	progression := 0.0
+
+	var first, last time.Time
+	assigned := false
+	actions := make(map[string]int)
+
+	length := len(eventsData)
+	for j := 0; j < length; j++ {
+		if eventsData[j].Player == info.Name {
+			actions[eventsData[j].Action]++
+
+			if eventsData[j].Action == "Game Feature Consumed" { //Save gameplay feature when consumed
+				gameplay++
+			} else if eventsData[j].Action == "Social Feature Consumed" { //Save social feature when consumed
+				social++
+			} else if eventsData[j].Action == "Game Progression" { //Save gameplay progression
+				//Increase progression
+				paramlen := len(eventsData[j].Parameters)
+				for k := 0; k < paramlen; k++ {
+					if eventsData[j].Parameters[k].Key == "Increase" {
+						progress, _ := strconv.ParseFloat(eventsData[j].Parameters[k].Value, 64)
+						progression += progress
 					}
 				}
+			}
 
-				date := eventsData[j].Date
-				if !assigned {
+			date := eventsData[j].Date
+			if !assigned {
+				first = date
+				last = date
+				assigned = true
+			} else {
+				//Min days as the first
+				if first.Sub(date).Hours() >= 0 {
 					first = date
-					last = date
-					assigned = true
-				} else {
-					//Min days as the first
-					if first.Sub(date).Hours() >= 0 {
-						first = date
-					}
+				}
 
-					//Max days as the last
-					if last.Sub(date).Hours() <= 0 {
-						last = date
-					}
+				//Max days as the last
+				if last.Sub(date).Hours() <= 0 {
+					last = date
 				}
 			}
 		}
+	}
 
-		//Save data
-		playerinfos[i].GameplayConsumed = gameplay
-		playerinfos[i].SocialActivities = social
-		playerinfos[i].Progression = progression
-		playerinfos[i].Level = int(progression) / 5
-
-		//Is retented?
-		tomorrow := first.AddDate(0, 0, 1)
-		duration := last.Sub(tomorrow)
-		if duration.Hours() >= 0 {
-			playerinfos[i].Day1Retention = true
-			retented += 1
-		}
+	//Save data
+	info.GameplayConsumed = gameplay
+	info.SocialActivities = social
+	info.Progression = progression
+	info.Level = int(progression) / 5
 
-		//Prepare data for Level Momentum
-		level := 1
-		levelduration := 0.0
-
-		length = len(timedeventsData)
-		for j := 0; j < length; j++ {
-			//Get player timed event
-			if timedeventsData[j].Info.Player == playerinfos[i].Name {
-				//Save Tutorial Momentum in Minutes
-				if timedeventsData[j].Info.Action == "Tutorial Duration" {
-					playerinfos[i].TutorialMomentum = timedeventsData[j].Duration.Minutes()
-				} else if timedeventsData[j].Info.Action == "Level Duration" {
-					level++
-					levelduration += timedeventsData[i].Duration.Minutes()
-				}
+	info.FirstDate = first
+	info.LastDate = last
+
+	//Is retented?
+	tomorrow := first.AddDate(0, 0, 1)
+	duration := last.Sub(tomorrow)
+	if duration.Hours() >= 0 {
+		info.Day1Retention = true
+	}
+
+	//Prepare data for Level Momentum, mean duration of the "Level Duration" events
+	level := 0
+	levelduration := 0.0
+	tutorial := false
+
+	length = len(timedeventsData)
+	for j := 0; j < length; j++ {
+		//Get player timed event
+		if timedeventsData[j].Info.Player == info.Name {
+			actions[timedeventsData[j].Info.Action]++
+
+			//Save Tutorial Momentum in Minutes
+			if timedeventsData[j].Info.Action == "Tutorial Duration" {
+				info.TutorialMomentum = timedeventsData[j].Duration.Minutes()
+				tutorial = true
+			} else if timedeventsData[j].Info.Action == "Level Duration" {
+				//Duration of the matched timed event of the player
+				level++
+				levelduration += timedeventsData[j].Duration.Minutes()
 			}
 		}
+	}
 
-		//Save Level Momentum in Minutes
-		playerinfos[i].LevelMomentum = levelduration / float64(level)
-		//playerinfos[i].Level = level
+	//Save Level Momentum in Minutes
+	if level > 0 {
+		info.LevelMomentum = levelduration / float64(level)
+	}
+	//info.Level = level
 
-		c.Debugf("Player:\n%+v\n", playerinfos[i])
-		c.Debugf("Retented:\n%v\n", playerinfos[i].Day1Retention)
+	//Save session features in Minutes, only from the events before retention is decided
+	var firstEvents []db.Event
+	for _, event := range eventsData {
+		if event.Player == info.Name && event.Date.Before(tomorrow) {
+			firstEvents = append(firstEvents, event)
+		}
+	}
+	var firstTimedEvents []db.TimedEvent
+	for _, timedevent := range timedeventsData {
+		if timedevent.Info.Player == info.Name && timedevent.Info.Date.Before(tomorrow) {
+			firstTimedEvents = append(firstTimedEvents, timedevent)
+		}
 	}
 
-	*infos = playerinfos
+	sessions := analytics.Sessionize(firstEvents, firstTimedEvents, analytics.SessionGap)
+	sessionCount, sessionLength, sessionInterval := analytics.SessionStatistics(sessions)
+	info.SessionCount = sessionCount
+	info.SessionLength = sessionLength
+	info.SessionInterval = sessionInterval
+
+	//Without the events the momentum is unknown, not zero
+	info.MissingTutorial = !tutorial
+	info.MissingLevelDuration = level == 0
+	info.MissingSessionGap = sessionCount < 2
+	info.Actions = actions
+}
+
+//Features of the player as used by the models, unknown values are shown empty
+func (info PlayerInfo) StringHTML() string {
+	var buffer bytes.Buffer
+
+	data := playerDataPoint(info, playerCategoricalNames)
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Feature</td>")
+	buffer.WriteString("<td>Value</td>")
+	buffer.WriteString("</tr>")
+	for i, name := range playerVariableNames {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(name)
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if data.Missing == nil || !data.Missing[i] {
+			buffer.WriteString(strconv.FormatFloat(data.Variables[i], 'f', 2, 64))
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+	for i, name := range playerCategoricalNames {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(name)
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(data.Categories[i]))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+	buffer.WriteString("</table>")
+
+	//Events and timed events of each action
+	var actions []string
+	for action := range info.Actions {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+
+	buffer.WriteString("<br/><div><h3>Actions</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Action</td>")
+	buffer.WriteString("<td>Events</td>")
+	buffer.WriteString("</tr>")
+	for _, action := range actions {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(action))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(info.Actions[action]))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+	buffer.WriteString("</table>")
 
-	return retented, nil
+	return buffer.String()
 }
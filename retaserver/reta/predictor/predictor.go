@@ -2,6 +2,7 @@ package predictor
 
 import (
 	"bytes"
+	"html"
 	"math/rand"
 	"net/http"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 
 	"appengine"
 
+	"reta/db"
 	"reta/errors"
 )
 
@@ -18,8 +20,43 @@ type Predictor struct {
 	trainingDatasetPercentage int
 	testingDatasetPercentage  int
 	iteration                 int
+	fittingMode               FittingMode
+	scalingMode               ScalingMode
+	formula                   string
+	validationMode            ValidationMode
+	folds                     int
+	repeats                   int
+	seed                      int64
+	bootstrapSamples          int
+	bootstrapInterval         BootstrapInterval
+	selectionMethod           SelectionMethod
+	selectionCriterion        SelectionCriterion
+	collinearityDrop          bool
+	imbalanceMode             ImbalanceMode
+	imputationMode            ImputationMode
+	imputationConstant        float64
+	outlierDetection          OutlierDetection
+	outlierTreatment          OutlierTreatment
+	method                    ClassificationMethod
+	compareMethods            bool
+	boostingRounds            int
+	learningRate              float64
+	boostingDepth             int
+	stages                    StageLabeller
+	segmentation              bool
+	segmentCount              int
+	scoring                   bool
+	scored                    int
 }
 
+//Player variables used by every classification method
+var playerVariableNames = []string{"Tutorial Momentum", "Level Momentum", "Gameplay Consumed", "Social Activity", "Progression", "Level", "Session Count", "Session Length", "Session Interval"}
+
+//Categorical variables of the players, only used when the formula names them
+var playerCategoricalNames = []string{"App Version"}
+
+const segmentVariableName = "Player Segment"
+
 func (p *Predictor) SetInputDates(begin time.Time, end time.Time) {
 	p.beginDate = begin
 	p.endDate = end
@@ -40,196 +77,731 @@ func (p *Predictor) SetIteration(num int) {
 	p.iteration = num
 }
 
+func (p *Predictor) SetFittingMode(mode FittingMode) {
+	p.fittingMode = mode
+}
+
+func (p *Predictor) SetScalingMode(mode ScalingMode) {
+	p.scalingMode = mode
+}
+
+//Validation technique, folds and repeats are only used by k-fold modes
+func (p *Predictor) SetValidation(mode ValidationMode, folds int, repeats int) {
+	p.validationMode = mode
+	p.folds = folds
+	p.repeats = repeats
+	if p.folds < 2 {
+		p.folds = 5
+	}
+	if p.repeats < 1 {
+		p.repeats = 1
+	}
+}
+
+//Seed of the random shuffle so results can be reproduced, zero means a new seed on every run
+func (p *Predictor) SetSeed(seed int64) {
+	p.seed = seed
+}
+
+//Number of bootstrap samples for confidence intervals, zero to disable
+func (p *Predictor) SetBootstrap(samples int, interval BootstrapInterval) {
+	p.bootstrapSamples = samples
+	p.bootstrapInterval = interval
+}
+
+//Feature selection shown below the model, criterion is only used by stepwise methods
+func (p *Predictor) SetSelection(method SelectionMethod, criterion SelectionCriterion) {
+	p.selectionMethod = method
+	p.selectionCriterion = criterion
+}
+
+//Drop variables with high variance inflation before fitting, otherwise only warn about them
+func (p *Predictor) SetCollinearityDrop(drop bool) {
+	p.collinearityDrop = drop
+}
+
+//Balancing of retained and not retained players in the training data
+func (p *Predictor) SetImbalance(mode ImbalanceMode) {
+	p.imbalanceMode = mode
+}
+
+//Imputation of missing player features, constant is only used by constant imputation
+func (p *Predictor) SetImputation(mode ImputationMode, constant float64) {
+	p.imputationMode = mode
+	p.imputationConstant = constant
+}
+
+//Outlier detection of player features and treatment of the features having them
+func (p *Predictor) SetOutlierHandling(detection OutlierDetection, treatment OutlierTreatment) {
+	p.outlierDetection = detection
+	p.outlierTreatment = treatment
+}
+
+//Classification method of the model shown, the other settings are only used by logistic regression
+func (p *Predictor) SetMethod(method ClassificationMethod) {
+	p.method = method
+}
+
+//Evaluate every classification method on the same folds and show them side by side
+func (p *Predictor) SetComparison(compare bool) {
+	p.compareMethods = compare
+}
+
+//Maximum trees, learning rate and tree depth of gradient boosting, zero means the default
+func (p *Predictor) SetBoosting(rounds int, learningRate float64, depth int) {
+	p.boostingRounds = rounds
+	p.learningRate = learningRate
+	p.boostingDepth = depth
+}
+
+//Predict the churn stage of the labeller with multinomial logistic regression instead of day-1 retention
+func (p *Predictor) SetStages(labeller StageLabeller) {
+	p.stages = labeller
+}
+
+//Segment players with k-means and use the segment as a categorical variable, zero count picks k by silhouette
+func (p *Predictor) SetSegmentation(enabled bool, count int) {
+	p.segmentation = enabled
+	p.segmentCount = count
+}
+
+//Store the churn risk of the players whose retention is still open, only on explicit scoring runs
+func (p *Predictor) SetScoring(enabled bool) {
+	p.scoring = enabled
+}
+
+//Players scored by the last run
+func (p *Predictor) Scored() int {
+	return p.scored
+}
+
+//Model formula of the variables, e.g. "retained ~ tutorial + social * level + poly(progression,2) + app_version"
+//App version is only a categorical variable of the models when the formula names it
+func (p *Predictor) SetFormula(formula string) {
+	p.formula = formula
+}
+
+//Create regression instance with the player variables
+func (p *Predictor) newRegression() (Regression, error) {
+	var regress Regression
+	regress.SetFittingMode(p.fittingMode)
+	regress.SetScalingMode(p.scalingMode)
+	regress.SetCollinearityDrop(p.collinearityDrop)
+	regress.SetImputation(p.imputationMode, p.imputationConstant)
+	regress.SetOutlierHandling(p.outlierDetection, p.outlierTreatment)
+
+	//Init
+	regress.Initialize(len(playerVariableNames))
+
+	//Set variable names
+	regress.SetObservedName("Day 1 Retention")
+	for i, name := range playerVariableNames {
+		regress.SetVariableName(i, name)
+	}
+	for _, name := range p.categoricalNames() {
+		regress.AddCategoricalVariable(name)
+	}
+
+	//Use formula to build interaction and polynomial terms
+	if p.formula != "" {
+		err := regress.SetFormula(p.formula)
+		if err != nil {
+			return regress, err
+		}
+	}
+
+	return regress, nil
+}
+
+//Categorical variables of the models: the ones named in the formula, with the segment when players are segmented
+func (p *Predictor) categoricalNames() []string {
+	var names []string
+	if p.formula != "" {
+		available := playerCategoricalNames
+		if p.segmentation {
+			available = append(append([]string{}, available...), segmentVariableName)
+		}
+
+		//An invalid formula is reported by the regression, which needs every categorical variable to resolve it
+		parsed, err := ParseFormula(p.formula, playerVariableNames, available)
+		if err != nil {
+			names = append(names, playerCategoricalNames...)
+		} else {
+			for _, index := range parsed.Factors {
+				if available[index] != segmentVariableName {
+					names = append(names, available[index])
+				}
+			}
+		}
+	}
+
+	if p.segmentation {
+		names = append(names, segmentVariableName)
+	}
+
+	return names
+}
+
+//Fit k-means on the variables of the training players and set the segment of the training and other players
+func (p *Predictor) segmentPlayers(training []PlayerInfo, others []PlayerInfo, random *rand.Rand) (*KMeans, error) {
+	kmeans := &KMeans{K: p.segmentCount, Random: random}
+	kmeans.SetVariableNames(playerVariableNames)
+
+	err := kmeans.Fit(playerDataPoints(training, nil))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, infos := range [][]PlayerInfo{training, others} {
+		for i := range infos {
+			infos[i].Segment = segmentName(kmeans.Assign(playerDataPoint(infos[i], nil)))
+		}
+	}
+
+	return kmeans, nil
+}
+
+//Convert player info to data point with the given categorical variables
+func playerDataPoint(info PlayerInfo, categoricals []string) DataPoint {
+	//Convert retention to float
+	var retented float64
+	if info.Day1Retention {
+		retented = 1.0
+	} else {
+		retented = 0.0
+	}
+
+	//Convert metric to float
+	tutorialMomentum := info.TutorialMomentum
+	levelMomentum := info.LevelMomentum
+	gameplayConsumed := float64(info.GameplayConsumed)
+	socialActivity := float64(info.SocialActivities)
+	progression := info.Progression
+	level := float64(info.Level)
+	sessionCount := float64(info.SessionCount)
+	sessionLength := info.SessionLength
+	sessionInterval := info.SessionInterval
+
+	//Momentum is unknown without its events, time between sessions without a second session
+	var missing []bool
+	if info.MissingTutorial || info.MissingLevelDuration || info.MissingSessionGap {
+		missing = []bool{info.MissingTutorial, info.MissingLevelDuration, false, false, false, false, false, false, info.MissingSessionGap}
+	}
+
+	categories := make([]string, len(categoricals))
+	for i, name := range categoricals {
+		if name == segmentVariableName {
+			categories[i] = info.Segment
+		} else {
+			categories[i] = info.Version
+		}
+	}
+
+	//Create datapoint
+	return DataPoint{Result: retented, Variables: []float64{tutorialMomentum, levelMomentum, gameplayConsumed, socialActivity, progression, level, sessionCount, sessionLength, sessionInterval}, Categories: categories, Missing: missing}
+	//return DataPoint{Result: retented, Variables: []float64{tutorialMomentum, gameplayConsumed}}
+}
+
+//Players installed within the window before now, their day 1 retention is still open
+const openWindow = 24 * time.Hour
+
+//Score the players whose retention is still open and store their risk, returns the number scored
+func scoreOpenPlayers(c appengine.Context, method ClassificationMethod, model Classifier, kmeans *KMeans, categoricals []string, now time.Time) (int, error) {
+	begin := now.Add(-openWindow)
+
+	var eventsData []db.Event
+	err := db.GetAllEvents(c, begin, now, &eventsData)
+	if err != nil {
+		return 0, err
+	}
+
+	var timedeventsData []db.TimedEvent
+	err = db.GetAllTimedEvents(c, begin, now, &timedeventsData)
+	if err != nil {
+		return 0, err
+	}
+
+	//Events of every player, events are ordered by date
+	var players []string
+	events := make(map[string][]db.Event)
+	for _, event := range eventsData {
+		if _, ok := events[event.Player]; !ok {
+			players = append(players, event.Player)
+		}
+		events[event.Player] = append(events[event.Player], event)
+	}
+	timedevents := make(map[string][]db.TimedEvent)
+	for _, timedevent := range timedeventsData {
+		timedevents[timedevent.Info.Player] = append(timedevents[timedevent.Info.Player], timedevent)
+	}
+
+	//Players with earlier events installed before the window
+	earlier, err := db.GetPlayersBefore(c, players, begin)
+	if err != nil {
+		return 0, err
+	}
+
+	var infos []PlayerInfo
+	for _, player := range players {
+		if earlier[player] {
+			continue
+		}
+
+		info, _ := PlayerInformation(player, events[player], timedevents[player])
+		if kmeans != nil {
+			info.Segment = segmentName(kmeans.Assign(playerDataPoint(info, nil)))
+		}
+		infos = append(infos, info)
+	}
+
+	err = storeRiskScores(c, method, model, infos, categoricals)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(infos), nil
+}
+
+//Score every player with the model and store the scores
+func storeRiskScores(c appengine.Context, method ClassificationMethod, model Classifier, infos []PlayerInfo, categoricals []string) error {
+	scored := time.Now()
+	scores := make([]db.RiskScore, len(infos))
+	for i, info := range infos {
+		retained, err := model.PredictProba(playerDataPoint(info, categoricals))
+		if err != nil {
+			return err
+		}
+
+		scores[i] = db.RiskScore{Player: info.Name, Risk: 1.0 - retained, Method: method.String(), Scored: scored}
+	}
+
+	return db.PutRiskScores(c, scores)
+}
+
+func playerDataPoints(infos []PlayerInfo, categoricals []string) []DataPoint {
+	datapoints := make([]DataPoint, len(infos))
+	for i, info := range infos {
+		datapoints[i] = playerDataPoint(info, categoricals)
+	}
+
+	return datapoints
+}
+
+//Generate logistic regression model from training players, balanced using the imbalance mode
+func (p *Predictor) generateModel(c appengine.Context, infos []PlayerInfo, random *rand.Rand, debug bool) (*Regression, error) {
+	regress, err := p.newRegression()
+	if err != nil {
+		return nil, err
+	}
+
+	if debug {
+		regress.EnableDebugMode(c)
+	}
+
+	//Add training data
+	for _, datapoint := range balanceDataPoints(playerDataPoints(infos, p.categoricalNames()), p.imbalanceMode, random) {
+		err = regress.AddDataPoint(datapoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = regress.GenerateModel(p.iteration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &regress, nil
+}
+
+//Create classifier of the method with the player variables
+func (p *Predictor) newClassifier(method ClassificationMethod, random *rand.Rand) (Classifier, error) {
+	switch method {
+	case DecisionTreeMethod:
+		tree := &DecisionTree{Random: random}
+		tree.SetFeatureNames(playerVariableNames, p.categoricalNames())
+		return tree, nil
+	case RandomForestMethod:
+		forest := &RandomForest{Random: random}
+		forest.SetFeatureNames(playerVariableNames, p.categoricalNames())
+		return forest, nil
+	case NaiveBayesMethod:
+		bayes := &NaiveBayes{}
+		bayes.SetFeatureNames(playerVariableNames, p.categoricalNames())
+		return bayes, nil
+	case GradientBoostingMethod:
+		boosting := &GradientBoosting{NumRounds: p.boostingRounds, LearningRate: p.learningRate, MaxDepth: p.boostingDepth, Random: random}
+		boosting.SetFeatureNames(playerVariableNames, p.categoricalNames())
+		return boosting, nil
+	}
+
+	regress, err := p.newRegression()
+	if err != nil {
+		return nil, err
+	}
+	regress.iteration = p.iteration
+
+	return &regress, nil
+}
+
+//Fit classifier of the method from training players balanced using the imbalance mode, returns the data used
+func (p *Predictor) fitClassifier(method ClassificationMethod, infos []PlayerInfo, random *rand.Rand) (Classifier, []DataPoint, error) {
+	classifier, err := p.newClassifier(method, random)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	training := balanceDataPoints(playerDataPoints(infos, p.categoricalNames()), p.imbalanceMode, random)
+	err = classifier.Fit(training)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return classifier, training, nil
+}
+
+//Evaluate every classification method on the testing data of the same folds
+func (p *Predictor) compareClassifiers(folds []Fold, random *rand.Rand) []MethodComparison {
+	comparisons := make([]MethodComparison, len(classificationMethods))
+	for m, method := range classificationMethods {
+		comparisons[m].Method = method
+
+		for _, fold := range folds {
+			classifier, _, err := p.fitClassifier(method, fold.Training, random)
+			if err != nil {
+				comparisons[m].Err = err
+				break
+			}
+
+			evaluation, err := evaluateClassifier(classifier, playerDataPoints(fold.Testing, p.categoricalNames()))
+			if err != nil {
+				comparisons[m].Err = err
+				break
+			}
+
+			comparisons[m].Evaluations = append(comparisons[m].Evaluations, evaluation)
+		}
+	}
+
+	return comparisons
+}
+
 //1. Get all user data from begin to end dates
-//2. Slice it using percentage
-//3. Use training data to create model using prediction method
-//4. Use testing data to test prediction
-//5. Return model and prediction as HTML
+//2. Slice it into folds using the validation mode
+//3. Use training data of each fold to create model using prediction method
+//4. Use testing data of each fold to test prediction
+//5. Return model and prediction metrics as HTML
 func (p *Predictor) RunPrediction(w http.ResponseWriter, c appengine.Context) string {
 	//Initialize HTML result string
 	var buffer bytes.Buffer
 
+	//Seeded shuffle so the result can be reproduced
+	seed := p.seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	random := rand.New(rand.NewSource(seed))
+
 	//Header
 	buffer.WriteString("<header>")
-	buffer.WriteString("<h2>Logistic Regression Model for Day-1 Retention</h2>")
+	buffer.WriteString("<h2>")
+	if p.stageMode() {
+		buffer.WriteString("Multinomial Logistic Regression Model for Churn Stage</h2>")
+	} else {
+		buffer.WriteString(p.method.String())
+		buffer.WriteString(" Model for Day-1 Retention</h2>")
+	}
 	buffer.WriteString("<span>Model created from ")
 	buffer.WriteString(p.beginDate.String())
 	buffer.WriteString(" to ")
 	buffer.WriteString(p.endDate.String())
+	if p.method == LogisticRegressionMethod && !p.stageMode() {
+		buffer.WriteString(" using ")
+		buffer.WriteString(p.fittingMode.String())
+	}
 	buffer.WriteString("</span></header>")
 
+	//Validation
+	buffer.WriteString("<div>Validation: ")
+	buffer.WriteString(p.validationMode.String())
+	if p.crossValidation() {
+		buffer.WriteString(" (")
+		buffer.WriteString(strconv.Itoa(p.folds))
+		buffer.WriteString(" folds, ")
+		buffer.WriteString(strconv.Itoa(p.repeats))
+		buffer.WriteString(" repeats)")
+	}
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Random Seed: ")
+	buffer.WriteString(strconv.FormatInt(seed, 10))
+	buffer.WriteString("</div>")
+
 	//Get playerinfo
 	var playerinfos []PlayerInfo
 	retented, err := GetPlayerInformation(c, p.beginDate, p.endDate, &playerinfos)
 	if err != nil {
-		return err.Error()
+		return html.EscapeString(err.Error())
 	}
 
 	c.Debugf("Total Retented Player:\n%v\n", retented)
 
-	//Create regression instance
-	var regress Regression
-	regress.EnableDebugMode(c)
-
-	//Init
-	regress.Initialize(6)
-	//regress.Initialize(2)
-
-	//Set variable names
-	regress.SetObservedName("Day 1 Retention")
-	regress.SetVariableName(0, "Tutorial Momentum")
-	regress.SetVariableName(1, "Level Momentum")
-	regress.SetVariableName(2, "Gameplay Consumed")
-	regress.SetVariableName(3, "Social Activity")
-	regress.SetVariableName(4, "Progression")
-	regress.SetVariableName(5, "Level")
-	//regress.SetVariableName(0, "Tutorial Momentum")
-	//regress.SetVariableName(1, "Gameplay Consumed")
+	//Churn stages are where players dropped, so only churned players are labelled
+	if p.stageMode() {
+		playerinfos = churnedPlayers(playerinfos)
+	}
 
 	//Calculate number of data
 	totalDataset := len(playerinfos)
+	c.Debugf("Total Dataset:\n%v\n", totalDataset)
+
+	//Split into folds
+	folds := p.createFolds(playerinfos, random)
+
+	//Segments of every fold are found from its training players only, retention is not used
+	var kmeans *KMeans
+	if p.segmentation {
+		for i := range folds {
+			segments, err := p.segmentPlayers(folds[i].Training, folds[i].Testing, random)
+			if err != nil {
+				return html.EscapeString(err.Error())
+			}
 
-	//Shuffle so we get good result
-	trainingRetented := int(float64(p.trainingDatasetPercentage) / 100.0 * float64(retented))
-	trainingNotRetented := int(float64(p.trainingDatasetPercentage) / 100.0 * float64(totalDataset-retented))
-	testRetented := retented - trainingRetented
-	testNotRetented := (totalDataset - retented) - trainingNotRetented
-
-	c.Debugf("Training Retented:\n%v\n", trainingRetented)
-	c.Debugf("Training Not-Retented:\n%v\n", trainingNotRetented)
-	c.Debugf("Testing Retented:\n%v\n", testRetented)
-	c.Debugf("Testing Not-Retented:\n%v\n", testNotRetented)
-
-	trainingInfos := make([]PlayerInfo, trainingRetented+trainingNotRetented)
-	testInfos := make([]PlayerInfo, testRetented+testNotRetented)
-
-	trainingIndex := 0
-	testIndex := 0
-
-	for i := 0; i < totalDataset; i++ {
-		if playerinfos[i].Day1Retention {
-			if trainingRetented > 0 {
-				trainingInfos[trainingIndex] = playerinfos[i]
-				trainingIndex += 1
-				trainingRetented -= 1
-			} else {
-				testInfos[testIndex] = playerinfos[i]
-				testIndex += 1
-				testRetented -= 1
+			if i == 0 {
+				kmeans = segments
 			}
+		}
+
+		//Segments of the model shown, which is generated from all players with cross validation
+		if p.crossValidation() {
+			kmeans, err = p.segmentPlayers(playerinfos, nil, random)
 		} else {
-			if trainingNotRetented > 0 {
-				trainingInfos[trainingIndex] = playerinfos[i]
-				trainingIndex += 1
-				trainingNotRetented -= 1
-			} else {
-				testInfos[testIndex] = playerinfos[i]
-				testIndex += 1
-				testNotRetented -= 1
+			for i := range playerinfos {
+				playerinfos[i].Segment = segmentName(kmeans.Assign(playerDataPoint(playerinfos[i], nil)))
 			}
 		}
-	}
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
 
-	for i := range trainingInfos {
-		j := rand.Intn(i + 1)
-		trainingInfos[i], trainingInfos[j] = trainingInfos[j], trainingInfos[i]
+		err = storeSegmentation(c, kmeans)
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
 	}
 
-	for i := range testInfos {
-		j := rand.Intn(i + 1)
-		testInfos[i], testInfos[j] = testInfos[j], testInfos[i]
+	//Churn stages have their own model and metrics
+	if p.stageMode() {
+		buffer.WriteString(p.runStagePrediction(c, playerinfos, folds))
+		if kmeans != nil {
+			buffer.WriteString(kmeans.StringHTML(playerDataPoints(playerinfos, nil)))
+		}
+		return buffer.String()
 	}
 
-	trainingDataNum := len(trainingInfos)
-	testDataNum := len(testInfos)
+	//Train and test every fold
+	var regress *Regression
+	var classifier Classifier
+	var training []DataPoint
+	var evaluations []Evaluation
+	unseen := 0
+	notConverged := 0
+	for i, fold := range folds {
+		trainingDataNum := len(fold.Training)
+		testDataNum := len(fold.Testing)
+
+		c.Debugf("Fold %v Training Data:\n%v\n", i+1, trainingDataNum)
+		c.Debugf("Fold %v Testing Data:\n%v\n", i+1, testDataNum)
+
+		if testDataNum == 0 {
+			return "Error: Testing data is empty, add more players or decrease training percentage"
+		}
 
-	c.Debugf("Total Dataset:\n%v\n", totalDataset)
-	c.Debugf("Training Data:\n%v\n", trainingDataNum)
-	c.Debugf("Testing Data:\n%v\n", testDataNum)
+		//Other methods are fitted through the classifier interface
+		if p.method != LogisticRegressionMethod {
+			model, data, err := p.fitClassifier(p.method, fold.Training, random)
+			if err != nil {
+				return html.EscapeString(err.Error())
+			}
+
+			evaluation, err := evaluateClassifier(model, playerDataPoints(fold.Testing, p.categoricalNames()))
+			if err != nil {
+				return html.EscapeString(err.Error())
+			}
+
+			evaluations = append(evaluations, evaluation)
+
+			if i == 0 {
+				classifier = model
+				training = data
+			}
+			continue
+		}
+
+		//Only log the model shown on the page
+		model, err := p.generateModel(c, fold.Training, random, i == 0 && !p.crossValidation())
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		evaluation, err := model.Evaluate(playerDataPoints(fold.Testing, p.categoricalNames()))
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		evaluations = append(evaluations, evaluation)
+		unseen += model.UnseenLevels()
+		if !model.Convergence().Converged() {
+			notConverged++
+		}
+
+		if i == 0 {
+			regress = model
+		}
+	}
 
 	//Dataset
 	buffer.WriteString("<div>Total Dataset: ")
 	buffer.WriteString(strconv.FormatInt(int64(totalDataset), 10))
 	buffer.WriteString("</div>")
-	buffer.WriteString("<div>Training vs Testing: ")
-	buffer.WriteString(strconv.FormatInt(int64(trainingDataNum), 10))
-	buffer.WriteString(" vs ")
-	buffer.WriteString(strconv.FormatInt(int64(testDataNum), 10))
-	buffer.WriteString("</div>")
+	if p.crossValidation() {
+		//Model shown is generated from all players, folds are used for the metrics
+		if p.method != LogisticRegressionMethod {
+			classifier, training, err = p.fitClassifier(p.method, playerinfos, random)
+		} else {
+			regress, err = p.generateModel(c, playerinfos, random, true)
+		}
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		buffer.WriteString("<div>Model generated from all players, metrics from ")
+		buffer.WriteString(strconv.Itoa(len(folds)))
+		buffer.WriteString(" testing folds</div>")
+	} else {
+		buffer.WriteString("<div>Training vs Testing: ")
+		buffer.WriteString(strconv.FormatInt(int64(len(folds[0].Training)), 10))
+		buffer.WriteString(" vs ")
+		buffer.WriteString(strconv.FormatInt(int64(len(folds[0].Testing)), 10))
+		buffer.WriteString("</div>")
+	}
+
+	//Balancing only changes the training data, metrics use the testing data as it is
+	if regress != nil {
+		training = regress.dataPoints
+	}
+	if p.imbalanceMode != NoBalancing {
+		positive, negative := classTotals(training)
+
+		buffer.WriteString("<div>Class Balancing: ")
+		buffer.WriteString(p.imbalanceMode.String())
+		buffer.WriteString(" (training retained vs not retained: ")
+		buffer.WriteString(strconv.FormatFloat(positive, 'f', 1, 64))
+		buffer.WriteString(" vs ")
+		buffer.WriteString(strconv.FormatFloat(negative, 'f', 1, 64))
+		buffer.WriteString(")</div>")
+	}
 	buffer.WriteString("<br/>")
 
-	//Add training data
-	for i := 0; i < trainingDataNum; i++ {
-		//Convert retention to float
-		var retented float64
-		if trainingInfos[i].Day1Retention {
-			retented = 1.0
-		} else {
-			retented = 0.0
+	//Segments used as the player segment variable
+	if kmeans != nil {
+		buffer.WriteString(kmeans.StringHTML(playerDataPoints(playerinfos, nil)))
+		buffer.WriteString("<br/>")
+	}
+
+	//Model of the other methods, bootstrap and selection need the regression coefficients
+	if regress == nil {
+		buffer.WriteString(classifier.Describe())
+
+		if p.bootstrapSamples > 0 || p.selectionMethod != NoSelection {
+			buffer.WriteString("<div>Bootstrap intervals and feature selection are only available for logistic regression</div>")
 		}
+	}
 
-		//Convert metric to float
-		tutorialMomentum := trainingInfos[i].TutorialMomentum
-		levelMomentum := trainingInfos[i].LevelMomentum
-		gameplayConsumed := float64(trainingInfos[i].GameplayConsumed)
-		socialActivity := float64(trainingInfos[i].SocialActivities)
-		progression := trainingInfos[i].Progression
-		level := float64(trainingInfos[i].Level)
+	//Bootstrap intervals, metrics use the testing data of a single split
+	if regress != nil && p.bootstrapSamples > 0 {
+		var testDatapoint []DataPoint
+		if !p.crossValidation() {
+			testDatapoint = playerDataPoints(folds[0].Testing, p.categoricalNames())
+		}
 
-		//Create and add datapoints
-		datapoint := DataPoint{Result: retented, Variables: []float64{tutorialMomentum, levelMomentum, gameplayConsumed, socialActivity, progression, level}}
-		//datapoint := DataPoint{Result: retented, Variables: []float64{tutorialMomentum, gameplayConsumed}}
-		err = regress.AddDataPoint(datapoint)
+		regress.SetBootstrap(p.bootstrapSamples, p.bootstrapInterval, seed)
+		err = regress.Bootstrap(testDatapoint)
 		if err != nil {
-			return err.Error()
+			return html.EscapeString(err.Error())
 		}
 	}
 
-	//Generate logistic regression model
-	err = regress.GenerateModel(p.iteration)
-	if err != nil {
-		return err.Error()
+	//Keep generated model
+	if regress != nil {
+		model := regress.StringHTML()
+		buffer.WriteString(model)
 	}
 
-	//Keep generated model
-	model := regress.StringHTML()
-	buffer.WriteString(model)
-
-	//Add testing data
-	testDatapoint := make([]DataPoint, testDataNum)
-	for i := 0; i < testDataNum; i++ {
-		//Convert retention to float
-		var retented float64
-		if testInfos[i].Day1Retention {
-			retented = 1.0
-		} else {
-			retented = 0.0
+	//Latest risk of the players still open for the player page, players of the model have a known outcome
+	if p.scoring {
+		scorer := classifier
+		if regress != nil {
+			scorer = regress
 		}
 
-		//Convert metric to float
-		tutorialMomentum := testInfos[i].TutorialMomentum
-		levelMomentum := testInfos[i].LevelMomentum
-		gameplayConsumed := float64(testInfos[i].GameplayConsumed)
-		socialActivity := float64(testInfos[i].SocialActivities)
-		progression := testInfos[i].Progression
-		level := float64(testInfos[i].Level)
+		p.scored, err = scoreOpenPlayers(c, p.method, scorer, kmeans, p.categoricalNames(), time.Now())
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
 
-		//Create and add datapoints
-		datapoint := DataPoint{Result: retented, Variables: []float64{tutorialMomentum, levelMomentum, gameplayConsumed, socialActivity, progression, level}}
-		//datapoint := DataPoint{Result: retented, Variables: []float64{tutorialMomentum, gameplayConsumed}}
-		testDatapoint[i] = datapoint
+		buffer.WriteString("<br/><div>Players installed in the last 24 hours scored: ")
+		buffer.WriteString(strconv.Itoa(p.scored))
+		buffer.WriteString("</div>")
 	}
 
 	//Test prediction
-	var prediction float64
-	prediction, err = regress.TestModel(testDatapoint)
-	if err != nil {
-		return err.Error()
-	}
+	mean, _ := summarizeEvaluations(evaluations)
 
 	buffer.WriteString("<br/><div><h3>Prediction result percentage (cross-validation with testing data): ")
-	buffer.WriteString(strconv.FormatFloat(prediction, 'f', 2, 64))
+	buffer.WriteString(strconv.FormatFloat(mean.Accuracy, 'f', 2, 64))
 	buffer.WriteString(" </div>")
+	buffer.WriteString(evaluationsHTML(evaluations))
+
+	//Every method on the same folds
+	if p.compareMethods {
+		buffer.WriteString(comparisonHTML(p.compareClassifiers(folds, random)))
+	}
+
+	//Candidate models from the first fold
+	if regress != nil && p.selectionMethod != NoSelection {
+		training, err := p.generateModel(c, folds[0].Training, random, false)
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		selection, err := training.SelectFeatures(p.selectionMethod, p.selectionCriterion)
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		selection.Evaluate(playerDataPoints(folds[0].Testing, p.categoricalNames()))
+		buffer.WriteString(selection.StringHTML())
+	}
+
+	//Models of the folds behind the metrics, the shown model reports its own convergence
+	if notConverged > 0 {
+		buffer.WriteString("<div><strong>Warning: ")
+		buffer.WriteString(strconv.Itoa(notConverged))
+		buffer.WriteString(" of ")
+		buffer.WriteString(strconv.Itoa(len(folds)))
+		buffer.WriteString(" fold models did not converge</strong></div>")
+	}
+
+	//Testing players with app version not seen in training are scored as the reference version
+	if unseen > 0 {
+		buffer.WriteString("<div>Categorical levels not seen in training data (scored as reference level): ")
+		buffer.WriteString(strconv.FormatInt(int64(unseen), 10))
+		buffer.WriteString("</div>")
+	}
 
 	return buffer.String()
 }
+
+func (p *Predictor) crossValidation() bool {
+	return p.validationMode == KFoldValidation || p.validationMode == StratifiedKFoldValidation
+}
@@ -3,6 +3,7 @@ package predictor
 import (
 	"bytes"
 	"github.com/skelterjohn/go.matrix"
+	"html"
 	"math"
 	"strconv"
 
@@ -19,8 +20,19 @@ import (
 // - http://msdn.microsoft.com/en-us/magazine/jj618304.aspx
 
 type DataPoint struct {
-	Result    float64
-	Variables []float64
+	Result     float64
+	Variables  []float64
+	Categories []string //Level of each categorical variable
+	Weight     float64  //Sample weight used when fitting, zero means 1
+	Missing    []bool   //Variables without observed value, nil when all are observed
+}
+
+func (d DataPoint) weight() float64 {
+	if d.Weight <= 0 {
+		return 1.0
+	}
+
+	return d.Weight
 }
 
 type Model struct {
@@ -32,7 +44,22 @@ type Model struct {
 	UpperConfidenceIntervals []float64
 	LogLikelihood            float64
 	Deviance                 float64
+	AIC                      float64 //Akaike information criterion -2 * ln LF + 2 * coefficients
 	ChiSquare                float64
+	Separation               Separation
+	Scaling                  Scaling   //Scaling applied to the variables before fitting
+	OriginalCoefficients     []float64 //Coefficients transformed back to the unscaled variables
+	OriginalOddsRatio        []float64
+	Factors                  []Factor      //Levels of each categorical variable
+	FactorTests              []FactorTest  //Grouped tests of each categorical variable
+	Covariance               [][]float64   //Inverse of the information matrix inv(X'WX)
+	Bootstrap                *Bootstrap    //Bootstrap intervals, nil when not generated
+	Collinearity             *Collinearity //Multicollinearity diagnostics, nil for internal models
+	Imputation               Imputation    //Values filled in for missing variables
+	Outliers                 Outliers      //Outlier bounds and treatment of the variables
+	Convergence              Convergence   //Stop reason and trace of the solver
+	Classes                  []ClassModel  //Coefficients of every class against the reference, multinomial only
+	NullLogLikelihood        float64       //Log likelihood of the class proportions, multinomial only
 }
 
 type Regression struct {
@@ -42,6 +69,27 @@ type Regression struct {
 	variableNames []string    //Name of each independent variables
 	dataPoints    []DataPoint //Datapoints used for training
 	model         Model       //Regression model from training
+	fittingMode   FittingMode //Technique used to find the coefficients
+	scalingMode   ScalingMode //Scaling applied to variables before fitting
+
+	terms            []Term   //Terms of the model formula, all variables as they are if empty
+	interceptOnly    bool     //No numerical variable at all, used as the null model in feature selection
+	categoricalNames []string //Name of each categorical variables
+	unseenLevels     int      //Levels seen when scoring which are not in the training data
+	iteration        int      //Maximum Newton-Raphson iteration used in the last GenerateModel
+	collinearityDrop bool     //Drop terms with high variance inflation before fitting
+	classNames       []string //Observed classes of multinomial regression, empty for retained or not
+
+	imputationMode     ImputationMode //Filling of missing variables
+	imputationConstant float64
+	outlierDetection   OutlierDetection
+	outlierTreatment   OutlierTreatment
+
+	auxiliaryModel      bool //Model fitted internally (factor tests, bootstrap), skip the extra statistics
+	presetPreprocessing bool //Scaling and factors are copied from the parent model instead of computed
+	bootstrapSamples    int
+	bootstrapInterval   BootstrapInterval
+	bootstrapSeed       int64
 
 	debugMode    bool
 	debugContext appengine.Context
@@ -57,6 +105,52 @@ func (r *Regression) EnableDebugMode(c appengine.Context) {
 	r.debugContext = c
 }
 
+func (r *Regression) SetFittingMode(mode FittingMode) {
+	r.fittingMode = mode
+}
+
+func (r *Regression) SetScalingMode(mode ScalingMode) {
+	r.scalingMode = mode
+}
+
+//Use model formula to build the design matrix, variable names and categorical variables must be set first
+func (r *Regression) SetFormula(formula string) error {
+	parsed, err := ParseFormula(formula, r.variableNames, r.categoricalNames)
+	if err != nil {
+		return err
+	}
+
+	//Categorical variables are added as dummy variables, a formula of only those has no numerical term
+	r.terms = parsed.Terms
+	r.interceptOnly = len(parsed.Terms) == 0
+	if parsed.Observed != "" {
+		r.observedName = parsed.Observed
+	}
+
+	return nil
+}
+
+//New regression with the same variables and settings, without any data point
+func (r *Regression) cloneSettings() Regression {
+	var clone Regression
+	clone.Initialize(len(r.variableNames))
+	copy(clone.variableNames, r.variableNames)
+	clone.observedName = r.observedName
+	clone.fittingMode = r.fittingMode
+	clone.scalingMode = r.scalingMode
+	clone.imputationMode = r.imputationMode
+	clone.imputationConstant = r.imputationConstant
+	clone.outlierDetection = r.outlierDetection
+	clone.outlierTreatment = r.outlierTreatment
+	clone.terms = r.terms
+	clone.interceptOnly = r.interceptOnly
+	clone.categoricalNames = r.categoricalNames
+	clone.classNames = r.classNames
+	clone.auxiliaryModel = true
+
+	return clone
+}
+
 func (r *Regression) SetObservedName(observed string) {
 	r.observedName = observed
 }
@@ -73,55 +167,74 @@ func (r *Regression) AddDataPoint(data DataPoint) error {
 		return errors.New("Error: Number of variables in the data != in the model")
 	}
 
+	if len(data.Categories) != len(r.categoricalNames) {
+		return errors.New("Error: Number of categorical variables in the data != in the model")
+	}
+
 	r.dataPoints = append(r.dataPoints, data)
 	r.initialized = true
 
 	return nil
 }
 
-func (r *Regression) GenerateModel(iteration int) error {
+//Fit the model, the convergence report tells why the solver stopped
+func (r *Regression) GenerateModel(iteration int) (Convergence, error) {
+	r.model.Convergence = Convergence{}
+	err := r.fitModel(iteration)
+	return r.model.Convergence, err
+}
+
+func (r *Regression) fitModel(iteration int) error {
 	if !r.initialized {
 		return errors.New("Error: Need some data to perform regression")
 	}
 
-	numData := len(r.dataPoints)
-	numVariables := len(r.variableNames)
+	r.iteration = iteration
 
-	if numData <= numVariables {
-		return errors.New("Error: Datapoints must exceed variables")
+	//Compute imputed values, outlier treatment, scaling parameters and factor levels from training data
+	if !r.presetPreprocessing {
+		r.computeImputation()
+		r.computeOutliers()
+		r.computeScaling()
+		r.computeFactors()
 	}
 
-	//Create training data matrix for observed (result) and (independent) variables
-	trainingObserved := matrix.Zeros(numData, 1)
-	trainingVariables := matrix.Zeros(numData, numVariables+1)
+	//Check dependencies between variables before fitting, streaming solvers never hold the whole design matrix
+	if !r.auxiliaryModel && !r.fittingMode.streaming() {
+		r.computeCollinearity()
+	}
 
-	//Copy data to matrix
-	for i := 0; i < numData; i++ {
-		trainingObserved.Set(i, 0, r.dataPoints[i].Result)
-		for j := 0; j < numVariables+1; j++ {
-			if j == 0 {
-				trainingVariables.Set(i, 0, 1)
-			} else {
-				trainingVariables.Set(i, j, r.dataPoints[i].Variables[j-1])
-			}
-		}
+	numData := len(r.dataPoints)
+	numVariables := len(r.designNames())
+
+	if numData <= numVariables {
+		return errors.New("Error: Datapoints must exceed variables")
 	}
 
-	if r.debugMode {
-		r.debugContext.Infof("\n---------- VARIABLES ---------\n%s", trainingVariables.String())
-		r.debugContext.Infof("\n-------- OBSERVED -------\n%s", trainingObserved.String())
+	//More than two classes are fitted together, the binary statistics below do not apply
+	if r.multinomial() {
+		return r.computeMultinomialModel(iteration)
 	}
 
 	//Initialize model arrays
 	r.model.StandardErrors = make([]float64, numVariables+1)
 
-	//Newton-Raphson algorithm stop condition
-	maxIteration := iteration
-	epsilon := 0.01      //Stop if all coefficients change less than this | Algorithm has converged
-	jumpFactor := 1000.0 //Stop if any new coefficients jumps too much | Algorithm spinning out of control
+	//Check whether any variable predicts the observed value perfectly
+	r.detectSeparation()
 
-	//Use Newton-Raphson to find coefficients that best fit training data
-	err := r.computeBestCoefficients(trainingVariables, trainingObserved, maxIteration, epsilon, jumpFactor)
+	var err error
+	if r.fittingMode.streaming() {
+		//Stream the data points instead of building the design matrix
+		err = r.computeStreamingCoefficients(iteration)
+	} else {
+		err = r.computeMatrixCoefficients(iteration)
+	}
+	if err != nil {
+		return err
+	}
+
+	//Separation by a combination of variables only shows in the fitted probabilities
+	err = r.detectFittedSeparation()
 	if err != nil {
 		return err
 	}
@@ -132,6 +245,9 @@ func (r *Regression) GenerateModel(iteration int) error {
 		return err
 	}
 
+	//Transform coefficients back to the original variables
+	r.computeOriginalCoefficients()
+
 	//Compute pValue of wald statistics from the generated coefficients
 	err = r.computeWaldStatistic()
 	if err != nil {
@@ -156,9 +272,58 @@ func (r *Regression) GenerateModel(iteration int) error {
 	//Compute chi-square value
 	r.computeChiSquare()
 
+	//Test each categorical variable as a whole, the reduced models are full refits so streaming solvers skip them
+	if !r.auxiliaryModel && !r.fittingMode.streaming() {
+		err = r.computeFactorTests(iteration)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+//Build the numData x (variables + 1) design matrix and fit it with Newton-Raphson or Firth
+func (r *Regression) computeMatrixCoefficients(iteration int) error {
+	numData := len(r.dataPoints)
+	numVariables := len(r.designNames())
+
+	//Create training data matrix for observed (result) and (independent) variables
+	trainingObserved := matrix.Zeros(numData, 1)
+	trainingVariables := matrix.Zeros(numData, numVariables+1)
+
+	//Copy data to matrix
+	for i := 0; i < numData; i++ {
+		trainingObserved.Set(i, 0, r.dataPoints[i].Result)
+		variables := r.designVariables(r.dataPoints[i])
+		for j := 0; j < numVariables+1; j++ {
+			if j == 0 {
+				trainingVariables.Set(i, 0, 1)
+			} else {
+				trainingVariables.Set(i, j, variables[j-1])
+			}
+		}
+	}
+
+	if r.debugMode {
+		r.debugContext.Infof("\n---------- VARIABLES ---------\n%s", trainingVariables.String())
+		r.debugContext.Infof("\n-------- OBSERVED -------\n%s", trainingObserved.String())
+	}
+
+	//Newton-Raphson algorithm stop condition
+	maxIteration := iteration
+	epsilon := 0.01      //Stop if all coefficients change less than this | Algorithm has converged
+	jumpFactor := 1000.0 //Stop if any new coefficients jumps too much | Algorithm spinning out of control
+
+	if r.fittingMode == FirthFitting {
+		//Use Firth's penalized likelihood which stays finite under separation
+		return r.computeFirthCoefficients(trainingVariables, trainingObserved, maxIteration, epsilon)
+	}
+
+	//Use Newton-Raphson to find coefficients that best fit training data
+	return r.computeBestCoefficients(trainingVariables, trainingObserved, maxIteration, epsilon, jumpFactor)
+}
+
 //Use the Newton-Raphson technique to estimate logistic regression beta parameters: b[t] = b[t-1] + inv(X'W[t-1]X)X'(Y - p[t-1])
 //- xTrainingVector is a design matrix of predictor variables where the first column is augmented with all 1.0 to represent dummy x values for the b0 constant
 //- yTrainingVector is a column vector of binary (0.0 or 1.0) dependent variables
@@ -215,6 +380,10 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 		r.debugContext.Infof("\nInitial MSE:\n%f", mse)
 	}
 
+	//Report why the iterations stopped
+	convergence := Convergence{Solver: NewtonRaphsonFitting, Reason: MaxIterationsReached}
+	convergence.Trace = append(convergence.Trace, r.matrixStep(0, xTrainingVector, yTrainingVector, pVector, nil))
+
 	//How many times are the new betas worse (i.e., give worse MSE) than the current betas
 	timesWorse := 0
 	for i := 0; i < maxIteration; i++ {
@@ -224,6 +393,7 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 			if r.debugMode {
 				r.debugContext.Infof("\nNew coefficients vector is null | current product cannot be inverted -- stopping")
 			}
+			convergence.Reason = SingularInformation
 			break
 		}
 
@@ -236,6 +406,7 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 			if r.debugMode {
 				r.debugContext.Infof("\nNo significant change between old beta values and new beta values -- stopping")
 			}
+			convergence.Reason = CoefficientsConverged
 			break
 		}
 
@@ -244,6 +415,7 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 			if r.debugMode {
 				r.debugContext.Infof("\nThe new coefficients vector has at least one value which changed by a factor of %s -- stopping", jumpFactor)
 			}
+			convergence.Reason = OutOfControl
 			break
 		}
 
@@ -265,6 +437,9 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 			r.debugContext.Infof("\nNew calculated MSE:\n%f", newMSE)
 		}
 
+		convergence.Iterations = i + 1
+		convergence.Trace = append(convergence.Trace, r.matrixStep(i+1, xTrainingVector, yTrainingVector, pVector, nil))
+
 		if newMSE > mse {
 			//Update counter if newMSE is worst than the current one
 			timesWorse += 1
@@ -272,6 +447,7 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 				if r.debugMode {
 					r.debugContext.Infof("\nThe new coefficients vector produced worse predictions even after modification four times in a row -- stopping")
 				}
+				convergence.Reason = WorseFit
 				break
 			}
 
@@ -306,8 +482,11 @@ func (r *Regression) computeBestCoefficients(xTrainingVector matrix.Matrix, yTra
 
 	if r.debugMode {
 		r.debugContext.Infof("\nBest coefficients vector:\n%s", bestCoeffVector.String())
+		r.debugContext.Infof("\nStopped after %v iterations: %v", convergence.Iterations, convergence.Reason)
 	}
 
+	r.model.Convergence = convergence
+
 	//Done, put coefficients data from matrix to arrays
 	length := bestCoeffVector.Rows()
 	r.model.Coefficients = make([]float64, length)
@@ -337,7 +516,7 @@ func (r *Regression) constructProbVector(xMatrix matrix.Matrix, bVector matrix.M
 	return times, nil
 }
 
-//How good are the predictions? (using an already-calculated prob vector)
+//How good are the predictions? (using an already-calculated prob vector), weighted by the sample weights
 //Note: it is possible that a model with better (lower) MSE than a second model could give worse predictive accuracy.
 func (r *Regression) meanSquaredError(pVector matrix.Matrix, yVector matrix.Matrix) (mse float64, err error) {
 	pRows := pVector.Rows()
@@ -353,10 +532,13 @@ func (r *Regression) meanSquaredError(pVector matrix.Matrix, yVector matrix.Matr
 	}
 
 	result := 0.0
+	totalWeight := 0.0
 	for i := 0; i < pRows; i++ {
-		result += (pVector.Get(i, 0) - yVector.Get(i, 0)) * (pVector.Get(i, 0) - yVector.Get(i, 0))
+		weight := r.sampleWeight(i)
+		result += weight * (pVector.Get(i, 0) - yVector.Get(i, 0)) * (pVector.Get(i, 0) - yVector.Get(i, 0))
+		totalWeight += weight
 	}
-	mse = result / float64(pRows)
+	mse = result / totalWeight
 
 	return mse, nil
 }
@@ -367,7 +549,7 @@ func (r *Regression) meanSquaredError(pVector matrix.Matrix, yVector matrix.Matr
 // b[t] is the new (time t) b column vector
 // b[t-1] is the old (time t-1) vector
 // X' is the transpose of the X matrix of x data (1.0, age, sex, chol)
-// W[t-1] is the old weight matrix
+// W[t-1] is the old weight matrix, diagonal of v * p * (1 - p) where v is the sample weight
 // y is the column vector of binary dependent variable data
 // p[t-1] is the old column probability vector (computed as 1.0 / (1.0 + exp(-z) where z = b0x0 + b1x1 + . . .)
 
@@ -390,16 +572,16 @@ func (r *Regression) constructNewCoefficientsVector(oldBVector matrix.Matrix, xM
 		return nil
 	}
 
-	//Save standard error
-	errLength := len(r.model.StandardErrors)
-	for i := 0; i < errLength; i++ {
-		r.model.StandardErrors[i] = math.Sqrt(C.Get(i, i))
-	}
+	//Save standard error and covariance
+	r.saveCovariance(C)
 
 	D := matrix.Product(C, Xt)                   // inv(X'WX)X'
 	YP := matrix.Difference(yVector, oldPVector) // y-p
-	E := matrix.Product(D, YP)                   // inv(X'WX)X'(y-p)
-	result := matrix.Sum(oldBVector, E)          // b + inv(X'WX)X'(y-p)
+	for i := 0; i < YP.Rows(); i++ {
+		YP.Set(i, 0, r.sampleWeight(i)*YP.Get(i, 0)) // v(y-p) with sample weights v
+	}
+	E := matrix.Product(D, YP)          // inv(X'WX)X'v(y-p)
+	result := matrix.Sum(oldBVector, E) // b + inv(X'WX)X'v(y-p)
 
 	return result
 }
@@ -427,13 +609,36 @@ func (r *Regression) computeXtilde(pVector matrix.Matrix, xMatrix matrix.Matrix)
 		for j := 0; j < xCols; j++ {
 			pVal := pVector.Get(i, 0)
 			xVal := xMatrix.Get(i, j)
-			result.Set(i, j, pVal*(1.0-pVal)*xVal) //Note the p(1-p)
+			result.Set(i, j, r.sampleWeight(i)*pVal*(1.0-pVal)*xVal) //Note the p(1-p)
 		}
 	}
 
 	return result, nil
 }
 
+//Weight of the training data point in row i of the design matrix
+func (r *Regression) sampleWeight(i int) float64 {
+	if i < 0 || i >= len(r.dataPoints) {
+		return 1.0
+	}
+
+	return r.dataPoints[i].weight()
+}
+
+//Keep inv(X'WX) as the coefficients covariance and its diagonal as the standard errors
+func (r *Regression) saveCovariance(C matrix.Matrix) {
+	length := C.Rows()
+	r.model.StandardErrors = make([]float64, length)
+	r.model.Covariance = make([][]float64, length)
+	for i := 0; i < length; i++ {
+		r.model.StandardErrors[i] = math.Sqrt(C.Get(i, i))
+		r.model.Covariance[i] = make([]float64, length)
+		for j := 0; j < length; j++ {
+			r.model.Covariance[i][j] = C.Get(i, j)
+		}
+	}
+}
+
 func (r *Regression) noChange(oldBVector matrix.Matrix, newBVector matrix.Matrix, epsilon float64) bool {
 	length := oldBVector.Rows()
 	for i := 0; i < length; i++ {
@@ -527,64 +732,26 @@ func (r *Regression) computeConfidenceInterval() error {
 	return nil
 }
 
-//Log likelihood ln LF = TotalAddition[(Yi * ln Pi) + (1 - Yi) * ln (1 - Pi)]
+//Log likelihood ln LF = TotalAddition[Vi * ((Yi * ln Pi) + (1 - Yi) * ln (1 - Pi))] where Vi is the sample weight
+//Computed one data point at a time so no design matrix is needed
 func (r *Regression) computeLogLikelihood() error {
-	numData := len(r.dataPoints)
-	numVariables := len(r.dataPoints[0].Variables)
-
-	//Create test data matrix for observed (result) and (independent) variables
-	testObserved := matrix.Zeros(numData, 1)
-	testVariables := matrix.Zeros(numData, numVariables+1)
-
-	//Copy data to matrix
-	for i := 0; i < numData; i++ {
-		testObserved.Set(i, 0, r.dataPoints[i].Result)
-		for j := 0; j < numVariables+1; j++ {
-			if j == 0 {
-				testVariables.Set(i, 0, 1)
-			} else {
-				testVariables.Set(i, j, r.dataPoints[i].Variables[j-1])
-			}
-		}
-	}
-
 	//Error check the coefficient
 	coeffLen := len(r.model.Coefficients)
 	if coeffLen == 0 {
 		return errors.New("Error: Coefficients in models are not generated yet")
 	}
 
-	//Create coefficient matrix from already generated model
-	bVector := matrix.Zeros(coeffLen, 1)
-	for i := 0; i < coeffLen; i++ {
-		bVector.Set(i, 0, r.model.Coefficients[i])
-	}
-
-	//Error checking again
-	xRows := testVariables.Rows()
-	xCols := testVariables.Cols()
-	yRows := testObserved.Rows()
-	bRows := bVector.Rows()
-	if xCols != bRows || xRows != yRows {
-		return errors.New("Error:Bad dimensions for xMatrix or yVector or bVector in computeLogLikelihood()")
-	}
-
-	pVector, err := r.constructProbVector(testVariables, bVector)
-	if err != nil {
-		return err
-	}
-
-	pRows := pVector.Rows()
-	if pRows != xRows {
-		return errors.New("Error:Unequal rows in prob vector and design matrix in computeLogLikelihood()")
-	}
-
 	//Initiate cases
 	logLikelihood := 0.0
 
-	for i := 0; i < yRows; i++ {
-		pVal := pVector.Get(i, 0)
-		observedVal := testObserved.Get(i, 0)
+	for i, data := range r.dataPoints {
+		variables := r.designVariables(data)
+		if len(variables)+1 != coeffLen {
+			return errors.New("Error:Bad dimensions for variables or coefficients in computeLogLikelihood()")
+		}
+
+		pVal := sigmoid(r.linearPredictor(variables))
+		observedVal := data.Result
 
 		current := 0.0
 		if observedVal == 0.0 {
@@ -592,8 +759,9 @@ func (r *Regression) computeLogLikelihood() error {
 		} else if observedVal == 1.0 {
 			current = math.Log(pVal)
 		}
+		current *= r.sampleWeight(i)
 
-		if r.debugMode {
+		if r.debugMode && !r.fittingMode.streaming() {
 			r.debugContext.Infof("\nY is %v and P is %v", observedVal, pVal)
 			r.debugContext.Infof("\n(Yi * ln Pi) + (1 - Yi) * ln (1 - Pi): %v", current)
 		}
@@ -611,8 +779,26 @@ func (r *Regression) computeLogLikelihood() error {
 	return nil
 }
 
+//z = b0 + b1x1 + b2x2 + . . . of the design variables using the model coefficients
+func (r *Regression) linearPredictor(variables []float64) float64 {
+	coefficients := r.model.Coefficients
+	if len(coefficients) == 0 {
+		return 0.0
+	}
+
+	z := coefficients[0]
+	for j, val := range variables {
+		if j+1 < len(coefficients) {
+			z += coefficients[j+1] * val
+		}
+	}
+
+	return z
+}
+
 func (r *Regression) computeDeviance() {
 	r.model.Deviance = -2 * r.model.LogLikelihood
+	r.model.AIC = r.model.Deviance + 2*float64(len(r.model.Coefficients))
 }
 
 func (r *Regression) computeChiSquare() {
@@ -622,7 +808,7 @@ func (r *Regression) computeChiSquare() {
 	for i := 0; i < length; i++ {
 		//Assume predicted probability of 0.5
 		//Current is always ln 0.5 because whether observed is 1 or 0, 1 - 0.5 and 0.5 is the same
-		logLikelihoodBase += math.Log(0.5)
+		logLikelihoodBase += r.sampleWeight(i) * math.Log(0.5)
 	}
 
 	//Calculate baseline deviance
@@ -641,14 +827,15 @@ func (r *Regression) computeChiSquare() {
 
 func (r *Regression) Predict(testData DataPoint) (predicted float64, err error) {
 	//Create matrix for independent variables
-	numVariables := len(testData.Variables)
+	variables := r.designVariables(testData)
+	numVariables := len(variables)
 	testVariables := matrix.Zeros(1, numVariables+1)
 
-	for i := 0; i < numVariables; i++ {
+	for i := 0; i < numVariables+1; i++ {
 		if i == 0 {
 			testVariables.Set(0, 0, 1)
 		} else {
-			testVariables.Set(0, i, testData.Variables[i-i])
+			testVariables.Set(0, i, variables[i-1])
 		}
 	}
 
@@ -678,7 +865,8 @@ func (r *Regression) Predict(testData DataPoint) (predicted float64, err error)
 
 func (r *Regression) TestModel(testData []DataPoint) (accuracy float64, err error) {
 	numData := len(testData)
-	numVariables := len(testData[0].Variables)
+	numVariables := len(r.designNames())
+	r.unseenLevels = 0
 
 	//Create test data matrix for observed (result) and (independent) variables
 	testObserved := matrix.Zeros(numData, 1)
@@ -687,11 +875,12 @@ func (r *Regression) TestModel(testData []DataPoint) (accuracy float64, err erro
 	//Copy data to matrix
 	for i := 0; i < numData; i++ {
 		testObserved.Set(i, 0, testData[i].Result)
+		variables := r.designVariables(testData[i])
 		for j := 0; j < numVariables+1; j++ {
 			if j == 0 {
 				testVariables.Set(i, 0, 1)
 			} else {
-				testVariables.Set(i, j, testData[i].Variables[j-1])
+				testVariables.Set(i, j, variables[j-1])
 			}
 		}
 	}
@@ -763,7 +952,8 @@ func (r *Regression) String() string {
 	var buffer bytes.Buffer
 	buffer.WriteString("Name|Coefficient|Odds Ratio|Std. Error|p-Value|Lower Confidence|Upper Confidence\n")
 
-	length := len(r.variableNames) + 1
+	names := r.designNames()
+	length := len(names) + 1
 	for i := 0; i < length; i++ {
 		index := i - 1
 
@@ -771,7 +961,7 @@ func (r *Regression) String() string {
 		if index == -1 {
 			variableString = "Intercept"
 		} else {
-			variableString = r.variableNames[index]
+			variableString = names[index]
 		}
 
 		coeffString := strconv.FormatFloat(r.model.Coefficients[i], 'f', 6, 64)
@@ -819,9 +1009,21 @@ func (r *Regression) String() string {
 }
 
 func (r *Regression) StringHTML() string {
+	if r.multinomial() {
+		return r.multinomialHTML()
+	}
+
 	//HTML string buffer
 	var buffer bytes.Buffer
 
+	//Show original-scale coefficients next to the standardized ones
+	scaled := r.model.Scaling.Mode != NoScaling
+	if scaled {
+		buffer.WriteString("<div>Variables scaled using ")
+		buffer.WriteString(r.model.Scaling.Mode.String())
+		buffer.WriteString(", coefficients and odds ratio are per scaled unit</div>")
+	}
+
 	//Table header
 	buffer.WriteString("<table>")
 	buffer.WriteString("<tr>")
@@ -832,10 +1034,15 @@ func (r *Regression) StringHTML() string {
 	buffer.WriteString("<td>p-Value</td>")
 	buffer.WriteString("<td>Lower Confidence</td>")
 	buffer.WriteString("<td>Upper Confidence</td>")
+	if scaled {
+		buffer.WriteString("<td>Original Coefficient</td>")
+		buffer.WriteString("<td>Original Odds Ratio</td>")
+	}
 	buffer.WriteString("</tr>")
 
 	//Table attributes
-	length := len(r.variableNames) + 1
+	names := r.designNames()
+	length := len(names) + 1
 	for i := 0; i < length; i++ {
 		//Decrease one for fun
 		index := i - 1
@@ -845,7 +1052,7 @@ func (r *Regression) StringHTML() string {
 		if index == -1 {
 			variableString = "Intercept"
 		} else {
-			variableString = r.variableNames[index]
+			variableString = names[index]
 		}
 
 		//Convert attributes to string
@@ -861,7 +1068,7 @@ func (r *Regression) StringHTML() string {
 
 		//Model attributes
 		buffer.WriteString("<td>")
-		buffer.WriteString(variableString)
+		buffer.WriteString(html.EscapeString(variableString))
 		buffer.WriteString("</td>")
 
 		buffer.WriteString("<td>")
@@ -888,6 +1095,16 @@ func (r *Regression) StringHTML() string {
 		buffer.WriteString(upperString)
 		buffer.WriteString("</td>")
 
+		if scaled {
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(r.model.OriginalCoefficients[i], 'f', 6, 64))
+			buffer.WriteString("</td>")
+
+			buffer.WriteString("<td>")
+			buffer.WriteString(strconv.FormatFloat(r.model.OriginalOddsRatio[i], 'f', 6, 64))
+			buffer.WriteString("</td>")
+		}
+
 		//Footer
 		buffer.WriteString("</tr>")
 	}
@@ -895,6 +1112,30 @@ func (r *Regression) StringHTML() string {
 	//End table
 	buffer.WriteString("</table>")
 
+	//Separation warning
+	buffer.WriteString(r.separationHTML())
+	buffer.WriteString(r.convergenceHTML())
+
+	//Missing values
+	buffer.WriteString(r.imputationHTML())
+
+	//Outlier treatment
+	buffer.WriteString(r.outliersHTML())
+
+	//Multicollinearity warning
+	buffer.WriteString(r.collinearityHTML())
+	if r.fittingMode.streaming() {
+		buffer.WriteString("<br/><div>Multicollinearity diagnostics and grouped tests of categorical variables are skipped by ")
+		buffer.WriteString(r.fittingMode.String())
+		buffer.WriteString("</div>")
+	}
+
+	//Grouped tests of categorical variables
+	buffer.WriteString(r.factorTestsHTML())
+
+	//Bootstrap confidence intervals
+	buffer.WriteString(r.bootstrapHTML())
+
 	//Calculate model performance
 	logLikelihoodString := strconv.FormatFloat(r.model.LogLikelihood, 'f', 15, 64)
 	devianceString := strconv.FormatFloat(r.model.Deviance, 'f', 15, 64)
@@ -907,6 +1148,9 @@ func (r *Regression) StringHTML() string {
 	buffer.WriteString("<div>-2 * Log Likelihood (Deviance): ")
 	buffer.WriteString(devianceString)
 	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Akaike Information Criterion (AIC): ")
+	buffer.WriteString(strconv.FormatFloat(r.model.AIC, 'f', 6, 64))
+	buffer.WriteString("</div>")
 	buffer.WriteString("<div>Chi-Square Goodness of Fit: ")
 	buffer.WriteString(chiString)
 	buffer.WriteString("</div>")
@@ -0,0 +1,164 @@
+package predictor
+
+import (
+	"math"
+)
+
+//Feature scaling applied to the independent variables before generating the model
+//
+//Scaling parameters are computed from the training data and kept with the model so the same
+//transformation is applied to any data used in Predict or TestModel.
+
+type ScalingMode int
+
+const (
+	NoScaling     ScalingMode = iota //Use variables as they are
+	ZScoreScaling                    //(x - mean) / standard deviation
+	MinMaxScaling                    //(x - min) / (max - min)
+)
+
+func (m ScalingMode) String() string {
+	switch m {
+	case ZScoreScaling:
+		return "Z-Score Standardization"
+	case MinMaxScaling:
+		return "Min-Max Scaling"
+	default:
+		return "None"
+	}
+}
+
+type Scaling struct {
+	Mode    ScalingMode
+	Centers []float64 //Subtracted from each variable (mean or min)
+	Scales  []float64 //Divider of each variable (standard deviation or range)
+}
+
+//Compute centers and scales of every variable from the training data points
+func (r *Regression) computeScaling() {
+	numVariables := len(r.variableNames)
+	numData := len(r.dataPoints)
+
+	//Scale the imputed and treated values
+	variables := make([][]float64, numData)
+	for i, data := range r.dataPoints {
+		variables[i] = r.preprocessVariables(data)
+	}
+
+	scaling := Scaling{Mode: r.scalingMode}
+	scaling.Centers = make([]float64, numVariables)
+	scaling.Scales = make([]float64, numVariables)
+
+	for j := 0; j < numVariables; j++ {
+		center := 0.0
+		scale := 1.0
+
+		if r.scalingMode == ZScoreScaling && numData > 1 {
+			mean := 0.0
+			for i := range r.dataPoints {
+				mean += variables[i][j]
+			}
+			mean /= float64(numData)
+
+			variance := 0.0
+			for i := range r.dataPoints {
+				variance += (variables[i][j] - mean) * (variables[i][j] - mean)
+			}
+			variance /= float64(numData - 1)
+
+			center = mean
+			scale = math.Sqrt(variance)
+		} else if r.scalingMode == MinMaxScaling && numData > 0 {
+			min, max := math.Inf(1), math.Inf(-1)
+			for i := range r.dataPoints {
+				min = math.Min(min, variables[i][j])
+				max = math.Max(max, variables[i][j])
+			}
+
+			center = min
+			scale = max - min
+		}
+
+		//Constant variable, just shift it
+		if scale == 0 {
+			scale = 1.0
+		}
+
+		scaling.Centers[j] = center
+		scaling.Scales[j] = scale
+	}
+
+	r.model.Scaling = scaling
+
+	if r.debugMode && r.scalingMode != NoScaling {
+		r.debugContext.Infof("\nScaling centers: %v\nScaling scales: %v", scaling.Centers, scaling.Scales)
+	}
+}
+
+//Return the variables transformed using the scaling stored in the model
+func (r *Regression) scaleVariables(variables []float64) []float64 {
+	if r.model.Scaling.Mode == NoScaling || len(r.model.Scaling.Scales) != len(variables) {
+		return variables
+	}
+
+	scaled := make([]float64, len(variables))
+	for j, val := range variables {
+		scaled[j] = (val - r.model.Scaling.Centers[j]) / r.model.Scaling.Scales[j]
+	}
+
+	return scaled
+}
+
+//Coefficients are generated on the scaled variables, transform them back so they apply to the original units:
+//b[j] = b'[j] / s[j] and b[0] = b'[0] - TotalAddition(b'[j] * c[j] / s[j])
+//
+//Interaction and polynomial terms of scaled variables have no single original-scale coefficient, those are NaN.
+func (r *Regression) computeOriginalCoefficients() {
+	r.model.OriginalCoefficients = r.originalCoefficients(r.model.Coefficients)
+	r.model.OriginalOddsRatio = make([]float64, len(r.model.OriginalCoefficients))
+	for i, coefficient := range r.model.OriginalCoefficients {
+		r.model.OriginalOddsRatio[i] = math.Exp(coefficient)
+	}
+}
+
+//Coefficients of the design matrix with the intercept first, transformed back to the unscaled variables
+func (r *Regression) originalCoefficients(coefficients []float64) []float64 {
+	length := len(coefficients)
+	original := make([]float64, length)
+
+	if length == 0 {
+		return original
+	}
+
+	scaled := r.model.Scaling.Mode != NoScaling
+	intercept := coefficients[0]
+	for i := 1; i < length; i++ {
+		coefficient := coefficients[i]
+
+		//Variable of the coefficient, dummy variables are not scaled
+		index := i - 1
+		if r.interceptOnly {
+			index = -1
+		} else if len(r.terms) > 0 && index < len(r.terms) {
+			linear, ok := r.terms[index].isLinear()
+			if !ok && scaled {
+				intercept = math.NaN()
+				coefficient = math.NaN()
+			}
+			index = linear
+		} else if len(r.terms) > 0 {
+			index = -1
+		}
+
+		if scaled && index >= 0 && index < len(r.model.Scaling.Scales) {
+			coefficient /= r.model.Scaling.Scales[index]
+			intercept -= coefficient * r.model.Scaling.Centers[index]
+		}
+
+		original[i] = coefficient
+	}
+
+	original[0] = intercept
+
+	return original
+}
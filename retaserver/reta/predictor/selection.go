@@ -0,0 +1,439 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"reta/errors"
+)
+
+//Automated feature selection
+//
+//Selection works on units: every formula term (or every variable when there is no formula) and every
+//categorical variable as a whole. Each fitted subset becomes a candidate model ranked by AIC.
+//
+// - Forward stepwise starts from the intercept-only model and adds the best unit on every step
+// - Backward stepwise starts from the full model and removes the worst unit on every step
+// - LASSO path fits L1 penalized models over decreasing lambda, each distinct active set is refitted
+//   without penalty as a candidate (see lasso.go)
+
+type SelectionMethod int
+
+const (
+	NoSelection SelectionMethod = iota
+	ForwardSelection
+	BackwardSelection
+	LassoSelection
+)
+
+func (m SelectionMethod) String() string {
+	switch m {
+	case ForwardSelection:
+		return "Forward Stepwise"
+	case BackwardSelection:
+		return "Backward Stepwise"
+	case LassoSelection:
+		return "LASSO Path"
+	default:
+		return "None"
+	}
+}
+
+type SelectionCriterion int
+
+const (
+	AICCriterion             SelectionCriterion = iota //Accept a step when AIC decreases
+	LikelihoodRatioCriterion                           //Enter when LR p-value < 0.05, remove when > 0.10
+)
+
+func (c SelectionCriterion) String() string {
+	if c == LikelihoodRatioCriterion {
+		return "Likelihood Ratio Test"
+	}
+
+	return "AIC"
+}
+
+type CandidateModel struct {
+	Names         []string //Terms and categorical variables in the model
+	Coefficients  int
+	LogLikelihood float64
+	AIC           float64
+	Selected      bool    //Chosen on the stepwise path
+	Lambda        float64 //LASSO penalty where this active set first appears
+	HasEvaluation bool
+	Evaluation    Evaluation
+
+	terms   []int //Index of included terms
+	factors []int //Index of included categorical variables
+	model   *Regression
+}
+
+type Selection struct {
+	Method     SelectionMethod
+	Criterion  SelectionCriterion
+	Candidates []CandidateModel //Ranked by AIC
+	Path       *LassoPath       //Only for LASSO
+}
+
+//Terms used as selection units, every variable when there is no formula
+func (r *Regression) selectionTerms() []Term {
+	if len(r.terms) > 0 {
+		return r.terms
+	}
+
+	terms := make([]Term, len(r.variableNames))
+	for i := range r.variableNames {
+		terms[i] = Term{Variables: []int{i}, Powers: []int{1}}
+	}
+
+	return terms
+}
+
+//Fit model using only the given terms and categorical variables
+func (r *Regression) fitSubset(termIndexes []int, factorIndexes []int) (*Regression, error) {
+	subset := r.cloneSettings()
+
+	allTerms := r.selectionTerms()
+	subset.terms = nil
+	for _, index := range termIndexes {
+		subset.terms = append(subset.terms, allTerms[index])
+	}
+	subset.interceptOnly = len(subset.terms) == 0
+
+	subset.categoricalNames = nil
+	for _, index := range factorIndexes {
+		subset.categoricalNames = append(subset.categoricalNames, r.categoricalNames[index])
+	}
+
+	for _, data := range r.dataPoints {
+		point := DataPoint{Result: data.Result, Variables: data.Variables, Weight: data.Weight, Missing: data.Missing}
+		for _, index := range factorIndexes {
+			point.Categories = append(point.Categories, data.Categories[index])
+		}
+
+		err := subset.AddDataPoint(point)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err := subset.GenerateModel(r.iteration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &subset, nil
+}
+
+func (r *Regression) newCandidate(termIndexes []int, factorIndexes []int) (CandidateModel, error) {
+	candidate := CandidateModel{terms: termIndexes, factors: factorIndexes, Lambda: math.NaN()}
+
+	model, err := r.fitSubset(termIndexes, factorIndexes)
+	if err != nil {
+		return candidate, err
+	}
+
+	allTerms := r.selectionTerms()
+	for _, index := range termIndexes {
+		candidate.Names = append(candidate.Names, allTerms[index].Name(r.variableNames))
+	}
+	for _, index := range factorIndexes {
+		candidate.Names = append(candidate.Names, r.categoricalNames[index])
+	}
+
+	candidate.model = model
+	candidate.Coefficients = len(model.model.Coefficients)
+	candidate.LogLikelihood = model.model.LogLikelihood
+	candidate.AIC = model.model.AIC
+
+	return candidate, nil
+}
+
+//Run feature selection on the training data points
+func (r *Regression) SelectFeatures(method SelectionMethod, criterion SelectionCriterion) (*Selection, error) {
+	if !r.initialized {
+		return nil, errors.New("Error: Need some data to perform feature selection")
+	}
+
+	if r.iteration == 0 {
+		r.iteration = 20
+	}
+
+	//Imputation, outlier treatment, scaling and factors of the full model
+	if !r.presetPreprocessing {
+		r.computeImputation()
+		r.computeOutliers()
+		r.computeScaling()
+		r.computeFactors()
+	}
+
+	selection := Selection{Method: method, Criterion: criterion}
+
+	var err error
+	switch method {
+	case ForwardSelection, BackwardSelection:
+		selection.Candidates, err = r.stepwise(method == ForwardSelection, criterion)
+	case LassoSelection:
+		selection.Path, selection.Candidates, err = r.lassoSelection()
+	default:
+		return nil, errors.New("Error: Unknown feature selection method")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Stable(byAIC(selection.Candidates))
+
+	return &selection, nil
+}
+
+func (r *Regression) stepwise(forward bool, criterion SelectionCriterion) ([]CandidateModel, error) {
+	numTerms := len(r.selectionTerms())
+	numFactors := len(r.categoricalNames)
+
+	//Unit i < numTerms is a term, otherwise a categorical variable
+	included := make([]bool, numTerms+numFactors)
+	for i := range included {
+		included[i] = !forward
+	}
+
+	fitted := make(map[string]*CandidateModel)
+	var order []string
+
+	fit := func(units []bool) (*CandidateModel, error) {
+		var terms, factors []int
+		for i, in := range units {
+			if in && i < numTerms {
+				terms = append(terms, i)
+			} else if in {
+				factors = append(factors, i-numTerms)
+			}
+		}
+
+		key := unitsKey(units)
+		if candidate, ok := fitted[key]; ok {
+			return candidate, nil
+		}
+
+		candidate, err := r.newCandidate(terms, factors)
+		if err != nil {
+			return nil, err
+		}
+		fitted[key] = &candidate
+		order = append(order, key)
+
+		return &candidate, nil
+	}
+
+	current, err := fit(included)
+	if err != nil {
+		return nil, err
+	}
+	current.Selected = true
+
+	for {
+		var best *CandidateModel
+		bestUnit := -1
+		bestScore := math.Inf(1)
+
+		for unit := range included {
+			if included[unit] != !forward {
+				continue
+			}
+
+			next := make([]bool, len(included))
+			copy(next, included)
+			next[unit] = forward
+
+			candidate, err := fit(next)
+			if err != nil {
+				//Singular or separated subset, skip it
+				continue
+			}
+
+			//Lower score is better
+			score := candidate.AIC
+			if criterion == LikelihoodRatioCriterion {
+				score = stepPValue(current, candidate, forward)
+				if !forward {
+					score = -score
+				}
+			}
+
+			if score < bestScore {
+				best = candidate
+				bestUnit = unit
+				bestScore = score
+			}
+		}
+
+		if best == nil {
+			break
+		}
+
+		accept := false
+		if criterion == LikelihoodRatioCriterion {
+			pValue := stepPValue(current, best, forward)
+			accept = (forward && pValue < 0.05) || (!forward && pValue > 0.10)
+		} else {
+			accept = best.AIC < current.AIC
+		}
+
+		if !accept {
+			break
+		}
+
+		included[bestUnit] = forward
+		best.Selected = true
+		current = best
+	}
+
+	candidates := make([]CandidateModel, 0, len(order))
+	for _, key := range order {
+		candidates = append(candidates, *fitted[key])
+	}
+
+	return candidates, nil
+}
+
+//p-Value of likelihood ratio between smaller and larger nested model
+func stepPValue(current *CandidateModel, next *CandidateModel, forward bool) float64 {
+	small, large := current, next
+	if !forward {
+		small, large = next, current
+	}
+
+	df := large.Coefficients - small.Coefficients
+	if df <= 0 {
+		return 1.0
+	}
+
+	return chiSquarePValue(2.0*(large.LogLikelihood-small.LogLikelihood), df)
+}
+
+func unitsKey(units []bool) string {
+	key := make([]byte, len(units))
+	for i, in := range units {
+		if in {
+			key[i] = '1'
+		} else {
+			key[i] = '0'
+		}
+	}
+
+	return string(key)
+}
+
+type byAIC []CandidateModel
+
+func (b byAIC) Len() int           { return len(b) }
+func (b byAIC) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byAIC) Less(i, j int) bool { return b[i].AIC < b[j].AIC }
+
+//Evaluate every candidate model against the testing data
+func (s *Selection) Evaluate(testData []DataPoint) {
+	for i := range s.Candidates {
+		candidate := &s.Candidates[i]
+
+		//Keep only the categorical variables used by the candidate
+		points := make([]DataPoint, len(testData))
+		for j, data := range testData {
+			points[j] = DataPoint{Result: data.Result, Variables: data.Variables, Missing: data.Missing}
+			for _, index := range candidate.factors {
+				points[j].Categories = append(points[j].Categories, data.Categories[index])
+			}
+		}
+
+		evaluation, err := candidate.model.Evaluate(points)
+		if err == nil {
+			candidate.Evaluation = evaluation
+			candidate.HasEvaluation = true
+		}
+	}
+}
+
+//Ranked table of candidate models
+func (s *Selection) StringHTML() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Feature Selection: ")
+	buffer.WriteString(s.Method.String())
+	if s.Method != LassoSelection {
+		buffer.WriteString(" using ")
+		buffer.WriteString(s.Criterion.String())
+	}
+	buffer.WriteString("</h3></div>")
+
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Rank</td>")
+	buffer.WriteString("<td>Variables</td>")
+	buffer.WriteString("<td>Coefficients</td>")
+	buffer.WriteString("<td>Log Likelihood</td>")
+	buffer.WriteString("<td>AIC</td>")
+	buffer.WriteString("<td>Accuracy</td>")
+	buffer.WriteString("<td>AUC</td>")
+	if s.Method == LassoSelection {
+		buffer.WriteString("<td>Lambda</td>")
+	} else {
+		buffer.WriteString("<td>Selected</td>")
+	}
+	buffer.WriteString("</tr>")
+
+	for i, candidate := range s.Candidates {
+		names := "Intercept only"
+		if len(candidate.Names) > 0 {
+			names = strings.Join(candidate.Names, ", ")
+		}
+
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(i + 1))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(names))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(candidate.Coefficients))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(candidate.LogLikelihood, 'f', 6, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(candidate.AIC, 'f', 6, 64))
+		buffer.WriteString("</td>")
+
+		buffer.WriteString("<td>")
+		if candidate.HasEvaluation {
+			buffer.WriteString(strconv.FormatFloat(candidate.Evaluation.Accuracy, 'f', 2, 64))
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		if candidate.HasEvaluation {
+			buffer.WriteString(strconv.FormatFloat(candidate.Evaluation.AUC, 'f', 4, 64))
+		}
+		buffer.WriteString("</td>")
+
+		buffer.WriteString("<td>")
+		if s.Method == LassoSelection {
+			buffer.WriteString(strconv.FormatFloat(candidate.Lambda, 'g', 4, 64))
+		} else if candidate.Selected {
+			buffer.WriteString("Yes")
+		}
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	if s.Path != nil {
+		buffer.WriteString(s.Path.StringHTML())
+	}
+
+	return buffer.String()
+}
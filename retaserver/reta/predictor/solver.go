@@ -0,0 +1,416 @@
+package predictor
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/skelterjohn/go.matrix"
+)
+
+//Streaming solvers for large datasets
+//
+//Newton-Raphson builds the numData x (variables + 1) design matrix and inverts X'WX on every iteration.
+//These solvers only keep the coefficients and a few vectors of the same length, the design variables of
+//every data point are computed on each pass over the data. Columns are standardized internally using streamed
+//means and standard deviations so a single step size fits all of them, coefficients are transformed back after.
+//Standard errors come from X'WX accumulated in one last pass which is only (variables + 1)^2 in size.
+//
+// - Mini-batch SGD: Adam updates on shuffled mini-batches, one iteration is one epoch over the data
+// - L-BFGS: quasi-Newton steps from the last updates of the full streamed gradient with a backtracking line search
+//
+//Both minimize the weighted mean negative log likelihood and stop when the gradient norm or the change of
+//the log likelihood falls below the tolerance.
+//
+//References:
+// - Kingma, D. and Ba, J. (2015). Adam: A Method for Stochastic Optimization. ICLR
+// - Nocedal, J. and Wright, S. (2006). Numerical Optimization, chapter 7
+
+const (
+	sgdBatchSize      = 256
+	sgdLearningRate   = 0.1  //Divided by the square root of the epoch
+	lbfgsMemory       = 10   //Number of updates kept
+	solverTolerance   = 1e-8 //Relative change of the log likelihood
+	sgdTolerance      = 1e-6 //Looser for SGD as the mini-batches make the log likelihood noisy
+	gradientTolerance = 1e-5 //Norm of the mean gradient
+)
+
+//Mean and standard deviation of every design column, the intercept is left as it is
+type solverScaling struct {
+	means      []float64
+	deviations []float64
+}
+
+//Result of one pass over all data points
+type solverPass struct {
+	logLikelihood float64
+	mse           float64
+	loss          float64   //Weighted mean negative log likelihood
+	gradient      []float64 //Gradient of the loss
+}
+
+//Fit coefficients with the streaming solver of the fitting mode
+func (r *Regression) computeStreamingCoefficients(maxIteration int) error {
+	numColumns := len(r.designNames()) + 1
+	scaling := r.streamScaling(numColumns)
+
+	var coefficients []float64
+	if r.fittingMode == LBFGSFitting {
+		coefficients = r.lbfgsCoefficients(scaling, numColumns, maxIteration)
+	} else {
+		coefficients = r.sgdCoefficients(scaling, numColumns, maxIteration)
+	}
+
+	//Back to the unstandardized design columns: b[j] = c[j] / s[j], b[0] = c[0] - Sum(c[j] * m[j] / s[j])
+	r.model.Coefficients = make([]float64, numColumns)
+	r.model.Coefficients[0] = coefficients[0]
+	for j := 1; j < numColumns; j++ {
+		r.model.Coefficients[j] = coefficients[j] / scaling.deviations[j]
+		r.model.Coefficients[0] -= coefficients[j] * scaling.means[j] / scaling.deviations[j]
+	}
+
+	if r.debugMode {
+		convergence := r.model.Convergence
+		r.debugContext.Infof("\n%v: %v after %v iterations\nCoefficients: %v", convergence.Solver, convergence.Reason, convergence.Iterations, r.model.Coefficients)
+	}
+
+	r.computeStreamingCovariance(numColumns)
+
+	return nil
+}
+
+func (r *Regression) streamScaling(numColumns int) solverScaling {
+	scaling := solverScaling{means: make([]float64, numColumns), deviations: make([]float64, numColumns)}
+
+	total := 0.0
+	for _, data := range r.dataPoints {
+		for j, val := range r.designVariables(data) {
+			scaling.means[j+1] += data.weight() * val
+		}
+		total += data.weight()
+	}
+	for j := range scaling.means {
+		scaling.means[j] /= total
+	}
+
+	for _, data := range r.dataPoints {
+		for j, val := range r.designVariables(data) {
+			scaling.deviations[j+1] += data.weight() * (val - scaling.means[j+1]) * (val - scaling.means[j+1])
+		}
+	}
+
+	//Constant columns are only centered
+	scaling.means[0] = 0.0
+	for j := range scaling.deviations {
+		scaling.deviations[j] = math.Sqrt(scaling.deviations[j] / total)
+		if j == 0 || scaling.deviations[j] == 0 {
+			scaling.deviations[j] = 1.0
+		}
+	}
+
+	return scaling
+}
+
+//Standardized design row of the data point with the intercept column
+func (r *Regression) solverRow(data DataPoint, scaling solverScaling) []float64 {
+	variables := r.designVariables(data)
+
+	row := make([]float64, len(variables)+1)
+	row[0] = 1.0
+	for j, val := range variables {
+		row[j+1] = (val - scaling.means[j+1]) / scaling.deviations[j+1]
+	}
+
+	return row
+}
+
+//Log likelihood, MSE and gradient of the standardized coefficients over all data points
+func (r *Regression) streamPass(coefficients []float64, scaling solverScaling) solverPass {
+	pass := solverPass{gradient: make([]float64, len(coefficients))}
+
+	total := 0.0
+	for _, data := range r.dataPoints {
+		row := r.solverRow(data, scaling)
+		p := sigmoid(dot(coefficients, row))
+		weight := data.weight()
+
+		//Clamp so the log likelihood stays finite
+		clamped := math.Min(math.Max(p, 1e-15), 1.0-1e-15)
+		pass.logLikelihood += weight * (data.Result*math.Log(clamped) + (1.0-data.Result)*math.Log(1.0-clamped))
+		pass.mse += weight * (p - data.Result) * (p - data.Result)
+
+		for j, val := range row {
+			pass.gradient[j] -= weight * (data.Result - p) * val
+		}
+		total += weight
+	}
+
+	pass.loss = -pass.logLikelihood / total
+	pass.mse /= total
+	for j := range pass.gradient {
+		pass.gradient[j] /= total
+	}
+
+	return pass
+}
+
+func (r *Regression) sgdCoefficients(scaling solverScaling, numColumns int, maxIteration int) []float64 {
+	coefficients := make([]float64, numColumns)
+	firstMoment := make([]float64, numColumns)
+	secondMoment := make([]float64, numColumns)
+
+	//Running mean of the coefficients after the first epoch, smooths out the mini-batch noise
+	average := make([]float64, numColumns)
+	averaged := 0
+
+	//Fixed seed so the same data gives the same model
+	random := rand.New(rand.NewSource(1))
+
+	previous := r.streamPass(coefficients, scaling)
+	convergence := Convergence{Solver: SGDFitting, Reason: MaxIterationsReached}
+	convergence.Trace = append(convergence.Trace, previous.step(0))
+
+	step := 0
+	numData := len(r.dataPoints)
+	for epoch := 0; epoch < maxIteration; epoch++ {
+		learningRate := sgdLearningRate / math.Sqrt(float64(epoch+1))
+		order := random.Perm(numData)
+
+		for start := 0; start < numData; start += sgdBatchSize {
+			end := start + sgdBatchSize
+			if end > numData {
+				end = numData
+			}
+
+			//Mean gradient of the mini-batch
+			gradient := make([]float64, numColumns)
+			total := 0.0
+			for _, i := range order[start:end] {
+				data := r.dataPoints[i]
+				row := r.solverRow(data, scaling)
+				p := sigmoid(dot(coefficients, row))
+				for j, val := range row {
+					gradient[j] -= data.weight() * (data.Result - p) * val
+				}
+				total += data.weight()
+			}
+
+			//Adam update with bias corrected moments
+			step++
+			for j := range coefficients {
+				g := gradient[j] / total
+				firstMoment[j] = 0.9*firstMoment[j] + 0.1*g
+				secondMoment[j] = 0.999*secondMoment[j] + 0.001*g*g
+				m := firstMoment[j] / (1.0 - math.Pow(0.9, float64(step)))
+				v := secondMoment[j] / (1.0 - math.Pow(0.999, float64(step)))
+				coefficients[j] -= learningRate * m / (math.Sqrt(v) + 1e-8)
+			}
+
+			if epoch > 0 {
+				averaged++
+				for j := range average {
+					average[j] += (coefficients[j] - average[j]) / float64(averaged)
+				}
+			}
+		}
+
+		if epoch == 0 {
+			copy(average, coefficients)
+		}
+
+		pass := r.streamPass(average, scaling)
+		convergence.Iterations = epoch + 1
+		convergence.Trace = append(convergence.Trace, pass.step(epoch+1))
+
+		if reason, stop := solverStop(previous, pass, sgdTolerance); stop {
+			convergence.Reason = reason
+			break
+		}
+		previous = pass
+	}
+
+	r.model.Convergence = convergence
+
+	return average
+}
+
+func (r *Regression) lbfgsCoefficients(scaling solverScaling, numColumns int, maxIteration int) []float64 {
+	coefficients := make([]float64, numColumns)
+	current := r.streamPass(coefficients, scaling)
+
+	//Last updates of the coefficients and of the gradient
+	var updates, gradientUpdates [][]float64
+
+	convergence := Convergence{Solver: LBFGSFitting, Reason: MaxIterationsReached}
+	convergence.Trace = append(convergence.Trace, current.step(0))
+	for iteration := 0; iteration < maxIteration; iteration++ {
+		direction := lbfgsDirection(current.gradient, updates, gradientUpdates)
+		slope := dot(direction, current.gradient)
+		if slope >= 0 {
+			//Not a descent direction, restart from steepest descent
+			updates, gradientUpdates = nil, nil
+			direction = scale(current.gradient, -1.0)
+			slope = dot(direction, current.gradient)
+		}
+
+		//Backtracking line search with the Armijo condition
+		stepSize := 1.0
+		if len(updates) == 0 {
+			stepSize = 1.0 / math.Max(1.0, norm(current.gradient))
+		}
+
+		var candidate []float64
+		var next solverPass
+		accepted := false
+		for halving := 0; halving < 30; halving++ {
+			candidate = make([]float64, numColumns)
+			for j := range candidate {
+				candidate[j] = coefficients[j] + stepSize*direction[j]
+			}
+
+			next = r.streamPass(candidate, scaling)
+			if next.loss <= current.loss+1e-4*stepSize*slope {
+				accepted = true
+				break
+			}
+			stepSize /= 2.0
+		}
+
+		if !accepted {
+			convergence.Reason = LineSearchFailed
+			break
+		}
+
+		update := make([]float64, numColumns)
+		gradientUpdate := make([]float64, numColumns)
+		for j := range update {
+			update[j] = candidate[j] - coefficients[j]
+			gradientUpdate[j] = next.gradient[j] - current.gradient[j]
+		}
+
+		//Only keep updates where the curvature is positive
+		if dot(update, gradientUpdate) > 1e-12 {
+			updates = append(updates, update)
+			gradientUpdates = append(gradientUpdates, gradientUpdate)
+			if len(updates) > lbfgsMemory {
+				updates = updates[1:]
+				gradientUpdates = gradientUpdates[1:]
+			}
+		}
+
+		coefficients = candidate
+		convergence.Iterations = iteration + 1
+		convergence.Trace = append(convergence.Trace, next.step(iteration+1))
+
+		reason, stop := solverStop(current, next, solverTolerance)
+		current = next
+		if stop {
+			convergence.Reason = reason
+			break
+		}
+	}
+
+	r.model.Convergence = convergence
+
+	return coefficients
+}
+
+//Two-loop recursion: approximate inverse Hessian times the negative gradient
+func lbfgsDirection(gradient []float64, updates [][]float64, gradientUpdates [][]float64) []float64 {
+	direction := scale(gradient, -1.0)
+
+	count := len(updates)
+	alphas := make([]float64, count)
+	for k := count - 1; k >= 0; k-- {
+		rho := 1.0 / dot(gradientUpdates[k], updates[k])
+		alphas[k] = rho * dot(updates[k], direction)
+		for j := range direction {
+			direction[j] -= alphas[k] * gradientUpdates[k][j]
+		}
+	}
+
+	//Initial Hessian scaled by the latest curvature
+	if count > 0 {
+		gamma := dot(updates[count-1], gradientUpdates[count-1]) / dot(gradientUpdates[count-1], gradientUpdates[count-1])
+		direction = scale(direction, gamma)
+	}
+
+	for k := 0; k < count; k++ {
+		rho := 1.0 / dot(gradientUpdates[k], updates[k])
+		beta := rho * dot(gradientUpdates[k], direction)
+		for j := range direction {
+			direction[j] += (alphas[k] - beta) * updates[k][j]
+		}
+	}
+
+	return direction
+}
+
+//Reason to stop after a pass, false to continue
+func solverStop(previous solverPass, current solverPass, tolerance float64) (StopReason, bool) {
+	if math.IsNaN(current.loss) || math.IsInf(current.loss, 0) {
+		return Diverged, true
+	}
+
+	if norm(current.gradient) < gradientTolerance {
+		return GradientConverged, true
+	}
+
+	if math.Abs(current.loss-previous.loss) <= tolerance*(math.Abs(previous.loss)+1.0) {
+		return LikelihoodConverged, true
+	}
+
+	return MaxIterationsReached, false
+}
+
+func (p solverPass) step(iteration int) ConvergenceStep {
+	return ConvergenceStep{Iteration: iteration, LogLikelihood: p.logLikelihood, MSE: p.mse, GradientNorm: norm(p.gradient)}
+}
+
+//Accumulate X'WX one data point at a time and keep its inverse as the covariance
+func (r *Regression) computeStreamingCovariance(numColumns int) {
+	information := matrix.Zeros(numColumns, numColumns)
+	for _, data := range r.dataPoints {
+		variables := r.designVariables(data)
+		row := append([]float64{1.0}, variables...)
+
+		p := sigmoid(r.linearPredictor(variables))
+		weight := data.weight() * p * (1.0 - p)
+		for j := 0; j < numColumns; j++ {
+			for k := 0; k < numColumns; k++ {
+				information.Set(j, k, information.Get(j, k)+weight*row[j]*row[k])
+			}
+		}
+	}
+
+	covariance := matrix.Inverse(information)
+	if covariance == nil {
+		if r.debugMode {
+			r.debugContext.Infof("\nInformation matrix cannot be inverted -- standard errors are not available")
+		}
+		return
+	}
+
+	r.saveCovariance(covariance)
+}
+
+func dot(a []float64, b []float64) float64 {
+	result := 0.0
+	for i := range a {
+		result += a[i] * b[i]
+	}
+
+	return result
+}
+
+func norm(a []float64) float64 {
+	return math.Sqrt(dot(a, a))
+}
+
+func scale(a []float64, factor float64) []float64 {
+	result := make([]float64, len(a))
+	for i, val := range a {
+		result[i] = factor * val
+	}
+
+	return result
+}
@@ -0,0 +1,348 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"strconv"
+	"strings"
+
+	"appengine"
+
+	"reta/errors"
+)
+
+//Churn stage of a player from its events
+//
+//Stages are listed in the order players go through them, separated by ";":
+//	Pre-Tutorial; Tutorial = Game Feature Consumed; Early Levels = Tutorial Duration; Mid-Game = level >= 3
+//
+// - Name                 first stage, every player starts there
+// - Name = Action        reached with at least one event or timed event of the action
+// - Name = Action >= n   reached with at least n events of the action
+// - Name = level >= n    reached at level n or higher
+//
+//A player is labelled with the furthest stage reached, which is where the player dropped when churned. Only
+//players not retained on day 1 are labelled, and the variables computed from the events of the rules are left
+//out of the model since they define the label.
+
+const DefaultStages = "Pre-Tutorial; Tutorial = Game Feature Consumed; Early Levels = Tutorial Duration; Mid-Game = level >= 3"
+
+type StageRule struct {
+	Name     string
+	Action   string //Event action counted, empty when the rule uses the level
+	MinCount int
+	MinLevel int
+}
+
+type StageLabeller struct {
+	Rules []StageRule //First rule has no condition
+}
+
+func ParseStages(spec string) (StageLabeller, error) {
+	var labeller StageLabeller
+
+	for i, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var rule StageRule
+		equal := strings.Index(part, "=")
+		if equal == -1 {
+			rule.Name = part
+		} else {
+			rule.Name = strings.TrimSpace(part[:equal])
+			condition := strings.TrimSpace(part[equal+1:])
+
+			//Optional minimum count after ">="
+			count := 1
+			if index := strings.Index(condition, ">="); index != -1 {
+				value, err := strconv.Atoi(strings.TrimSpace(condition[index+2:]))
+				if err != nil || value < 1 {
+					return labeller, errors.New("Error: Stage '" + rule.Name + "' needs a positive number after '>='")
+				}
+				count = value
+				condition = strings.TrimSpace(condition[:index])
+			}
+
+			if strings.ToLower(condition) == "level" {
+				rule.MinLevel = count
+			} else {
+				rule.Action = condition
+				rule.MinCount = count
+			}
+
+			if condition == "" {
+				return labeller, errors.New("Error: Stage '" + rule.Name + "' has no event action")
+			}
+		}
+
+		if rule.Name == "" {
+			return labeller, errors.New("Error: Stage " + strconv.Itoa(i+1) + " has no name")
+		}
+		if len(labeller.Rules) == 0 && equal != -1 {
+			return labeller, errors.New("Error: First stage '" + rule.Name + "' cannot have a condition")
+		}
+		if len(labeller.Rules) > 0 && equal == -1 {
+			return labeller, errors.New("Error: Stage '" + rule.Name + "' needs a condition")
+		}
+		for _, other := range labeller.Rules {
+			if other.Name == rule.Name {
+				return labeller, errors.New("Error: Stage '" + rule.Name + "' is listed twice")
+			}
+		}
+
+		labeller.Rules = append(labeller.Rules, rule)
+	}
+
+	if len(labeller.Rules) < 3 {
+		return labeller, errors.New("Error: Need at least three stages, use retention for two")
+	}
+
+	return labeller, nil
+}
+
+func (s StageLabeller) Names() []string {
+	names := make([]string, len(s.Rules))
+	for i, rule := range s.Rules {
+		names[i] = rule.Name
+	}
+
+	return names
+}
+
+//Index of the furthest stage reached by the player
+func (s StageLabeller) Label(info PlayerInfo) int {
+	stage := 0
+	for i := 1; i < len(s.Rules); i++ {
+		rule := s.Rules[i]
+		if rule.Action != "" && info.Actions[rule.Action] >= rule.MinCount {
+			stage = i
+		} else if rule.Action == "" && info.Level >= rule.MinLevel {
+			stage = i
+		}
+	}
+
+	return stage
+}
+
+//Player variables computed from the events of the rules, level rules use the progression
+func (s StageLabeller) labelVariables() []string {
+	var names []string
+	for _, rule := range s.Rules[1:] {
+		action := rule.Action
+		if action == "" {
+			action = "Game Progression"
+		}
+
+		for _, name := range actionVariables[action] {
+			found := false
+			for _, other := range names {
+				found = found || other == name
+			}
+			if !found {
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
+
+//Players not retained on day 1, the stage they reached is where they dropped
+func churnedPlayers(infos []PlayerInfo) []PlayerInfo {
+	var churned []PlayerInfo
+	for _, info := range infos {
+		if !info.Day1Retention {
+			churned = append(churned, info)
+		}
+	}
+
+	return churned
+}
+
+//Data points of the players with the stage index as the result
+func stageDataPoints(infos []PlayerInfo, labeller StageLabeller, categoricals []string) []DataPoint {
+	datapoints := playerDataPoints(infos, categoricals)
+	for i, info := range infos {
+		datapoints[i].Result = float64(labeller.Label(info))
+	}
+
+	return datapoints
+}
+
+func (p *Predictor) stageMode() bool {
+	return len(p.stages.Rules) > 0
+}
+
+//Multinomial regression of the churn stages from the players, logged when debug is set
+func (p *Predictor) generateStageModel(c appengine.Context, infos []PlayerInfo, debug bool) (*Regression, error) {
+	regress, err := p.newRegression()
+	if err != nil {
+		return nil, err
+	}
+
+	regress.SetObservedName("Churn Stage")
+	regress.SetClassNames(p.stages.Names())
+
+	//Only terms without the variables defining the stages
+	if !regress.interceptOnly {
+		excluded := make(map[int]bool)
+		for _, name := range p.stages.labelVariables() {
+			for j, variable := range playerVariableNames {
+				if variable == name {
+					excluded[j] = true
+				}
+			}
+		}
+
+		var terms []Term
+		for _, term := range regress.selectionTerms() {
+			keep := true
+			for _, index := range term.Variables {
+				keep = keep && !excluded[index]
+			}
+			if keep {
+				terms = append(terms, term)
+			}
+		}
+
+		regress.terms = terms
+		regress.interceptOnly = len(terms) == 0
+	}
+
+	if debug {
+		regress.EnableDebugMode(c)
+	}
+
+	for _, datapoint := range stageDataPoints(infos, p.stages, p.categoricalNames()) {
+		err = regress.AddDataPoint(datapoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = regress.GenerateModel(p.iteration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &regress, nil
+}
+
+//Stage distribution, model and pooled confusion matrix of the testing folds as HTML
+func (p *Predictor) runStagePrediction(c appengine.Context, playerinfos []PlayerInfo, folds []Fold) string {
+	var buffer bytes.Buffer
+
+	if len(playerinfos) == 0 {
+		return "Error: No churned player in the selected dates"
+	}
+
+	names := p.stages.Names()
+
+	//Players of every stage
+	counts := make([]int, len(names))
+	for _, info := range playerinfos {
+		counts[p.stages.Label(info)]++
+	}
+
+	buffer.WriteString("<div>Churned Players (not retained on day 1): ")
+	buffer.WriteString(strconv.Itoa(len(playerinfos)))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<div>Left out of the model as they define the stages: ")
+	buffer.WriteString(html.EscapeString(strings.Join(p.stages.labelVariables(), ", ")))
+	buffer.WriteString("</div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Stage</td>")
+	buffer.WriteString("<td>Players</td>")
+	buffer.WriteString("<td>Percentage</td>")
+	buffer.WriteString("</tr>")
+	for k, name := range names {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.Itoa(counts[k]))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(100.0*float64(counts[k])/float64(len(playerinfos)), 'f', 2, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+	buffer.WriteString("</table>")
+
+	if p.method != LogisticRegressionMethod || p.fittingMode != NewtonRaphsonFitting || p.imbalanceMode != NoBalancing {
+		buffer.WriteString("<div>Churn stages always use multinomial logistic regression with Newton-Raphson, without class balancing</div>")
+	}
+
+	//Train and test every fold
+	var regress *Regression
+	var evaluations []ClassEvaluation
+	notConverged := 0
+	for i, fold := range folds {
+		if len(fold.Testing) == 0 {
+			return "Error: Testing data is empty, add more players or decrease training percentage"
+		}
+
+		model, err := p.generateStageModel(c, fold.Training, i == 0 && !p.crossValidation())
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		evaluation, err := model.EvaluateClasses(stageDataPoints(fold.Testing, p.stages, p.categoricalNames()))
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		evaluations = append(evaluations, evaluation)
+		if !model.Convergence().Converged() {
+			notConverged++
+		}
+
+		if i == 0 {
+			regress = model
+		}
+	}
+
+	if p.crossValidation() {
+		//Model shown is generated from all players, folds are used for the metrics
+		var err error
+		regress, err = p.generateStageModel(c, playerinfos, true)
+		if err != nil {
+			return html.EscapeString(err.Error())
+		}
+
+		buffer.WriteString("<div>Model generated from all players, metrics from ")
+		buffer.WriteString(strconv.Itoa(len(folds)))
+		buffer.WriteString(" testing folds</div>")
+	} else {
+		buffer.WriteString("<div>Training vs Testing: ")
+		buffer.WriteString(strconv.Itoa(len(folds[0].Training)))
+		buffer.WriteString(" vs ")
+		buffer.WriteString(strconv.Itoa(len(folds[0].Testing)))
+		buffer.WriteString("</div>")
+	}
+	buffer.WriteString("<br/>")
+
+	buffer.WriteString(regress.StringHTML())
+
+	buffer.WriteString("<br/><div><h3>Confusion Matrix (testing data of every fold)</h3></div>")
+	buffer.WriteString(classEvaluationHTML(poolClassEvaluations(evaluations)))
+
+	if p.compareMethods || p.bootstrapSamples > 0 || p.selectionMethod != NoSelection {
+		buffer.WriteString("<div>Method comparison, bootstrap intervals and feature selection are only available for day-1 retention</div>")
+	}
+
+	if notConverged > 0 {
+		buffer.WriteString("<div><strong>Warning: ")
+		buffer.WriteString(strconv.Itoa(notConverged))
+		buffer.WriteString(" of ")
+		buffer.WriteString(strconv.Itoa(len(folds)))
+		buffer.WriteString(" fold models did not converge</strong></div>")
+	}
+
+	return buffer.String()
+}
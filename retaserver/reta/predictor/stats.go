@@ -0,0 +1,130 @@
+package predictor
+
+import (
+	"math"
+)
+
+//Statistical distribution helpers
+//
+//References:
+// - Numerical Recipes in C, 6.2 Incomplete Gamma Function
+
+//p-Value of a chi-square statistic with df degrees of freedom: P(X >= x) = Q(df/2, x/2)
+func chiSquarePValue(x float64, df int) float64 {
+	if df <= 0 || math.IsNaN(x) {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return 1.0
+	}
+
+	return 1.0 - regularizedGammaP(float64(df)/2.0, x/2.0)
+}
+
+//Regularized lower incomplete gamma function P(a, x)
+func regularizedGammaP(a float64, x float64) float64 {
+	if x <= 0 {
+		return 0.0
+	}
+
+	lgamma, _ := math.Lgamma(a)
+
+	if x < a+1.0 {
+		//Series representation
+		sum := 1.0 / a
+		term := sum
+		ap := a
+		for n := 0; n < 500; n++ {
+			ap += 1.0
+			term *= x / ap
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*1e-14 {
+				break
+			}
+		}
+
+		return sum * math.Exp(-x+a*math.Log(x)-lgamma)
+	}
+
+	//Continued fraction representation using modified Lentz's method
+	tiny := 1e-300
+	b := x + 1.0 - a
+	c := 1.0 / tiny
+	d := 1.0 / b
+	h := d
+	for n := 1; n < 500; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2.0
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1.0 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1.0) < 1e-14 {
+			break
+		}
+	}
+
+	return 1.0 - math.Exp(-x+a*math.Log(x)-lgamma)*h
+}
+
+//Cumulative distribution function of standard normal distribution
+func normalCDF(x float64) float64 {
+	return 0.5 * (1.0 + math.Erf(x/math.Sqrt2))
+}
+
+//Inverse of standard normal CDF using Acklam's rational approximation
+func normalQuantile(p float64) float64 {
+	if p <= 0 {
+		return math.Inf(-1)
+	}
+	if p >= 1 {
+		return math.Inf(1)
+	}
+
+	a := []float64{-3.969683028665376e+01, 2.209460984245205e+02, -2.759285104469687e+02, 1.383577518672690e+02, -3.066479806614716e+01, 2.506628277459239e+00}
+	b := []float64{-5.447609879822406e+01, 1.615858368580409e+02, -1.556989798598866e+02, 6.680131188771972e+01, -1.328068155288572e+01}
+	c := []float64{-7.784894002430293e-03, -3.223964580411365e-01, -2.400758277161838e+00, -2.549732539343734e+00, 4.374664141464968e+00, 2.938163982698783e+00}
+	d := []float64{7.784695709041462e-03, 3.224671290700398e-01, 2.445134137142996e+00, 3.754408661907416e+00}
+
+	low := 0.02425
+	if p < low {
+		q := math.Sqrt(-2 * math.Log(p))
+		return (((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	}
+	if p > 1-low {
+		q := math.Sqrt(-2 * math.Log(1-p))
+		return -(((((c[0]*q+c[1])*q+c[2])*q+c[3])*q+c[4])*q + c[5]) / ((((d[0]*q+d[1])*q+d[2])*q+d[3])*q + 1)
+	}
+
+	q := p - 0.5
+	r := q * q
+	return (((((a[0]*r+a[1])*r+a[2])*r+a[3])*r+a[4])*r + a[5]) * q / (((((b[0]*r+b[1])*r+b[2])*r+b[3])*r+b[4])*r + 1)
+}
+
+//Value at the given quantile of sorted values using linear interpolation
+func quantile(sorted []float64, q float64) float64 {
+	length := len(sorted)
+	if length == 0 {
+		return math.NaN()
+	}
+
+	position := q * float64(length-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+	if lower < 0 {
+		return sorted[0]
+	}
+	if upper >= length {
+		return sorted[length-1]
+	}
+
+	fraction := position - float64(lower)
+	return sorted[lower] + fraction*(sorted[upper]-sorted[lower])
+}
@@ -0,0 +1,220 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"appengine"
+)
+
+//Survival analysis of the player lifetime
+//
+//Lifetime is the number of whole days between the first and last event of a player. A player is churned
+//when there was no event in the churn window before the end date, otherwise the lifetime is censored because
+//the player may still come back. Kaplan-Meier curves are estimated for each segment and a Cox model is fitted
+//on the same player variables as the retention model.
+
+type SurvivalSegment int
+
+const (
+	NoSegment       SurvivalSegment = iota //One curve of all players
+	VersionSegment                         //One curve per app version
+	TutorialSegment                        //Players with and without the tutorial event
+)
+
+func (s SurvivalSegment) String() string {
+	switch s {
+	case VersionSegment:
+		return "App Version"
+	case TutorialSegment:
+		return "Tutorial Completed"
+	default:
+		return "All Players"
+	}
+}
+
+//Lifetime of a player with the variables of its data point
+type SurvivalPoint struct {
+	Lifetime   float64 //Days from first to last event
+	Churned    bool    //False when censored
+	Segment    string
+	Variables  []float64
+	Categories []string
+	Missing    []bool
+}
+
+type SurvivalAnalysis struct {
+	beginDate time.Time
+	endDate   time.Time
+	churnDays int
+	segment   SurvivalSegment
+	iteration int
+}
+
+func (s *SurvivalAnalysis) SetInputDates(begin time.Time, end time.Time) {
+	s.beginDate = begin
+	s.endDate = end
+}
+
+//Days without event before the end date for a player to be churned, zero means 7
+func (s *SurvivalAnalysis) SetChurnWindow(days int) {
+	s.churnDays = days
+}
+
+func (s *SurvivalAnalysis) SetSegment(segment SurvivalSegment) {
+	s.segment = segment
+}
+
+//Maximum Newton-Raphson iterations of the Cox model, zero means 30
+func (s *SurvivalAnalysis) SetIteration(num int) {
+	s.iteration = num
+}
+
+//Lifetime and churn of every player at the end date
+func (s *SurvivalAnalysis) survivalPoints(infos []PlayerInfo) []SurvivalPoint {
+	churnDays := s.churnDays
+	if churnDays <= 0 {
+		churnDays = 7
+	}
+
+	points := make([]SurvivalPoint, len(infos))
+	for i, info := range infos {
+		data := playerDataPoint(info, playerCategoricalNames)
+
+		points[i] = SurvivalPoint{
+			Lifetime:   math.Floor(info.LastDate.Sub(info.FirstDate).Hours() / 24.0),
+			Churned:    s.endDate.Sub(info.LastDate).Hours() >= float64(churnDays)*24.0,
+			Segment:    s.segmentName(info),
+			Variables:  data.Variables,
+			Categories: data.Categories,
+			Missing:    data.Missing,
+		}
+	}
+
+	return points
+}
+
+func (s *SurvivalAnalysis) segmentName(info PlayerInfo) string {
+	switch s.segment {
+	case VersionSegment:
+		return "Version " + info.Version
+	case TutorialSegment:
+		if info.MissingTutorial {
+			return "No Tutorial"
+		}
+		return "Tutorial Completed"
+	}
+
+	return NoSegment.String()
+}
+
+//Players of each segment sorted by segment name
+func splitSegments(points []SurvivalPoint) ([]string, [][]SurvivalPoint) {
+	groups := make(map[string][]SurvivalPoint)
+	var names []string
+	for _, point := range points {
+		if _, ok := groups[point.Segment]; !ok {
+			names = append(names, point.Segment)
+		}
+		groups[point.Segment] = append(groups[point.Segment], point)
+	}
+	sort.Strings(names)
+
+	segments := make([][]SurvivalPoint, len(names))
+	for i, name := range names {
+		segments[i] = groups[name]
+	}
+
+	return names, segments
+}
+
+//1. Get all user data from begin to end dates
+//2. Build lifetime and churn of every player
+//3. Estimate Kaplan-Meier curves of every segment and test their difference
+//4. Fit Cox proportional-hazards model on the player variables
+//5. Return curves and model as HTML
+func (s *SurvivalAnalysis) RunSurvival(c appengine.Context) string {
+	var buffer bytes.Buffer
+
+	churnDays := s.churnDays
+	if churnDays <= 0 {
+		churnDays = 7
+	}
+
+	//Header
+	buffer.WriteString("<header>")
+	buffer.WriteString("<h2>Player Lifetime Survival Analysis</h2>")
+	buffer.WriteString("<span>Players from ")
+	buffer.WriteString(s.beginDate.String())
+	buffer.WriteString(" to ")
+	buffer.WriteString(s.endDate.String())
+	buffer.WriteString(", churned after ")
+	buffer.WriteString(strconv.Itoa(churnDays))
+	buffer.WriteString(" days without event</span></header>")
+
+	//Get playerinfo
+	var playerinfos []PlayerInfo
+	_, err := GetPlayerInformation(c, s.beginDate, s.endDate, &playerinfos)
+	if err != nil {
+		return html.EscapeString(err.Error())
+	}
+
+	if len(playerinfos) == 0 {
+		return "Error: No player in the selected dates"
+	}
+
+	points := s.survivalPoints(playerinfos)
+	c.Debugf("Total Survival Players:\n%v\n", len(points))
+
+	//Kaplan-Meier curves, all players first
+	curves := []KaplanMeier{kaplanMeier(NoSegment.String(), points)}
+	names, segments := splitSegments(points)
+	if s.segment != NoSegment {
+		for i, name := range names {
+			curves = append(curves, kaplanMeier(name, segments[i]))
+		}
+	}
+
+	buffer.WriteString("<br/><div><h3>Kaplan-Meier Survival by ")
+	buffer.WriteString(s.segment.String())
+	buffer.WriteString("</h3></div>")
+	buffer.WriteString(kaplanMeierSVG(curves))
+	buffer.WriteString(kaplanMeierHTML(curves))
+
+	if s.segment != NoSegment && len(segments) > 1 {
+		chiSquare, df, pValue := logRankTest(segments)
+
+		buffer.WriteString("<div>Log-Rank Test: ")
+		buffer.WriteString(strconv.FormatFloat(chiSquare, 'f', 4, 64))
+		buffer.WriteString(" on ")
+		buffer.WriteString(strconv.Itoa(df))
+		buffer.WriteString(" df, p-Value ")
+		buffer.WriteString(strconv.FormatFloat(pValue, 'f', 6, 64))
+		buffer.WriteString("</div>")
+	}
+
+	//Cox model on the player variables
+	iteration := s.iteration
+	if iteration <= 0 {
+		iteration = 30
+	}
+
+	var cox CoxModel
+	cox.SetFeatureNames(playerVariableNames, playerCategoricalNames)
+	err = cox.Fit(points, iteration)
+
+	buffer.WriteString("<br/><div><h3>Cox Proportional-Hazards Model (hazard of churning)</h3></div>")
+	if err != nil {
+		buffer.WriteString("<div>")
+		buffer.WriteString(html.EscapeString(err.Error()))
+		buffer.WriteString("</div>")
+	} else {
+		buffer.WriteString(cox.StringHTML())
+	}
+
+	return buffer.String()
+}
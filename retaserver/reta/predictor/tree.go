@@ -0,0 +1,302 @@
+package predictor
+
+import (
+	"bytes"
+	"html"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+//Classification tree (CART) using weighted Gini impurity
+//
+//Every node is split on the feature and threshold which decreases the impurity the most, until the maximum
+//depth, the minimum leaf weight or a pure node is reached. Leaves give the weighted fraction of retained players.
+//
+//References:
+// - Breiman, L., Friedman, J., Olshen, R. and Stone, C. (1984). Classification and Regression Trees
+
+type DecisionTree struct {
+	featureEncoder
+	MaxDepth    int        //Zero means 5
+	MinLeaf     float64    //Minimum weight of a leaf, zero means 5
+	MaxFeatures int        //Number of random features tried on every split, zero means all of them
+	Random      *rand.Rand //Used to pick the features when MaxFeatures is set
+
+	root       *treeNode
+	importance []float64 //Impurity decrease of each feature
+}
+
+type treeNode struct {
+	leaf        bool
+	feature     int
+	threshold   float64 //Left when feature <= threshold
+	left        *treeNode
+	right       *treeNode
+	probability float64 //Weighted fraction of retained players
+	value       float64 //Log-odds step of a boosting leaf
+	weight      float64
+}
+
+func (t *DecisionTree) Fit(trainingData []DataPoint) error {
+	err := t.fitFeatures(trainingData)
+	if err != nil {
+		return err
+	}
+
+	x := make([][]float64, len(trainingData))
+	y := make([]float64, len(trainingData))
+	w := make([]float64, len(trainingData))
+	indexes := make([]int, len(trainingData))
+	for i, data := range trainingData {
+		x[i] = t.encode(data)
+		y[i] = data.Result
+		w[i] = data.weight()
+		indexes[i] = i
+	}
+
+	t.growTree(x, y, w, indexes)
+
+	return nil
+}
+
+//Grow the tree from already encoded features of the given rows
+func (t *DecisionTree) growTree(x [][]float64, y []float64, w []float64, indexes []int) {
+	if t.MaxDepth <= 0 {
+		t.MaxDepth = 5
+	}
+	if t.MinLeaf <= 0 {
+		t.MinLeaf = 5
+	}
+	t.Random = classifierRandom(t.Random)
+
+	t.importance = make([]float64, len(t.featureNames()))
+	t.root = t.growNode(x, y, w, indexes, 0)
+
+	//Normalize importance to sum to 1
+	total := 0.0
+	for _, val := range t.importance {
+		total += val
+	}
+	if total > 0 {
+		for f := range t.importance {
+			t.importance[f] /= total
+		}
+	}
+}
+
+func (t *DecisionTree) growNode(x [][]float64, y []float64, w []float64, indexes []int, depth int) *treeNode {
+	node := &treeNode{leaf: true}
+
+	positive := 0.0
+	for _, i := range indexes {
+		node.weight += w[i]
+		positive += w[i] * y[i]
+	}
+	if node.weight > 0 {
+		node.probability = positive / node.weight
+	}
+
+	if depth >= t.MaxDepth || node.weight < 2*t.MinLeaf || positive == 0 || positive == node.weight {
+		return node
+	}
+
+	//Features tried on this split
+	numFeatures := len(t.importance)
+	features := make([]int, numFeatures)
+	for f := range features {
+		features[f] = f
+	}
+	if t.MaxFeatures > 0 && t.MaxFeatures < numFeatures {
+		features = t.Random.Perm(numFeatures)[:t.MaxFeatures]
+	}
+
+	parentImpurity := node.weight * gini(positive, node.weight)
+	bestDecrease := 1e-12
+	bestFeature := -1
+	bestThreshold := 0.0
+
+	sorted := make([]int, len(indexes))
+	for _, f := range features {
+		copy(sorted, indexes)
+		sort.Sort(byFeature{indexes: sorted, x: x, feature: f})
+
+		leftWeight, leftPositive := 0.0, 0.0
+		for k := 0; k < len(sorted)-1; k++ {
+			i := sorted[k]
+			leftWeight += w[i]
+			leftPositive += w[i] * y[i]
+
+			//Only split between different values
+			current, next := x[i][f], x[sorted[k+1]][f]
+			if current == next {
+				continue
+			}
+
+			rightWeight := node.weight - leftWeight
+			if leftWeight < t.MinLeaf || rightWeight < t.MinLeaf {
+				continue
+			}
+
+			impurity := leftWeight*gini(leftPositive, leftWeight) + rightWeight*gini(positive-leftPositive, rightWeight)
+			if decrease := parentImpurity - impurity; decrease > bestDecrease {
+				bestDecrease = decrease
+				bestFeature = f
+				bestThreshold = (current + next) / 2.0
+			}
+		}
+	}
+
+	if bestFeature == -1 {
+		return node
+	}
+
+	var left, right []int
+	for _, i := range indexes {
+		if x[i][bestFeature] <= bestThreshold {
+			left = append(left, i)
+		} else {
+			right = append(right, i)
+		}
+	}
+
+	t.importance[bestFeature] += bestDecrease
+
+	node.leaf = false
+	node.feature = bestFeature
+	node.threshold = bestThreshold
+	node.left = t.growNode(x, y, w, left, depth+1)
+	node.right = t.growNode(x, y, w, right, depth+1)
+
+	return node
+}
+
+//Gini impurity 2p(1 - p) of a node with the given weights
+func gini(positive float64, total float64) float64 {
+	if total == 0 {
+		return 0.0
+	}
+
+	p := positive / total
+	return 2.0 * p * (1.0 - p)
+}
+
+func (t *DecisionTree) PredictProba(data DataPoint) (float64, error) {
+	return t.predictFeatures(t.encode(data)), nil
+}
+
+func (t *DecisionTree) predictFeatures(features []float64) float64 {
+	node := findLeaf(t.root, features)
+	if node == nil {
+		return 0.5
+	}
+
+	return node.probability
+}
+
+//Leaf of the tree reached by the features
+func findLeaf(node *treeNode, features []float64) *treeNode {
+	for node != nil && !node.leaf {
+		if features[node.feature] <= node.threshold {
+			node = node.left
+		} else {
+			node = node.right
+		}
+	}
+
+	return node
+}
+
+func (t *DecisionTree) Describe() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<div>Decision Tree with maximum depth ")
+	buffer.WriteString(strconv.Itoa(t.MaxDepth))
+	buffer.WriteString(" and minimum leaf weight ")
+	buffer.WriteString(strconv.FormatFloat(t.MinLeaf, 'f', 1, 64))
+	buffer.WriteString("</div>")
+
+	buffer.WriteString(t.nodeHTML(t.root, t.featureNames()))
+	buffer.WriteString(importanceHTML(t.featureNames(), t.importance))
+
+	return buffer.String()
+}
+
+//Nested list of the split rules
+func (t *DecisionTree) nodeHTML(node *treeNode, names []string) string {
+	if node == nil {
+		return ""
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteString("<ul>")
+
+	if node.leaf {
+		buffer.WriteString("<li>Retention probability ")
+		buffer.WriteString(strconv.FormatFloat(node.probability, 'f', 4, 64))
+		buffer.WriteString(" (weight ")
+		buffer.WriteString(strconv.FormatFloat(node.weight, 'f', 1, 64))
+		buffer.WriteString(")</li>")
+	} else {
+		rule := html.EscapeString(names[node.feature])
+		threshold := strconv.FormatFloat(node.threshold, 'f', 4, 64)
+
+		buffer.WriteString("<li>")
+		buffer.WriteString(rule)
+		buffer.WriteString(" &lt;= ")
+		buffer.WriteString(threshold)
+		buffer.WriteString(t.nodeHTML(node.left, names))
+		buffer.WriteString("</li>")
+
+		buffer.WriteString("<li>")
+		buffer.WriteString(rule)
+		buffer.WriteString(" &gt; ")
+		buffer.WriteString(threshold)
+		buffer.WriteString(t.nodeHTML(node.right, names))
+		buffer.WriteString("</li>")
+	}
+
+	buffer.WriteString("</ul>")
+
+	return buffer.String()
+}
+
+//Table of the normalized importance of every feature
+func importanceHTML(names []string, importance []float64) string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString("<br/><div><h3>Feature Importance</h3></div>")
+	buffer.WriteString("<table>")
+	buffer.WriteString("<tr>")
+	buffer.WriteString("<td>Name</td>")
+	buffer.WriteString("<td>Importance</td>")
+	buffer.WriteString("</tr>")
+
+	for f, name := range names {
+		buffer.WriteString("<tr>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(html.EscapeString(name))
+		buffer.WriteString("</td>")
+		buffer.WriteString("<td>")
+		buffer.WriteString(strconv.FormatFloat(importance[f], 'f', 4, 64))
+		buffer.WriteString("</td>")
+		buffer.WriteString("</tr>")
+	}
+
+	buffer.WriteString("</table>")
+
+	return buffer.String()
+}
+
+//Sort row indexes by the value of a feature
+type byFeature struct {
+	indexes []int
+	x       [][]float64
+	feature int
+}
+
+func (b byFeature) Len() int      { return len(b.indexes) }
+func (b byFeature) Swap(i, j int) { b.indexes[i], b.indexes[j] = b.indexes[j], b.indexes[i] }
+func (b byFeature) Less(i, j int) bool {
+	return b.x[b.indexes[i]][b.feature] < b.x[b.indexes[j]][b.feature]
+}
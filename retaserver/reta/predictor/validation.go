@@ -0,0 +1,162 @@
+package predictor
+
+import (
+	"math/rand"
+	"sort"
+)
+
+//Validation technique used to split players into training and testing data
+
+type ValidationMode int
+
+const (
+	HoldoutValidation         ValidationMode = iota //Single stratified split using dataset percentage
+	KFoldValidation                                 //Every player is tested once in k folds
+	StratifiedKFoldValidation                       //K folds keeping the retention ratio in every fold
+	TimeSplitValidation                             //Train on earlier players, test on later ones
+)
+
+func (m ValidationMode) String() string {
+	switch m {
+	case KFoldValidation:
+		return "K-Fold Cross-Validation"
+	case StratifiedKFoldValidation:
+		return "Stratified K-Fold Cross-Validation"
+	case TimeSplitValidation:
+		return "Time-Based Split"
+	default:
+		return "Stratified Holdout"
+	}
+}
+
+type Fold struct {
+	Training []PlayerInfo
+	Testing  []PlayerInfo
+}
+
+//Split players into folds according to the validation mode
+func (p *Predictor) createFolds(playerinfos []PlayerInfo, random *rand.Rand) []Fold {
+	switch p.validationMode {
+	case KFoldValidation, StratifiedKFoldValidation:
+		var folds []Fold
+		for repeat := 0; repeat < p.repeats; repeat++ {
+			folds = append(folds, p.kFolds(playerinfos, random)...)
+		}
+		return folds
+	case TimeSplitValidation:
+		return []Fold{p.timeSplit(playerinfos)}
+	default:
+		return []Fold{p.holdoutSplit(playerinfos, random)}
+	}
+}
+
+//Shuffle retained and not retained players separately, then take training percentage of each
+func (p *Predictor) holdoutSplit(playerinfos []PlayerInfo, random *rand.Rand) Fold {
+	var fold Fold
+
+	retented, notRetented := splitByRetention(playerinfos)
+	for _, group := range [][]int{retented, notRetented} {
+		shuffleIndexes(group, random)
+
+		trainingNum := int(float64(p.trainingDatasetPercentage) / 100.0 * float64(len(group)))
+		for i, index := range group {
+			if i < trainingNum {
+				fold.Training = append(fold.Training, playerinfos[index])
+			} else {
+				fold.Testing = append(fold.Testing, playerinfos[index])
+			}
+		}
+	}
+
+	//Mix both class again
+	shuffleInfos(fold.Training, random)
+	shuffleInfos(fold.Testing, random)
+
+	return fold
+}
+
+//Assign shuffled players to folds in turn, stratified mode deals each class separately
+func (p *Predictor) kFolds(playerinfos []PlayerInfo, random *rand.Rand) []Fold {
+	k := p.folds
+	if k < 2 {
+		k = 2
+	}
+
+	var groups [][]int
+	if p.validationMode == StratifiedKFoldValidation {
+		retented, notRetented := splitByRetention(playerinfos)
+		groups = [][]int{retented, notRetented}
+	} else {
+		all := make([]int, len(playerinfos))
+		for i := range all {
+			all[i] = i
+		}
+		groups = [][]int{all}
+	}
+
+	assignment := make([]int, len(playerinfos))
+	next := 0
+	for _, group := range groups {
+		shuffleIndexes(group, random)
+		for _, index := range group {
+			assignment[index] = next % k
+			next++
+		}
+	}
+
+	folds := make([]Fold, k)
+	for f := 0; f < k; f++ {
+		for i, info := range playerinfos {
+			if assignment[i] == f {
+				folds[f].Testing = append(folds[f].Testing, info)
+			} else {
+				folds[f].Training = append(folds[f].Training, info)
+			}
+		}
+	}
+
+	return folds
+}
+
+//Earliest players by first event date are the training data
+func (p *Predictor) timeSplit(playerinfos []PlayerInfo) Fold {
+	sorted := make([]PlayerInfo, len(playerinfos))
+	copy(sorted, playerinfos)
+	sort.Stable(byFirstDate(sorted))
+
+	trainingNum := int(float64(p.trainingDatasetPercentage) / 100.0 * float64(len(sorted)))
+
+	return Fold{Training: sorted[:trainingNum], Testing: sorted[trainingNum:]}
+}
+
+func splitByRetention(playerinfos []PlayerInfo) (retented []int, notRetented []int) {
+	for i, info := range playerinfos {
+		if info.Day1Retention {
+			retented = append(retented, i)
+		} else {
+			notRetented = append(notRetented, i)
+		}
+	}
+
+	return retented, notRetented
+}
+
+func shuffleIndexes(indexes []int, random *rand.Rand) {
+	for i := range indexes {
+		j := random.Intn(i + 1)
+		indexes[i], indexes[j] = indexes[j], indexes[i]
+	}
+}
+
+func shuffleInfos(infos []PlayerInfo, random *rand.Rand) {
+	for i := range infos {
+		j := random.Intn(i + 1)
+		infos[i], infos[j] = infos[j], infos[i]
+	}
+}
+
+type byFirstDate []PlayerInfo
+
+func (b byFirstDate) Len() int           { return len(b) }
+func (b byFirstDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byFirstDate) Less(i, j int) bool { return b[i].FirstDate.Before(b[j].FirstDate) }
@@ -1,15 +1,20 @@
 package reta
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"appengine"
 
+	"reta/analytics"
 	"reta/db"
+	"reta/errors"
 	"reta/predictor"
 )
 
@@ -18,6 +23,23 @@ func init() {
 	http.HandleFunc("/", rootHandler)
 	http.HandleFunc("/predict", predictHandler)
 	http.HandleFunc("/result", resultHandler)
+	http.HandleFunc("/survival", survivalHandler)
+	http.HandleFunc("/survivalresult", survivalresultHandler)
+	http.HandleFunc("/cohorts", cohortsHandler)
+	http.HandleFunc("/funnel", funnelHandler)
+	http.HandleFunc("/paths", pathsHandler)
+	http.HandleFunc("/player", playerSearchHandler)
+	http.HandleFunc("/player/", playerHandler)
+
+	//Handling connector debugging
+	http.HandleFunc("/debug/live", liveHandler)
+	http.HandleFunc("/debug/live/events", liveEventsHandler)
+
+	//Handling scheduled tasks
+	http.HandleFunc("/tasks/rollup", rollupHandler)
+	http.HandleFunc("/tasks/sessions", sessionsHandler)
+	http.HandleFunc("/tasks/livecleanup", livecleanupHandler)
+	http.HandleFunc("/tasks/score", scoreHandler)
 
 	http.HandleFunc("/oldresult", oldresultHandler)
 
@@ -29,11 +51,116 @@ func init() {
 
 var homeTemplate = template.Must(template.ParseFiles("reta/templates/index.html"))
 
+type homePage struct {
+	StartDate string
+	EndDate   string
+	Overview  template.HTML
+}
+
 func rootHandler(w http.ResponseWriter, r *http.Request) {
-	err := homeTemplate.Execute(w, "")
+	//Every other path falls back to this handler
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	//Create appengine context
+	c := appengine.NewContext(r)
+
+	//Set dates, last 30 days by default
+	now := time.Now().UTC()
+	layout := "02/01/2006"
+	page := homePage{StartDate: r.FormValue("startdate"), EndDate: r.FormValue("enddate")}
+	if page.EndDate == "" {
+		page.EndDate = now.Format(layout)
+	}
+	ending, err := time.Parse(layout, page.EndDate)
+	if err != nil {
+		http.Error(w, "Error: End date must be DD/MM/YYYY", http.StatusBadRequest)
+		return
+	}
+	if page.StartDate == "" {
+		page.StartDate = ending.AddDate(0, 0, -29).Format(layout)
+	}
+	beginning, err := time.Parse(layout, page.StartDate)
+	if err != nil {
+		http.Error(w, "Error: Start date must be DD/MM/YYYY", http.StatusBadRequest)
+		return
+	}
+
+	//Monthly active users need the rollups of the previous 29 days
+	rollups, rebuilt, err := analytics.GetRollups(c, beginning.AddDate(0, 0, -29), ending, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	c.Debugf("Rollups built from events: %v\n", rebuilt)
+
+	overview := analytics.ComputeOverview(rollups, beginning, ending)
+	page.Overview = template.HTML(overview.StringHTML())
+
+	err = homeTemplate.Execute(w, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Rollup task, run by cron so the overview reads stored rollups */
+
+func rollupHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	//Closed days of the last month are built and stored
+	now := time.Now().UTC()
+	_, rebuilt, err := analytics.GetRollups(c, now.AddDate(0, 0, -31), now.AddDate(0, 0, -3), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "ROLLUPS_BUILT %v", rebuilt)
+}
+
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	//Windows of the last 3 days overlap, so every session is whole in one of the daily runs
+	now := time.Now().UTC()
+	stored, err := analytics.StoreSessions(c, now.AddDate(0, 0, -3), now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Fprintf(w, "SESSIONS_STORED %v", stored)
+}
+
+//Churn risk of the players installed in the last day, from a model of the players of the last month
+func scoreHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	now := time.Now().UTC()
+	var predict predictor.Predictor
+	predict.SetInputDates(now.AddDate(0, 0, -30), now)
+	predict.SetDatasetPercentage(80, 20)
+	predict.SetScoring(true)
+	prediction := predict.RunPrediction(w, c)
+	c.Debugf("Scoring run:\n%v\n", prediction)
+
+	fmt.Fprintf(w, "PLAYERS_SCORED %v", predict.Scored())
+}
+
+func livecleanupHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	//Submissions are only kept for the live event viewer
+	removed, err := db.DeleteLiveEvents(c, time.Now().AddDate(0, 0, -1))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+
+	fmt.Fprintf(w, "LIVE_EVENTS_REMOVED %v", removed)
 }
 
 /* Prediction input page */
@@ -60,14 +187,176 @@ func resultHandler(w http.ResponseWriter, r *http.Request) {
 	beginning, _ := time.Parse(layout, r.FormValue("startdate"))
 	ending, _ := time.Parse(layout, r.FormValue("enddate"))
 
+	//Set classification method, comparison evaluates every method on the same folds
+	method := predictor.LogisticRegressionMethod
+	switch r.FormValue("method") {
+	case "tree":
+		method = predictor.DecisionTreeMethod
+	case "forest":
+		method = predictor.RandomForestMethod
+	case "naivebayes":
+		method = predictor.NaiveBayesMethod
+	case "boosting":
+		method = predictor.GradientBoostingMethod
+	}
+	compare := r.FormValue("compare") == "yes"
+
+	//Set churn stages, empty spec uses the default stages
+	var stages predictor.StageLabeller
+	if r.FormValue("outcome") == "stage" {
+		spec := strings.TrimSpace(r.FormValue("stages"))
+		if spec == "" {
+			spec = predictor.DefaultStages
+		}
+
+		var err error
+		stages, err = predictor.ParseStages(spec)
+		if err != nil {
+			err = resultTemplate.Execute(w, err.Error())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	//Set gradient boosting, zero means the default
+	boostingRounds, _ := strconv.ParseInt(r.FormValue("rounds"), 10, 32)
+	learningRate, _ := strconv.ParseFloat(r.FormValue("learningrate"), 64)
+	boostingDepth, _ := strconv.ParseInt(r.FormValue("depth"), 10, 32)
+
+	//Set player segmentation, zero segments picks k by silhouette
+	segmentation := r.FormValue("segmentation") != "" && r.FormValue("segmentation") != "none"
+	segmentCount := int64(0)
+	if r.FormValue("segmentation") == "fixed" {
+		segmentCount, _ = strconv.ParseInt(r.FormValue("segmentk"), 10, 32)
+	}
+
 	//Set iteration
 	iteration, _ := strconv.ParseInt((r.FormValue("iteration")), 10, 32)
 
+	//Set fitting technique
+	fitting := predictor.NewtonRaphsonFitting
+	switch r.FormValue("fitting") {
+	case "firth":
+		fitting = predictor.FirthFitting
+	case "sgd":
+		fitting = predictor.SGDFitting
+	case "lbfgs":
+		fitting = predictor.LBFGSFitting
+	}
+
+	//Set feature scaling
+	scaling := predictor.NoScaling
+	switch r.FormValue("scaling") {
+	case "zscore":
+		scaling = predictor.ZScoreScaling
+	case "minmax":
+		scaling = predictor.MinMaxScaling
+	}
+
+	//Set validation, empty seed means random on every run
+	validation := predictor.HoldoutValidation
+	switch r.FormValue("validation") {
+	case "kfold":
+		validation = predictor.KFoldValidation
+	case "stratified":
+		validation = predictor.StratifiedKFoldValidation
+	case "time":
+		validation = predictor.TimeSplitValidation
+	}
+	folds, _ := strconv.ParseInt(r.FormValue("folds"), 10, 32)
+	repeats, _ := strconv.ParseInt(r.FormValue("repeats"), 10, 32)
+	seed, _ := strconv.ParseInt(r.FormValue("seed"), 10, 64)
+
+	//Set bootstrap, zero samples disables it
+	bootstrap, _ := strconv.ParseInt(r.FormValue("bootstrap"), 10, 32)
+	interval := predictor.PercentileInterval
+	if r.FormValue("interval") == "bca" {
+		interval = predictor.BCaInterval
+	}
+
+	//Set feature selection
+	selection := predictor.NoSelection
+	switch r.FormValue("selection") {
+	case "forward":
+		selection = predictor.ForwardSelection
+	case "backward":
+		selection = predictor.BackwardSelection
+	case "lasso":
+		selection = predictor.LassoSelection
+	}
+	criterion := predictor.AICCriterion
+	if r.FormValue("criterion") == "lr" {
+		criterion = predictor.LikelihoodRatioCriterion
+	}
+
+	//Set multicollinearity handling
+	collinearityDrop := r.FormValue("collinearity") == "drop"
+
+	//Set class imbalance handling
+	imbalance := predictor.NoBalancing
+	switch r.FormValue("imbalance") {
+	case "weights":
+		imbalance = predictor.BalancedWeights
+	case "undersample":
+		imbalance = predictor.Undersampling
+	case "oversample":
+		imbalance = predictor.Oversampling
+	}
+
+	//Set missing value imputation
+	imputation := predictor.NoImputation
+	switch r.FormValue("imputation") {
+	case "mean":
+		imputation = predictor.MeanImputation
+	case "median":
+		imputation = predictor.MedianImputation
+	case "constant":
+		imputation = predictor.ConstantImputation
+	}
+	imputationConstant, _ := strconv.ParseFloat(r.FormValue("imputeconstant"), 64)
+
+	//Set outlier detection and treatment
+	outlierDetection := predictor.NoOutlierDetection
+	switch r.FormValue("outliers") {
+	case "iqr":
+		outlierDetection = predictor.IQROutliers
+	case "zscore":
+		outlierDetection = predictor.ZScoreOutliers
+	}
+	outlierTreatment := predictor.ReportOutliers
+	switch r.FormValue("treatment") {
+	case "clip":
+		outlierTreatment = predictor.ClipOutliers
+	case "winsorize":
+		outlierTreatment = predictor.WinsorizeOutliers
+	case "log":
+		outlierTreatment = predictor.LogTransformOutliers
+	}
+
 	//Run prediction
 	var predict predictor.Predictor
 	predict.SetInputDates(beginning, ending)
 	predict.SetDatasetPercentage(80, 20)
 	predict.SetIteration(int(iteration))
+	predict.SetMethod(method)
+	predict.SetComparison(compare)
+	predict.SetStages(stages)
+	predict.SetSegmentation(segmentation, int(segmentCount))
+	predict.SetBoosting(int(boostingRounds), learningRate, int(boostingDepth))
+	predict.SetFittingMode(fitting)
+	predict.SetScalingMode(scaling)
+	predict.SetFormula(strings.TrimSpace(r.FormValue("formula")))
+	predict.SetValidation(validation, int(folds), int(repeats))
+	predict.SetSeed(seed)
+	predict.SetBootstrap(int(bootstrap), interval)
+	predict.SetSelection(selection, criterion)
+	predict.SetCollinearityDrop(collinearityDrop)
+	predict.SetImbalance(imbalance)
+	predict.SetImputation(imputation, imputationConstant)
+	predict.SetOutlierHandling(outlierDetection, outlierTreatment)
+	predict.SetScoring(r.FormValue("score") == "yes")
 	prediction := predict.RunPrediction(w, c)
 
 	//Show prediction result on result page
@@ -77,6 +366,544 @@ func resultHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+/* Survival analysis input page */
+
+var survivalTemplate = template.Must(template.ParseFiles("reta/templates/survival.html"))
+
+func survivalHandler(w http.ResponseWriter, r *http.Request) {
+	err := survivalTemplate.Execute(w, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Survival analysis result page */
+
+func survivalresultHandler(w http.ResponseWriter, r *http.Request) {
+	//Create appengine context
+	c := appengine.NewContext(r)
+
+	//Set dates
+	layout := "02/01/2006"
+	beginning, _ := time.Parse(layout, r.FormValue("startdate"))
+	ending, _ := time.Parse(layout, r.FormValue("enddate"))
+
+	//Set churn window and Cox iteration, zero means the default
+	churnDays, _ := strconv.ParseInt(r.FormValue("churndays"), 10, 32)
+	iteration, _ := strconv.ParseInt(r.FormValue("iteration"), 10, 32)
+
+	//Set segment of the Kaplan-Meier curves
+	segment := predictor.NoSegment
+	switch r.FormValue("segment") {
+	case "version":
+		segment = predictor.VersionSegment
+	case "tutorial":
+		segment = predictor.TutorialSegment
+	}
+
+	//Run survival analysis
+	var survival predictor.SurvivalAnalysis
+	survival.SetInputDates(beginning, ending)
+	survival.SetChurnWindow(int(churnDays))
+	survival.SetSegment(segment)
+	survival.SetIteration(int(iteration))
+	result := survival.RunSurvival(c)
+
+	//Show survival result on result page
+	err := resultTemplate.Execute(w, template.HTML(result))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+//Dates of the analytics pages, the end date is included and the sample data is shown by default
+func formDates(r *http.Request) (string, string, time.Time, time.Time, error) {
+	startdate, enddate := r.FormValue("startdate"), r.FormValue("enddate")
+	if startdate == "" {
+		startdate = "17/02/2014"
+	}
+	if enddate == "" {
+		enddate = "28/02/2014"
+	}
+
+	layout := "02/01/2006"
+	beginning, err := time.Parse(layout, startdate)
+	if err != nil {
+		return startdate, enddate, beginning, beginning, errors.New("Error: Start date must be DD/MM/YYYY")
+	}
+	ending, err := time.Parse(layout, enddate)
+	if err != nil {
+		return startdate, enddate, beginning, ending, errors.New("Error: End date must be DD/MM/YYYY")
+	}
+
+	return startdate, enddate, beginning, ending, nil
+}
+
+/* Cohort retention page */
+
+var cohortsTemplate = template.Must(template.ParseFiles("reta/templates/cohorts.html"))
+
+type cohortsPage struct {
+	StartDate string
+	EndDate   string
+	Version   string
+	Days      int
+	Versions  []string
+	Matrix    template.HTML
+}
+
+func cohortsHandler(w http.ResponseWriter, r *http.Request) {
+	//Create appengine context
+	c := appengine.NewContext(r)
+
+	//Set dates, the end date is included
+	startdate, enddate, beginning, ending, err := formDates(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := cohortsPage{StartDate: startdate, EndDate: enddate, Version: r.FormValue("version")}
+
+	//Set last day shown
+	days, _ := strconv.ParseInt(r.FormValue("days"), 10, 32)
+	if days <= 0 {
+		days = 14
+	}
+	page.Days = int(days)
+
+	//Get events
+	var events []db.Event
+	err = db.GetAllEvents(c, beginning, ending.AddDate(0, 0, 1).Add(-time.Second), &events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	//Players who installed before the start date are not new in the date range
+	seen := make(map[string]bool)
+	var players []string
+	for _, event := range events {
+		if !seen[event.Player] {
+			seen[event.Player] = true
+			players = append(players, event.Player)
+		}
+	}
+
+	earlier, err := db.GetPlayersBefore(c, players, beginning)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cohorts := analytics.BuildCohorts(events, earlier, beginning, ending, page.Version, page.Days)
+
+	//Download as CSV instead of the page
+	if r.FormValue("format") == "csv" {
+		data, err := cohorts.CSV()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"cohorts.csv\"")
+		w.Write(data)
+		return
+	}
+
+	page.Versions = cohorts.Versions
+	page.Matrix = template.HTML(cohorts.StringHTML())
+
+	err = cohortsTemplate.Execute(w, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Conversion funnel page */
+
+var funnelTemplate = template.Must(template.ParseFiles("reta/templates/funnel.html"))
+
+type funnelPage struct {
+	StartDate string
+	EndDate   string
+	Steps     string
+	MaxGap    int //Minutes
+	Funnel    template.HTML
+}
+
+func funnelHandler(w http.ResponseWriter, r *http.Request) {
+	//Create appengine context
+	c := appengine.NewContext(r)
+
+	//Set dates, the end date is included
+	startdate, enddate, beginning, ending, err := formDates(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := funnelPage{StartDate: startdate, EndDate: enddate, Steps: r.FormValue("steps")}
+	if page.Steps == "" {
+		page.Steps = analytics.DefaultFunnel
+	}
+
+	//Set maximum time between steps
+	maxgap, _ := strconv.ParseInt(r.FormValue("maxgap"), 10, 32)
+	if maxgap <= 0 {
+		maxgap = 24 * 60
+	}
+	page.MaxGap = int(maxgap)
+
+	actions, err := analytics.ParseFunnel(page.Steps)
+	if err != nil {
+		page.Funnel = template.HTML("<div>" + template.HTMLEscapeString(err.Error()) + "</div>")
+	} else {
+		//Get events
+		last := ending.AddDate(0, 0, 1).Add(-time.Second)
+
+		var events []db.Event
+		err = db.GetAllEvents(c, beginning, last, &events)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var timedevents []db.TimedEvent
+		err = db.GetAllTimedEvents(c, beginning, last, &timedevents)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		funnel := analytics.BuildFunnel(events, timedevents, actions, time.Duration(page.MaxGap)*time.Minute)
+		page.Funnel = template.HTML(funnel.StringHTML())
+	}
+
+	err = funnelTemplate.Execute(w, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Path analysis page */
+
+var pathsTemplate = template.Must(template.ParseFiles("reta/templates/paths.html"))
+
+type pathsPage struct {
+	StartDate string
+	EndDate   string
+	Filter    string
+	From      string //"last" for the actions up to the last one
+	Top       int
+	Steps     int
+	Graph     template.HTML
+}
+
+func pathsHandler(w http.ResponseWriter, r *http.Request) {
+	//Create appengine context
+	c := appengine.NewContext(r)
+
+	//Set dates, the end date is included
+	startdate, enddate, beginning, ending, err := formDates(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := pathsPage{StartDate: startdate, EndDate: enddate, Filter: r.FormValue("filter"), From: r.FormValue("from")}
+
+	//Set players of the paths
+	filter := analytics.AllPaths
+	switch page.Filter {
+	case "retained":
+		filter = analytics.RetainedPaths
+	case "churned":
+		filter = analytics.ChurnedPaths
+	}
+
+	//Set top actions and steps
+	top, _ := strconv.ParseInt(r.FormValue("top"), 10, 32)
+	if top <= 0 {
+		top = 8
+	}
+	if top > analytics.MaxPathTop {
+		top = analytics.MaxPathTop
+	}
+	page.Top = int(top)
+	steps, _ := strconv.ParseInt(r.FormValue("steps"), 10, 32)
+	if steps <= 1 {
+		steps = 5
+	}
+	if steps > analytics.MaxPathSteps {
+		steps = analytics.MaxPathSteps
+	}
+	page.Steps = int(steps)
+
+	//Get events
+	last := ending.AddDate(0, 0, 1).Add(-time.Second)
+
+	var events []db.Event
+	err = db.GetAllEvents(c, beginning, last, &events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var timedevents []db.TimedEvent
+	err = db.GetAllTimedEvents(c, beginning, last, &timedevents)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	//Label players retained or churned
+	var infos []predictor.PlayerInfo
+	predictor.PlayersInformation(c, last, events, timedevents, &infos)
+
+	retained := make(map[string]bool)
+	for _, info := range infos {
+		retained[info.Name] = info.Day1Retention
+	}
+
+	graph := analytics.BuildPaths(events, timedevents, retained, filter, page.From == "last", page.Top, page.Steps)
+
+	//Download as JSON instead of the page
+	if r.FormValue("format") == "json" {
+		data, err := graph.JSON()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+		return
+	}
+
+	page.Graph = template.HTML(graph.StringHTML())
+
+	err = pathsTemplate.Execute(w, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Player explorer page */
+
+var playerTemplate = template.Must(template.ParseFiles("reta/templates/player.html"))
+
+type playerPage struct {
+	Player    string
+	Found     bool
+	Version   string
+	FirstDate string
+	LastDate  string
+	Retention string
+	Segment   string
+	Scored    bool
+	Risk      string
+	Method    string
+	ScoredAt  string
+	Features  template.HTML
+	Sessions  template.HTML
+	Timeline  template.HTML
+}
+
+//Search form of the home page
+func playerSearchHandler(w http.ResponseWriter, r *http.Request) {
+	page := url.URL{Path: "/player/" + r.FormValue("id")}
+	http.Redirect(w, r, page.String(), http.StatusFound)
+}
+
+func playerHandler(w http.ResponseWriter, r *http.Request) {
+	//Create appengine context
+	c := appengine.NewContext(r)
+
+	page := playerPage{Player: strings.TrimPrefix(r.URL.Path, "/player/")}
+	if page.Player == "" {
+		http.Error(w, "Error: Player id is empty", http.StatusBadRequest)
+		return
+	}
+
+	//Get every event of the player
+	var events []db.Event
+	err := db.GetPlayerEvents(c, page.Player, &events)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var timedevents []db.TimedEvent
+	err = db.GetPlayerTimedEvents(c, page.Player, &timedevents)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info, found := predictor.PlayerInformation(page.Player, events, timedevents)
+	if found {
+		layout := "02/01/2006 15:04:05"
+		page.Found = true
+		page.Version = info.Version
+		page.FirstDate = info.FirstDate.Format(layout)
+		page.LastDate = info.LastDate.Format(layout)
+		page.Features = template.HTML(info.StringHTML())
+
+		//Retention is only known a day after the first event
+		if info.Day1Retention {
+			page.Retention = "Retained"
+		} else if time.Now().Sub(info.FirstDate.AddDate(0, 0, 1)).Hours() < 0 {
+			page.Retention = "Not known yet"
+		} else {
+			page.Retention = "Not retained"
+		}
+
+		//Segment of the last prediction with segmentation
+		page.Segment, err = predictor.PlayerSegment(c, info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	page.Timeline = template.HTML(analytics.TimelineHTML(events, timedevents))
+
+	//Sessions stored by the sessions task
+	var sessions []db.Session
+	err = db.GetPlayerSessions(c, page.Player, &sessions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page.Sessions = template.HTML(analytics.SessionsHTML(sessions))
+
+	//Latest risk score of the last prediction
+	var score db.RiskScore
+	page.Scored, err = db.GetRiskScore(c, page.Player, &score)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if page.Scored {
+		page.Risk = strconv.FormatFloat(100.0*score.Risk, 'f', 2, 64)
+		page.Method = score.Method
+		page.ScoredAt = score.Scored.Format("02/01/2006 15:04:05")
+	}
+
+	err = playerTemplate.Execute(w, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+/* Live event viewer for connector debugging */
+
+var liveTemplate = template.Must(template.ParseFiles("reta/templates/live.html"))
+
+func liveHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	//Start recording before the first poll
+	err := db.StartLiveCapture(c, liveCapture)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = liveTemplate.Execute(w, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+const (
+	liveCapture = 2 * time.Minute  //Recording after the last poll
+	livePoll    = 20 * time.Second //Longest wait of a poll without new submissions
+	liveRecent  = 10 * time.Minute //Submissions shown when the viewer opens
+	liveSettle  = 2 * time.Second  //Submissions this recent may still be saved with an earlier time, left to the next poll
+)
+
+type liveEntry struct {
+	Received   string
+	Player     string
+	Version    string
+	Action     string
+	Date       string
+	Duration   string
+	Parameters []string
+	Payload    string
+	Error      string
+}
+
+type liveResponse struct {
+	Next   string //Received time of the last submission seen, in nanoseconds
+	Events []liveEntry
+}
+
+//Long-poll of the submissions received after the given time matching the filters
+func liveEventsHandler(w http.ResponseWriter, r *http.Request) {
+	c := appengine.NewContext(r)
+
+	//Keep recording while the viewer polls
+	err := db.StartLiveCapture(c, liveCapture)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	after := time.Now().Add(-liveRecent)
+	if nanos, err := strconv.ParseInt(r.FormValue("after"), 10, 64); err == nil {
+		after = time.Unix(0, nanos)
+	}
+	player, version, action := r.FormValue("player"), r.FormValue("version"), r.FormValue("action")
+
+	response := liveResponse{Events: []liveEntry{}}
+	deadline := time.Now().Add(livePoll)
+	for {
+		var lives []db.LiveEvent
+		err = db.GetLiveEvents(c, after, time.Now().Add(-liveSettle), 100, &lives)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		for _, live := range lives {
+			after = live.Received
+
+			//Submissions which could not be parsed may have no player or action, they are always shown
+			if !live.Unparsed && ((player != "" && live.Player != player) || (version != "" && live.Version != version) || (action != "" && live.Action != action)) {
+				continue
+			}
+
+			entry := liveEntry{Received: live.Received.Format("02/01/2006 15:04:05.000"), Player: live.Player, Version: live.Version,
+				Action: live.Action, Payload: live.Payload, Error: live.Error, Parameters: []string{}}
+			if !live.Date.IsZero() {
+				entry.Date = live.Date.Format("02/01/2006 15:04:05")
+			}
+			if live.Duration != 0 {
+				entry.Duration = live.Duration.String()
+			}
+			for _, parameter := range live.Parameters {
+				entry.Parameters = append(entry.Parameters, parameter.Key+" = "+parameter.Value)
+			}
+			response.Events = append(response.Events, entry)
+		}
+
+		if len(response.Events) > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	response.Next = strconv.FormatInt(after.UnixNano(), 10)
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 func oldresultHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Reta Server | Prediction Result\n")
 
@@ -117,7 +944,26 @@ func connectorHandler(w http.ResponseWriter, r *http.Request) {
 	c := appengine.NewContext(r)
 	formData := r.PostForm
 
-	err = db.SubmitEvent(c, formData.Get("userid"), formData.Get("appversion"), formData.Get("data"))
+	ev, duration, err := db.ParseEvent(formData.Get("userid"), formData.Get("appversion"), formData.Get("data"))
+	unparsed := err != nil
+	if err == nil {
+		err = db.PutEvent(c, ev, duration)
+	}
+
+	//Keep the submission while the live event viewer is open
+	if db.LiveCapture(c) {
+		live := db.LiveEvent{Received: time.Now(), Player: ev.Player, Version: ev.Version, Action: ev.Action, Date: ev.Date,
+			Duration: duration, Parameters: ev.Parameters, Payload: formData.Get("data"), Unparsed: unparsed}
+		if err != nil {
+			live.Error = err.Error()
+		}
+
+		liveErr := db.PutLiveEvent(c, live)
+		if liveErr != nil {
+			c.Warningf("Live event not saved: %v", liveErr)
+		}
+	}
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}